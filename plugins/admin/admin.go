@@ -1,10 +1,15 @@
 package admin
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/jirwin/quadlek/quadlek"
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
 	"github.com/slack-go/slack"
 	"go.uber.org/zap"
 )
@@ -25,6 +30,297 @@ func shutdown(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
 	}
 }
 
+func pluginStatus(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
+	for {
+		select {
+		case cmdMsg := <-cmdChannel:
+			statuses := cmdMsg.Bot.PluginStatuses()
+			if len(statuses) == 0 {
+				cmdMsg.Command.Reply() <- &quadlek.CommandResp{
+					Text: "All plugins are healthy.",
+				}
+				continue
+			}
+
+			sb := &strings.Builder{}
+			for _, status := range statuses {
+				state := "recovering"
+				if status.Degraded {
+					state = "degraded"
+				}
+				fmt.Fprintf(sb, "%s is %s: %d panics, last at %s: %s\n",
+					status.PluginId, state, status.Panics, status.LastPanicTime.Format(time.RFC3339), status.LastPanic)
+			}
+
+			cmdMsg.Command.Reply() <- &quadlek.CommandResp{
+				Text: sb.String(),
+			}
+
+		case <-ctx.Done():
+			zap.L().Info("Exiting plugin status command.")
+			return
+		}
+	}
+}
+
+var roleSpec = &cmdparse.Spec{
+	Name:        "role",
+	Description: "Grant, revoke and list the roles that permit using restricted commands.",
+	Subcommands: []*cmdparse.Spec{
+		{
+			Name:        "grant",
+			Description: "Grant a role to a user.",
+			Args: []cmdparse.Arg{
+				{Name: "user", Type: cmdparse.User, Description: "The user to grant the role to."},
+				{Name: "role", Type: cmdparse.String, Description: "The role to grant."},
+			},
+			Examples: []string{"/role grant @jirwin admin"},
+		},
+		{
+			Name:        "revoke",
+			Description: "Revoke a role from a user.",
+			Args: []cmdparse.Arg{
+				{Name: "user", Type: cmdparse.User, Description: "The user to revoke the role from."},
+				{Name: "role", Type: cmdparse.String, Description: "The role to revoke."},
+			},
+		},
+		{
+			Name:        "grant-group",
+			Description: "Grant a role to every member of a user group.",
+			Args: []cmdparse.Arg{
+				{Name: "group", Type: cmdparse.UserGroup, Description: "The user group to grant the role to."},
+				{Name: "role", Type: cmdparse.String, Description: "The role to grant."},
+			},
+			Examples: []string{"/role grant-group @oncall admin"},
+		},
+		{
+			Name:        "revoke-group",
+			Description: "Revoke a role from a user group.",
+			Args: []cmdparse.Arg{
+				{Name: "group", Type: cmdparse.UserGroup, Description: "The user group to revoke the role from."},
+				{Name: "role", Type: cmdparse.String, Description: "The role to revoke."},
+			},
+		},
+		{
+			Name:        "list",
+			Description: "List the users and user groups that have been granted roles.",
+		},
+	},
+}
+
+func roleCommand(ctx context.Context, cmdMsg *quadlek.CommandMsg) (*quadlek.CommandResp, error) {
+	args, err := roleSpec.Parse(cmdMsg.Command.Text)
+	if err != nil {
+		return &quadlek.CommandResp{Text: err.Error()}, nil
+	}
+
+	b := cmdMsg.Bot
+	role := args.String("role")
+	switch args.Subcommand() {
+	case "grant":
+		err = b.GrantUserRole(args.String("user"), role)
+	case "revoke":
+		err = b.RevokeUserRole(args.String("user"), role)
+	case "grant-group":
+		err = b.GrantGroupRole(args.String("group"), role)
+	case "revoke-group":
+		err = b.RevokeGroupRole(args.String("group"), role)
+	case "list":
+		return listRoles(b)
+	default:
+		return &quadlek.CommandResp{Text: "Usage: " + roleSpec.Usage()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &quadlek.CommandResp{Text: "Done."}, nil
+}
+
+func listRoles(b *quadlek.Bot) (*quadlek.CommandResp, error) {
+	grants, err := b.RoleGrants()
+	if err != nil {
+		return nil, err
+	}
+	if len(grants) == 0 {
+		return &quadlek.CommandResp{Text: "No roles have been granted."}, nil
+	}
+
+	sb := &strings.Builder{}
+	for _, grant := range grants {
+		if grant.Group {
+			fmt.Fprintf(sb, "<!subteam^%s>: %s\n", grant.Id, strings.Join(grant.Roles, ", "))
+		} else {
+			fmt.Fprintf(sb, "<@%s>: %s\n", grant.Id, strings.Join(grant.Roles, ", "))
+		}
+	}
+
+	return &quadlek.CommandResp{Text: sb.String()}, nil
+}
+
+var scheduleSpec = &cmdparse.Spec{
+	Name:        "schedule",
+	Description: "List, cancel and resume the scheduled tasks of plugins.",
+	Subcommands: []*cmdparse.Spec{
+		{
+			Name:        "list",
+			Description: "List every scheduled task and when it next runs.",
+		},
+		{
+			Name:        "cancel",
+			Description: "Stop a task from running until it is resumed.",
+			Args: []cmdparse.Arg{
+				{Name: "plugin", Type: cmdparse.String, Description: "The id of the plugin the task belongs to."},
+				{Name: "task", Type: cmdparse.String, Description: "The name of the task."},
+			},
+		},
+		{
+			Name:        "resume",
+			Description: "Resume a cancelled task.",
+			Args: []cmdparse.Arg{
+				{Name: "plugin", Type: cmdparse.String, Description: "The id of the plugin the task belongs to."},
+				{Name: "task", Type: cmdparse.String, Description: "The name of the task."},
+			},
+		},
+	},
+}
+
+func scheduleCommand(ctx context.Context, cmdMsg *quadlek.CommandMsg) (*quadlek.CommandResp, error) {
+	args, err := scheduleSpec.Parse(cmdMsg.Command.Text)
+	if err != nil {
+		return &quadlek.CommandResp{Text: err.Error()}, nil
+	}
+
+	b := cmdMsg.Bot
+	switch args.Subcommand() {
+	case "cancel":
+		err = b.CancelScheduledTask(args.String("plugin"), args.String("task"))
+	case "resume":
+		err = b.ResumeScheduledTask(args.String("plugin"), args.String("task"))
+	case "list":
+		return listScheduledTasks(b), nil
+	default:
+		return &quadlek.CommandResp{Text: "Usage: " + scheduleSpec.Usage()}, nil
+	}
+	if err != nil {
+		return &quadlek.CommandResp{Text: err.Error()}, nil
+	}
+
+	return &quadlek.CommandResp{Text: "Done."}, nil
+}
+
+func listScheduledTasks(b *quadlek.Bot) *quadlek.CommandResp {
+	tasks := b.ScheduledTasks()
+	if len(tasks) == 0 {
+		return &quadlek.CommandResp{Text: "There are no scheduled tasks."}
+	}
+
+	sb := &strings.Builder{}
+	for _, task := range tasks {
+		fmt.Fprintf(sb, "%s %s: ", task.PluginId, task.Name)
+		switch {
+		case task.Cancelled:
+			sb.WriteString("cancelled")
+		case task.Next.IsZero():
+			sb.WriteString("finished")
+		default:
+			fmt.Fprintf(sb, "next run at %s", task.Next.Format(time.RFC3339))
+		}
+		if !task.LastRun.IsZero() {
+			fmt.Fprintf(sb, ", last ran at %s", task.LastRun.Format(time.RFC3339))
+		}
+		if task.LastError != "" {
+			fmt.Fprintf(sb, ", last error: %s", task.LastError)
+		}
+		sb.WriteString("\n")
+	}
+
+	return &quadlek.CommandResp{Text: sb.String()}
+}
+
+var backupSpec = &cmdparse.Spec{
+	Name:        "backup",
+	Description: "Back up and export the bot's database.",
+	Subcommands: []*cmdparse.Spec{
+		{
+			Name:        "now",
+			Description: "Take a backup now, in the configured backup directory.",
+		},
+		{
+			Name:        "list",
+			Description: "List the backups in the backup directory.",
+		},
+		{
+			Name:        "export",
+			Description: "Send yourself a JSON export of a plugin's data.",
+			Args: []cmdparse.Arg{
+				{Name: "plugin", Type: cmdparse.String, Description: "The id of the plugin to export."},
+			},
+			Examples: []string{"/backup export infobot"},
+		},
+	},
+}
+
+func backupCommand(ctx context.Context, cmdMsg *quadlek.CommandMsg) (*quadlek.CommandResp, error) {
+	args, err := backupSpec.Parse(cmdMsg.Command.Text)
+	if err != nil {
+		return &quadlek.CommandResp{Text: err.Error()}, nil
+	}
+
+	b := cmdMsg.Bot
+	switch args.Subcommand() {
+	case "now":
+		path, err := b.Backup()
+		if err != nil {
+			return &quadlek.CommandResp{Text: err.Error()}, nil
+		}
+		return &quadlek.CommandResp{Text: fmt.Sprintf("Backed up to %s.", path)}, nil
+
+	case "list":
+		backups, err := b.Backups()
+		if err != nil {
+			return nil, err
+		}
+		if len(backups) == 0 {
+			return &quadlek.CommandResp{Text: "There are no backups."}, nil
+		}
+		return &quadlek.CommandResp{Text: strings.Join(backups, "\n")}, nil
+
+	case "export":
+		return exportPlugin(ctx, b, cmdMsg.Command.UserId, args.String("plugin"))
+
+	default:
+		return &quadlek.CommandResp{Text: "Usage: " + backupSpec.Usage()}, nil
+	}
+}
+
+// exportPlugin sends a plugin's export to the user that asked for it. Exports can hold tokens, so they are never posted in a channel.
+func exportPlugin(ctx context.Context, b *quadlek.Bot, userId, pluginId string) (*quadlek.CommandResp, error) {
+	buf := &bytes.Buffer{}
+	err := b.Export(buf, pluginId)
+	if err != nil {
+		return nil, err
+	}
+
+	dm, _, _, err := b.GetApi().OpenConversationContext(ctx, &slack.OpenConversationParameters{Users: []string{userId}})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = b.GetApi().UploadFileContext(ctx, slack.FileUploadParameters{
+		Content:  buf.String(),
+		Filetype: "json",
+		Filename: pluginId + ".json",
+		Title:    fmt.Sprintf("%s export", pluginId),
+		Channels: []string{dm.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &quadlek.CommandResp{Text: "Sent you the export."}, nil
+}
+
 func restartInteraction(ctx context.Context, interactionChannel <-chan *quadlek.InteractionMsg) {
 	for {
 		select {
@@ -63,7 +359,7 @@ func restartInteraction(ctx context.Context, interactionChannel <-chan *quadlek.
 					},
 					CallbackID: "restart-confirm-modal",
 				}
-				_, err := scMsg.Bot.OpenView(scMsg.Interaction.TriggerID, r)
+				_, err := scMsg.Bot.OpenViewContext(scMsg.Context(), scMsg.Interaction.TriggerID, r)
 				if err != nil {
 					zap.L().Error("error opening view", zap.Error(err))
 					continue
@@ -104,7 +400,11 @@ func Register(adminChannel string) quadlek.Plugin {
 	return quadlek.MakePlugin(
 		"admin",
 		[]quadlek.Command{
-			quadlek.MakeCommand("shutdown", shutdown),
+			quadlek.MakeCommand("shutdown", shutdown, quadlek.WithDescription("Shut the bot down."), quadlek.WithRoles(quadlek.AdminRole)),
+			quadlek.MakeCommand("pluginstatus", pluginStatus, quadlek.WithDescription("Show plugins that have panicked."), quadlek.WithRoles(quadlek.AdminRole)),
+			quadlek.CommandFunc("role", roleCommand, quadlek.WithSpec(roleSpec), quadlek.WithRoles(quadlek.AdminRole)),
+			quadlek.CommandFunc("schedule", scheduleCommand, quadlek.WithSpec(scheduleSpec), quadlek.WithRoles(quadlek.AdminRole)),
+			quadlek.CommandFunc("backup", backupCommand, quadlek.WithSpec(backupSpec), quadlek.WithRoles(quadlek.AdminRole)),
 		},
 		nil,
 		nil,
@@ -119,7 +419,7 @@ func RegisterInteraction() quadlek.InteractionPlugin {
 	return quadlek.MakeInteractionPlugin(
 		"restart-quadlek",
 		[]quadlek.Interaction{
-			quadlek.MakeInteraction("restart", restartInteraction),
+			quadlek.MakeInteraction("restart", restartInteraction, quadlek.WithRoles(quadlek.AdminRole)),
 		},
 	)
 }
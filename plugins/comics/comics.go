@@ -6,14 +6,11 @@ import (
 	v1 "github.com/jirwin/quadlek/pb/quadlek/plugins/comics/v1"
 	"html"
 	"math/rand"
-	"strconv"
-	"strings"
-
-	"go.uber.org/zap"
-	"google.golang.org/protobuf/proto"
+	"time"
 
 	"github.com/jirwin/comics/src/comics"
 	"github.com/jirwin/quadlek/quadlek"
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
 )
 
 var (
@@ -21,60 +18,46 @@ var (
 	fontPath string
 )
 
-func addComicTemplate(templateUrl string, cmdMsg *quadlek.CommandMsg) error {
-	err := cmdMsg.Store.GetAndUpdate("templates", func(templatesProto []byte) ([]byte, error) {
-		templates := &v1.Templates{}
-		err := proto.Unmarshal(templatesProto, templates)
-		if err != nil {
-			return nil, err
-		}
+var comicSpec = &cmdparse.Spec{
+	Name:        "comic",
+	Description: "Render a comic from the recent messages in the channel.",
+	Subcommands: []*cmdparse.Spec{
+		{
+			Name:        "list",
+			Description: "List the configured templates.",
+		},
+		{
+			Name:        "del",
+			Description: "Delete a template.",
+			Args:        []cmdparse.Arg{{Name: "id", Type: cmdparse.Int, Description: "The id of the template, from /comic list."}},
+		},
+		{
+			Name:        "load",
+			Description: "Add a template.",
+			Args:        []cmdparse.Arg{{Name: "url", Type: cmdparse.URL, Description: "The url of the template image."}},
+		},
+	},
+}
 
+func addComicTemplate(templateUrl string, cmdMsg *quadlek.CommandMsg) error {
+	return quadlek.UpdateProto(cmdMsg.Store, "templates", func(templates *v1.Templates) error {
 		templates.Urls = append(templates.Urls, templateUrl)
-
-		updatedTemplates, err := proto.Marshal(templates)
-		if err != nil {
-			return nil, err
-		}
-
-		return updatedTemplates, nil
+		return nil
 	})
-
-	return err
 }
 
 func listTemplates(cmdMsg *quadlek.CommandMsg) ([]string, error) {
-	var templateUrls []string
-
-	err := cmdMsg.Store.Get("templates", func(templateProto []byte) error {
-		templates := &v1.Templates{}
-		err := proto.Unmarshal(templateProto, templates)
-		if err != nil {
-			return err
-		}
-		templateUrls = templates.Urls
-		return nil
-	})
-
+	templates, err := quadlek.GetProto[*v1.Templates](cmdMsg.Store, "templates")
 	if err != nil {
 		return nil, err
 	}
 
-	return templateUrls, nil
+	return templates.Urls, nil
 }
 
-func delComicTemplate(templateId string, cmdMsg *quadlek.CommandMsg) error {
-	return cmdMsg.Store.GetAndUpdate("templates", func(templateProto []byte) ([]byte, error) {
-		templates := &v1.Templates{}
-		err := proto.Unmarshal(templateProto, templates)
-		if err != nil {
-			return nil, err
-		}
+func delComicTemplate(tId int, cmdMsg *quadlek.CommandMsg) error {
+	return quadlek.UpdateProto(cmdMsg.Store, "templates", func(templates *v1.Templates) error {
 		var newTemplateUrls []string
-		tId, err := strconv.Atoi(templateId)
-		if err != nil {
-			return nil, err
-		}
-
 		for i, url := range templates.Urls {
 			if i != tId {
 				newTemplateUrls = append(newTemplateUrls, url)
@@ -82,12 +65,7 @@ func delComicTemplate(templateId string, cmdMsg *quadlek.CommandMsg) error {
 		}
 
 		templates.Urls = newTemplateUrls
-		templateBytes, err := proto.Marshal(templates)
-		if err != nil {
-			return nil, err
-		}
-
-		return templateBytes, nil
+		return nil
 	})
 }
 
@@ -95,198 +73,109 @@ func formatLogMsg(text string) string {
 	return html.UnescapeString(text)
 }
 
-func pickAndRenderTemplate(cmdMsg *quadlek.CommandMsg) (string, error) {
-	comicUrl := ""
+func pickAndRenderTemplate(ctx context.Context, cmdMsg *quadlek.CommandMsg) (string, error) {
+	tracer := cmdMsg.Bot.Tracer()
 
-	err := cmdMsg.Store.Get("templates", func(templatesProto []byte) error {
-		templates := &v1.Templates{}
-		err := proto.Unmarshal(templatesProto, templates)
-		if err != nil {
-			return err
-		}
+	templates, err := quadlek.GetProto[*v1.Templates](cmdMsg.Store, "templates")
+	if err != nil {
+		return "", err
+	}
 
-		if len(templates.Urls) == 0 {
-			return fmt.Errorf("error: no configured templates")
-		}
+	if len(templates.Urls) == 0 {
+		return "", fmt.Errorf("error: no configured templates")
+	}
 
-		template := templates.Urls[rand.Intn(len(templates.Urls))]
-		comic, err := comics.NewTemplate(template, fontPath)
-		if err != nil {
-			return err
-		}
+	template := templates.Urls[rand.Intn(len(templates.Urls))]
+	_, span := tracer.Start(ctx, "comic.template")
+	comic, err := comics.NewTemplate(template, fontPath)
+	quadlek.EndSpan(span, err)
+	if err != nil {
+		return "", err
+	}
+
+	historyCtx, span := tracer.Start(ctx, "comic.history")
+	msgs, err := cmdMsg.Bot.GetMessageLogContext(historyCtx, cmdMsg.Command.ChannelId, quadlek.MessageLotOpts{
+		SkipAttachments: true,
+	})
+	quadlek.EndSpan(span, err)
+	if err != nil {
+		return "", err
+	}
+
+	if len(msgs) < len(comic.Bubbles) {
+		return "", fmt.Errorf("not enough channel history for this comic")
+	}
 
-		msgs, err := cmdMsg.Bot.GetMessageLog(cmdMsg.Command.ChannelId, quadlek.MessageLotOpts{
-			SkipAttachments: true,
-		})
+	var comicTxt []string
+	for i := len(comic.Bubbles) - 1; i >= 0; i-- {
+		comicTxt = append(comicTxt, formatLogMsg(msgs[i].Text))
+	}
+
+	_, span = tracer.Start(ctx, "comic.render")
+	imgBytes, err := comic.Render(comicTxt)
+	quadlek.EndSpan(span, err)
+	if err != nil {
+		return "", err
+	}
+
+	_, span = tracer.Start(ctx, "comic.upload")
+	comicUrl, err := comics.ImgurUpload(imgBytes, clientId)
+	quadlek.EndSpan(span, err)
+
+	return comicUrl, err
+}
+
+func comicCommand(ctx context.Context, cmdMsg *quadlek.CommandMsg) (*quadlek.CommandResp, error) {
+	args, err := comicSpec.Parse(cmdMsg.Command.Text)
+	if err != nil {
+		return &quadlek.CommandResp{Text: err.Error()}, nil
+	}
+
+	switch args.Subcommand() {
+	case "list":
+		templates, err := listTemplates(cmdMsg)
 		if err != nil {
-			return err
+			return nil, quadlek.UserError("error listing template")
 		}
 
-		if len(msgs) < len(comic.Bubbles) {
-			return fmt.Errorf("not enough channel history for this comic")
+		if len(templates) == 0 {
+			return &quadlek.CommandResp{Text: "There are no templates configured."}, nil
 		}
 
-		var comicTxt []string
-		for i := len(comic.Bubbles) - 1; i >= 0; i-- {
-			comicTxt = append(comicTxt, formatLogMsg(msgs[i].Text))
+		msgText := "Configured templates:\n"
+		for i, template := range templates {
+			msgText += fmt.Sprintf("%d. %s\n", i, template)
 		}
 
-		imgBytes, err := comic.Render(comicTxt)
+		return &quadlek.CommandResp{Text: msgText}, nil
+
+	case "del":
+		err := delComicTemplate(args.Int("id"), cmdMsg)
 		if err != nil {
-			return err
+			return nil, quadlek.UserError("error deleting template: %s", err.Error())
 		}
 
-		comicUrl, err = comics.ImgurUpload(imgBytes, clientId)
+		return &quadlek.CommandResp{Text: "Successfully deleted template " + args.String("id")}, nil
+
+	case "load":
+		err := addComicTemplate(args.String("url"), cmdMsg)
 		if err != nil {
-			return err
+			return nil, quadlek.UserError("error adding template: %s", err.Error())
 		}
 
-		return nil
-	})
-	if err != nil {
-		return "", err
+		return &quadlek.CommandResp{Text: "Successfully added template " + args.String("url")}, nil
 	}
 
-	return comicUrl, nil
-}
-
-func comicCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
-	for {
-		select {
-		case cmdMsg := <-cmdChannel:
-			cmdMsg.Command.Reply() <- nil
-
-			if cmdMsg.Command.Text != "" {
-				split := strings.Split(cmdMsg.Command.Text, " ")
-				switch split[0] {
-				case "list":
-					templates, err := listTemplates(cmdMsg)
-					if err != nil {
-						err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-							Text:      "error listing template",
-							InChannel: false,
-						})
-						if err != nil {
-							return
-						}
-						continue
-					}
-
-					if len(templates) == 0 {
-						err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-							Text:      "There are no templates configured.",
-							InChannel: false,
-						})
-						if err != nil {
-							return
-						}
-						continue
-					}
-
-					msgText := "Configured templates:\n"
-					for i, template := range templates {
-						msgText += fmt.Sprintf("%d. %s\n", i, template)
-					}
-
-					err = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-						Text:      msgText,
-						InChannel: false,
-					})
-					if err != nil {
-						return
-					}
-
-				case "del":
-					if len(split) != 2 {
-						err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-							Text:      "You must provide the id of the template to delete.",
-							InChannel: false,
-						})
-						if err != nil {
-							return
-						}
-						continue
-					}
-					err := delComicTemplate(split[1], cmdMsg)
-					if err != nil {
-						err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-							Text:      fmt.Sprintf("error deleting template: %s", err.Error()),
-							InChannel: false,
-						})
-						if err != nil {
-							return
-						}
-						continue
-					}
-
-					err = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-						Text:      "Successfully deleted template " + split[1],
-						InChannel: false,
-					})
-					if err != nil {
-						return
-					}
-
-				case "load":
-					if len(split) != 2 {
-						err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-							Text:      "You must provide the url of a template to load.",
-							InChannel: false,
-						})
-						if err != nil {
-							return
-						}
-						continue
-					}
-					err := addComicTemplate(split[1], cmdMsg)
-					if err != nil {
-						err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-							Text:      fmt.Sprintf("error adding template: %s", err.Error()),
-							InChannel: false,
-						})
-						if err != nil {
-							return
-						}
-						continue
-					}
-
-					err = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-						Text:      "Successfully added template " + split[1],
-						InChannel: false,
-					})
-					if err != nil {
-						return
-					}
-				}
-
-				continue
-			}
-
-			comicUrl, err := pickAndRenderTemplate(cmdMsg)
-			if err != nil {
-				err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-					Text:      fmt.Sprintf("error rendering template: %s", err.Error()),
-					InChannel: false,
-				})
-				if err != nil {
-					return
-				}
-				continue
-			}
-
-			err = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-				Text:      fmt.Sprintf("<@%s> made a new comic: %s", cmdMsg.Command.UserId, comicUrl),
-				InChannel: true,
-			})
-			if err != nil {
-				return
-			}
-
-		case <-ctx.Done():
-			zap.L().Info("Exiting comic command.")
-			return
-		}
+	// Rendering usually takes longer than Slack waits for a reply, in which case the comic is sent to the response url.
+	comicUrl, err := pickAndRenderTemplate(ctx, cmdMsg)
+	if err != nil {
+		return nil, quadlek.UserError("error rendering template: %s", err.Error())
 	}
+
+	return &quadlek.CommandResp{
+		Text:      fmt.Sprintf("<@%s> made a new comic: %s", cmdMsg.Command.UserId, comicUrl),
+		InChannel: true,
+	}, nil
 }
 
 func Register(imgurClientId, comicFontPath string) quadlek.Plugin {
@@ -296,11 +185,18 @@ func Register(imgurClientId, comicFontPath string) quadlek.Plugin {
 	return quadlek.MakePlugin(
 		"comics",
 		[]quadlek.Command{
-			quadlek.MakeCommand("comic", comicCommand),
+			quadlek.CommandFunc("comic", comicCommand,
+				quadlek.WithSpec(comicSpec),
+				quadlek.WithRateLimit(quadlek.RateLimit{
+					PerUser:    quadlek.Rate{Burst: 2, Every: time.Minute},
+					PerChannel: quadlek.Rate{Burst: 5, Every: time.Minute},
+				}),
+			),
 		},
 		nil,
 		nil,
 		nil,
 		nil,
+		quadlek.WithProtoKeys(quadlek.ProtoKey{Prefix: "templates", Message: &v1.Templates{}}),
 	)
 }
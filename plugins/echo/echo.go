@@ -3,58 +3,33 @@ package echo
 import (
 	"context"
 
-	"go.uber.org/zap"
-
 	"fmt"
 
 	"github.com/jirwin/quadlek/quadlek"
 )
 
-func echoCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
-	for {
-		select {
-		case cmdMsg := <-cmdChannel:
-			cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-				Text: cmdMsg.Command.Text,
-			}
-		case <-ctx.Done():
-			zap.L().Info("Exiting echo command")
-			return
-		}
-	}
+func echoCommand(ctx context.Context, cmdMsg *quadlek.CommandMsg) (*quadlek.CommandResp, error) {
+	return &quadlek.CommandResp{
+		Text: cmdMsg.Command.Text,
+	}, nil
 }
 
-func echoHook(ctx context.Context, hookChannel <-chan *quadlek.HookMsg) {
-	for {
-		select {
-		case hookMsg := <-hookChannel:
-			hookMsg.Bot.Respond(hookMsg.Msg, fmt.Sprintf("echo: %s", hookMsg.Msg.Text))
-		case <-ctx.Done():
-			zap.L().Info("Exiting echo hook")
-			return
-		}
-	}
+func echoHook(ctx context.Context, hookMsg *quadlek.HookMsg) error {
+	hookMsg.Bot.RespondContext(ctx, hookMsg.Msg, fmt.Sprintf("echo: %s", hookMsg.Msg.Text))
+	return nil
 }
 
-func echoReactionHook(ctx context.Context, reactionChannel <-chan *quadlek.ReactionHookMsg) {
-	for {
-		select {
-		case rh := <-reactionChannel:
-			rh.Bot.Say(rh.Reaction.Item.Channel, fmt.Sprintf("<@%s> added a reaction! :%s:", rh.Reaction.User, rh.Reaction.Reaction))
-
-		case <-ctx.Done():
-			zap.L().Info("Exiting echo reaction hook")
-			return
-		}
-	}
+func echoReactionHook(ctx context.Context, rh *quadlek.ReactionHookMsg) error {
+	rh.Bot.SayContext(ctx, rh.Reaction.Item.Channel, fmt.Sprintf("<@%s> added a reaction! :%s:", rh.Reaction.User, rh.Reaction.Reaction))
+	return nil
 }
 
 func Register() quadlek.Plugin {
 	return quadlek.MakePlugin(
 		"echo",
-		[]quadlek.Command{quadlek.MakeCommand("echo", echoCommand)},
-		[]quadlek.Hook{quadlek.MakeHook(echoHook)},
-		[]quadlek.ReactionHook{quadlek.MakeReactionHook(echoReactionHook)},
+		[]quadlek.Command{quadlek.CommandFunc("echo", echoCommand, quadlek.WithDescription("Repeat the text back."), quadlek.WithUsage("<text...>"))},
+		[]quadlek.Hook{quadlek.HookFunc(echoHook)},
+		[]quadlek.ReactionHook{quadlek.ReactionHookFunc(echoReactionHook)},
 		nil,
 		nil,
 	)
@@ -4,17 +4,17 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
-	"net/url"
 	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/segmentio/fasthash/fnv1a"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 
 	v1 "github.com/jirwin/quadlek/pb/quadlek/plugins/gifs/v1"
 	"github.com/jirwin/quadlek/quadlek"
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
+	"github.com/jirwin/quadlek/quadlek/storage"
 )
 
 var gifs *Gifs
@@ -24,6 +24,9 @@ const (
 	BadBotReaction  = "bad-bot"
 )
 
+// urlTTL is how long a posted gif can be reacted to, to allow or block it for its phrase.
+const urlTTL = 30 * 24 * time.Hour
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
@@ -36,35 +39,6 @@ func newReply(phrase string, url string) *v1.Reply {
 	}
 }
 
-func saveAlias(bkt *bolt.Bucket, alias *v1.Alias) error {
-	out, err := proto.Marshal(alias)
-	if err != nil {
-		return err
-	}
-
-	err = bkt.Put([]byte(getAliasName(alias.Phrase)), out)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func getAlias(bkt *bolt.Bucket, phrase string) (*v1.Alias, bool, error) {
-	out := bkt.Get(getAliasName(phrase))
-
-	if out == nil {
-		return nil, false, nil
-	}
-
-	a, err := parseAlias(out)
-	if err != nil {
-		return nil, false, err
-	}
-
-	return a, true, nil
-}
-
 func parseAlias(b []byte) (*v1.Alias, error) {
 	a := &v1.Alias{}
 	err := proto.Unmarshal(b, a)
@@ -115,77 +89,72 @@ func getAliasName(phrase string) []byte {
 	return []byte(fmt.Sprintf("alias:%d", fnv1a.HashString64(phrase)))
 }
 
-func appendUrl(bkt *bolt.Bucket, phrase string, url string, block bool, forceNew bool) error {
-	var alias *v1.Alias
-	var ok bool
-	var err error
-
+func appendUrl(bkt storage.Bucket, phrase string, url string, block bool, forceNew bool) error {
+	alias := &v1.Alias{}
 	if !forceNew {
-		alias, ok, err = getAlias(bkt, phrase)
+		var err error
+		alias, err = quadlek.GetBucketProto[*v1.Alias](bkt, string(getAliasName(phrase)))
 		if err != nil {
 			return err
 		}
 	}
+	alias.Phrase = phrase
 
 	reply := newReply(phrase, url)
-
-	if ok {
-		if block {
-			alias.Blocked = diffAppendReply(reply, alias.Blocked)
-		} else {
-			alias.Allowed = diffAppendReply(reply, alias.Allowed)
-		}
+	if block {
+		alias.Blocked = diffAppendReply(reply, alias.Blocked)
 	} else {
-		alias = &v1.Alias{
-			Phrase: phrase,
-		}
-		if block {
-			alias.Blocked = diffAppendReply(reply, alias.Blocked)
-		} else {
-			alias.Allowed = diffAppendReply(reply, alias.Allowed)
-		}
-	}
-	err = saveAlias(bkt, alias)
-	if err != nil {
-		return err
+		alias.Allowed = diffAppendReply(reply, alias.Allowed)
 	}
 
-	return nil
+	return quadlek.PutBucketProto(bkt, string(getAliasName(phrase)), alias)
 }
 
-func gifLoad(bot *quadlek.Bot, store *quadlek.Store) error {
-	migrated := false
-	err := store.Get("meta:v2Migration", func(b []byte) error {
-		if b != nil {
-			migrated = true
-			return nil
+// gifsV2 moves the urls saved for each phrase into aliases, which can also hold blocked urls.
+var gifsV2 = quadlek.Migration{
+	Version:     1,
+	Description: "Move saved urls into aliases",
+	Migrate: func(bkt storage.Bucket) error {
+		// Buckets migrated before the plugin used versioned migrations are marked with meta:v2Migration.
+		marker, err := bkt.Get([]byte("meta:v2Migration"))
+		if err != nil {
+			return err
+		}
+		if marker != nil {
+			return bkt.Delete([]byte("meta:v2Migration"))
 		}
 
-		return nil
-	})
-	if migrated {
-		return nil
-	}
+		// Collect the v1 phrases first, the bucket can't be modified while it is scanned.
+		phrases := make(map[string]string)
+		err = bkt.Scan(nil, func(key []byte, value []byte) error {
+			k := string(key)
+			if !strings.HasPrefix(k, "url:") && !strings.HasPrefix(k, "alias:") && !strings.HasPrefix(k, "meta:") {
+				phrases[k] = string(value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 
-	err = store.ForEach(func(bkt *bolt.Bucket, key string, value []byte) error {
-		if !strings.HasPrefix(key, "url:") && !strings.HasPrefix(key, "alias:") && !strings.HasPrefix(key, "meta:") {
-			err = appendUrl(bkt, key, string(value), false, true)
+		for phrase, url := range phrases {
+			err = appendUrl(bkt, phrase, url, false, true)
 			if err != nil {
 				return err
 			}
 		}
-		if err != nil {
-			return err
-		}
 
 		return nil
-	})
-	if err != nil {
-		return err
-	}
+	},
+}
 
-	err = store.Update("meta:v2Migration", []byte("true"))
-	return nil
+// gifsV3 expires the urls posted before they were stored with a TTL.
+var gifsV3 = quadlek.Migration{
+	Version:     2,
+	Description: "Expire posted urls",
+	Migrate: func(bkt storage.Bucket) error {
+		return quadlek.ExpireExistingKeys(bkt, "url:", urlTTL)
+	},
 }
 
 func pickReply(replies []*v1.Reply) *v1.Reply {
@@ -200,79 +169,83 @@ func pickReply(replies []*v1.Reply) *v1.Reply {
 	return replies[rand.Intn(len(replies))]
 }
 
+// countLookup counts where a gif for /g came from: a saved gif, or giphy.
+func countLookup(bot *quadlek.Bot, source string) {
+	bot.Counter("gifs", "lookups_total", "Gifs looked up with /g, by whether a saved gif was used or giphy was searched.", "source").WithLabelValues(source).Inc()
+}
+
+// replyWithGif replies to a /g command with a gif for text, preferring the gifs allowed by its alias and skipping blocked ones.
+// It returns the url of the gif if it was translated.
+func replyWithGif(cmdMsg *quadlek.CommandMsg, text string, alias *v1.Alias) string {
+	if len(alias.Allowed) > 0 {
+		countLookup(cmdMsg.Bot, "saved")
+		reply := pickReply(alias.Allowed)
+		if reply != nil {
+			cmdMsg.Command.Reply() <- &quadlek.CommandResp{
+				Text:      reply.Url,
+				InChannel: true,
+			}
+		}
+		return ""
+	}
+
+	countLookup(cmdMsg.Bot, "giphy")
+	var gifUrl string
+	attempts := 0
+	for attempts < 10 {
+		attempts++
+		gUrl, err := gifs.Translate(text)
+		if err != nil {
+			cmdMsg.Command.Reply() <- &quadlek.CommandResp{
+				Text:      fmt.Sprintf("an error occured: %s", err.Error()),
+				InChannel: false,
+			}
+			return ""
+		}
+
+		if replyMatch(gUrl, alias.Blocked) {
+			continue
+		}
+
+		gifUrl = gUrl
+		break
+	}
+
+	if gifUrl != "" {
+		cmdMsg.Command.Reply() <- &quadlek.CommandResp{
+			Text:      gifUrl,
+			InChannel: true,
+		}
+	} else {
+		cmdMsg.Command.Reply() <- &quadlek.CommandResp{
+			Text:      "unable to load unblocked gif url",
+			InChannel: false,
+		}
+	}
+
+	return gifUrl
+}
+
 func gifCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
 	for {
 		select {
 		case cmdMsg := <-cmdChannel:
 			text := strings.TrimPrefix(cmdMsg.Command.Text, "url:")
 			if text != "" {
-				var gifUrl string
-				var err error
-				err = cmdMsg.Store.Get(string(getAliasName(text)), func(v []byte) error {
-					var alias *v1.Alias
-					if v != nil {
-						alias = &v1.Alias{}
-						err = proto.Unmarshal(v, alias)
-						if err != nil {
-							return err
-						}
-					}
-
-					if alias != nil {
-						if len(alias.Allowed) > 0 {
-							reply := pickReply(alias.Allowed)
-							if reply != nil {
-								cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-									Text:      reply.Url,
-									InChannel: true,
-								}
-							}
-							return nil
-						}
-					}
-
-					attempts := 0
-					for attempts < 10 {
-						attempts++
-						gUrl, err := gifs.Translate(text)
-						if err != nil {
-							cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-								Text:      fmt.Sprintf("an error occured: %s", err.Error()),
-								InChannel: false,
-							}
-							return nil
-						}
-
-						if alias != nil {
-							if replyMatch(gUrl, alias.Blocked) {
-								continue
-							}
-						}
-
-						gifUrl = gUrl
-						break
-					}
-
-					if gifUrl != "" {
-						cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-							Text:      gifUrl,
-							InChannel: true,
-						}
-					} else {
-						cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-							Text:      "unable to load unblocked gif url",
-							InChannel: false,
-						}
-					}
-
-					return nil
-				})
+				alias, err := quadlek.GetProto[*v1.Alias](cmdMsg.Store, string(getAliasName(text)))
 				if err != nil {
+					zap.L().Error("error loading gif alias", zap.Error(err))
+					cmdMsg.Command.Reply() <- &quadlek.CommandResp{
+						Text:      "Sorry, I was unable to load the gifs saved for that phrase.",
+						InChannel: false,
+					}
 					continue
 				}
 
+				gifUrl := replyWithGif(cmdMsg, text, alias)
+
 				if gifUrl != "" {
-					err = cmdMsg.Store.Update(fmt.Sprintf("url:%s", gifUrl), []byte(text))
+					err = cmdMsg.Store.PutWithTTL(fmt.Sprintf("url:%s", gifUrl), []byte(text), urlTTL)
 					if err != nil {
 						zap.L().Error("error updating store with gif url", zap.Error(err))
 					}
@@ -286,32 +259,33 @@ func gifCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
 	}
 }
 
+var gifSaveSpec = &cmdparse.Spec{
+	Name:        "gsave",
+	Description: "Save a gif for a phrase.",
+	Args: []cmdparse.Arg{
+		{Name: "url", Type: cmdparse.URL, Description: "The url of the gif."},
+		{Name: "phrase", Type: cmdparse.Rest, Description: "The phrase to save the gif for."},
+	},
+	Examples: []string{"/gsave https://media.giphy.com/media/l0MYt5jPR6QX5pnqM/giphy.gif mind blown"},
+}
+
 func gifSaveCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
 	for {
 		select {
 		case cmdMsg := <-cmdChannel:
-			text := cmdMsg.Command.Text
-			parts := strings.Split(text, " ")
-			if len(parts) < 2 {
-				cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-					Text:      "Malformed command: /gsave <url> phrase to save",
-					InChannel: false,
-				}
-				continue
-			}
-
-			gUrl, err := url.Parse(parts[0])
+			args, err := gifSaveSpec.Parse(cmdMsg.Command.Text)
 			if err != nil {
 				cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-					Text:      fmt.Sprintf("Invalid url: %s", parts[1]),
+					Text:      err.Error(),
 					InChannel: false,
 				}
 				continue
 			}
 
-			phrase := strings.Join(parts[1:], " ")
+			gUrl := args.URL("url")
+			phrase := args.String("phrase")
 
-			err = cmdMsg.Store.UpdateRaw(func(bkt *bolt.Bucket) error {
+			err = cmdMsg.Store.Transaction(func(bkt storage.Bucket) error {
 				return appendUrl(bkt, phrase, gUrl.String(), false, false)
 			})
 			if err != nil {
@@ -333,23 +307,48 @@ func gifSaveCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg)
 	}
 }
 
+// glistPageSize is how many phrases /glist shows at a time.
+const glistPageSize = 20
+
+var gifListSpec = &cmdparse.Spec{
+	Name:        "glist",
+	Description: "List the saved gif phrases.",
+	Args: []cmdparse.Arg{
+		{Name: "page", Type: cmdparse.String, Optional: true, Description: "Where to continue listing from, given at the end of the previous page."},
+	},
+}
+
 func gifListCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
 	for {
 		select {
 		case cmdMsg := <-cmdChannel:
-			sb := &strings.Builder{}
-			err := cmdMsg.Store.ForEach(func(_ *bolt.Bucket, key string, value []byte) error {
-				if len(value) == 0 {
-					return nil
-				}
+			args, err := gifListSpec.Parse(cmdMsg.Command.Text)
+			if err != nil {
+				cmdMsg.Command.Reply() <- &quadlek.CommandResp{Text: err.Error()}
+				continue
+			}
+
+			startAfter := ""
+			if args.Has("page") {
+				startAfter = "alias:" + args.String("page")
+			}
+			kvs, next, err := cmdMsg.Store.Scan("alias:", startAfter, glistPageSize)
+			if err != nil {
+				zap.L().Error("error listing gif aliases", zap.Error(err))
+				cmdMsg.Command.Reply() <- &quadlek.CommandResp{Text: "Sorry, I was unable to list the saved gifs."}
+				continue
+			}
 
-				if !strings.HasPrefix(key, "alias:") {
-					return nil
+			sb := &strings.Builder{}
+			for _, kv := range kvs {
+				if len(kv.Value) == 0 {
+					continue
 				}
 
-				a, err := parseAlias(value)
+				a, err := parseAlias(kv.Value)
 				if err != nil {
-					return err
+					zap.L().Error("error parsing gif alias", zap.String("key", kv.Key), zap.Error(err))
+					continue
 				}
 
 				fmt.Fprintf(sb, "%s =>\n", a.Phrase)
@@ -365,13 +364,9 @@ func gifListCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg)
 						fmt.Fprintf(sb, "\t\t%s\n", r.Url)
 					}
 				}
-				if err != nil {
-					return err
-				}
-				return nil
-			})
-			if err != nil {
-				continue
+			}
+			if next != "" {
+				fmt.Fprintf(sb, "More: /glist %s\n", strings.TrimPrefix(next, "alias:"))
 			}
 
 			if sb.Len() > 0 {
@@ -387,81 +382,46 @@ func gifListCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg)
 	}
 }
 
-func gifReaction(ctx context.Context, reactionChannel <-chan *quadlek.ReactionHookMsg) {
-	for {
-		select {
-		case rh := <-reactionChannel:
-			switch rh.Reaction.Reaction {
-			case GoodBotReaction:
-				msg, err := rh.Bot.GetMessage(rh.Reaction.Item.Channel, rh.Reaction.Item.Timestamp)
-				if err != nil {
-					fmt.Println("error getting message:", err.Error())
-					continue
-				}
-
-				if msg.User != "" {
-					continue
-				}
-
-				gifUrl := strings.TrimPrefix(msg.Text, "<")
-				gifUrl = strings.TrimSuffix(gifUrl, ">")
-
-				err = rh.Store.UpdateRaw(func(bkt *bolt.Bucket) error {
-					b := bkt.Get([]byte(fmt.Sprintf("url:%s", gifUrl)))
-					if b == nil {
-						return nil
-					}
-
-					err = appendUrl(bkt, string(b), gifUrl, false, false)
-					if err != nil {
-						return err
-					}
-
-					return nil
-				})
-				if err != nil {
-					rh.Bot.Say(rh.Reaction.Item.Channel, "Error saving gif")
-					continue
-				}
-
-			case BadBotReaction:
-				msg, err := rh.Bot.GetMessage(rh.Reaction.Item.Channel, rh.Reaction.Item.Timestamp)
-				if err != nil {
-					fmt.Println("error getting message:", err.Error())
-					continue
-				}
-
-				if msg.User != "" {
-					continue
-				}
-
-				gifUrl := strings.TrimPrefix(msg.Text, "<")
-				gifUrl = strings.TrimSuffix(gifUrl, ">")
+func gifReaction(ctx context.Context, rh *quadlek.ReactionHookMsg) error {
+	var block bool
+	switch rh.Reaction.Reaction {
+	case GoodBotReaction:
+		block = false
+	case BadBotReaction:
+		block = true
+	default:
+		return nil
+	}
 
-				err = rh.Store.UpdateRaw(func(bkt *bolt.Bucket) error {
-					b := bkt.Get([]byte(fmt.Sprintf("url:%s", gifUrl)))
-					if b == nil {
-						return nil
-					}
+	msg, err := rh.Bot.GetMessageContext(ctx, rh.Reaction.Item.Channel, rh.Reaction.Item.Timestamp)
+	if err != nil {
+		return fmt.Errorf("error getting message: %w", err)
+	}
 
-					err = appendUrl(bkt, string(b), gifUrl, true, false)
-					if err != nil {
-						return err
-					}
+	if msg.User != "" {
+		return nil
+	}
 
-					return nil
-				})
-				if err != nil {
-					rh.Bot.Say(rh.Reaction.Item.Channel, "Error saving gif")
-					continue
-				}
-			}
+	gifUrl := strings.TrimPrefix(msg.Text, "<")
+	gifUrl = strings.TrimSuffix(gifUrl, ">")
 
-		case <-ctx.Done():
-			fmt.Println("Shutting down gif react hook")
-			return
+	err = rh.Store.Transaction(func(bkt storage.Bucket) error {
+		b, err := bkt.Get([]byte(fmt.Sprintf("url:%s", gifUrl)))
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return nil
 		}
+
+		return appendUrl(bkt, string(b), gifUrl, block, false)
+	})
+	if err != nil {
+		rh.Bot.SayContext(ctx, rh.Reaction.Item.Channel, "Error saving gif")
+		return err
 	}
+
+	return nil
 }
 
 func Register(apiKey string) quadlek.Plugin {
@@ -469,15 +429,25 @@ func Register(apiKey string) quadlek.Plugin {
 	return quadlek.MakePlugin(
 		"gifs",
 		[]quadlek.Command{
-			quadlek.MakeCommand("g", gifCommand),
-			quadlek.MakeCommand("gsave", gifSaveCommand),
-			quadlek.MakeCommand("glist", gifListCommand),
+			quadlek.MakeCommand("g", gifCommand,
+				quadlek.WithDescription("Post a gif for a phrase, using a saved gif if there is one."),
+				quadlek.WithUsage("<phrase...>"),
+				quadlek.WithExamples("/g mind blown"),
+				quadlek.WithRateLimit(quadlek.RateLimit{
+					PerUser: quadlek.Rate{Burst: 5, Every: 30 * time.Second},
+					Global:  quadlek.Rate{Burst: 30, Every: 10 * time.Second},
+				}),
+			),
+			quadlek.MakeCommand("gsave", gifSaveCommand, quadlek.WithSpec(gifSaveSpec)),
+			quadlek.MakeCommand("glist", gifListCommand, quadlek.WithSpec(gifListSpec)),
 		},
 		nil,
 		[]quadlek.ReactionHook{
-			quadlek.MakeReactionHook(gifReaction),
+			quadlek.ReactionHookFunc(gifReaction),
 		},
 		nil,
-		gifLoad,
+		nil,
+		quadlek.WithMigrations(gifsV2, gifsV3),
+		quadlek.WithProtoKeys(quadlek.ProtoKey{Prefix: "alias:", Message: &v1.Alias{}}),
 	)
 }
@@ -11,18 +11,21 @@ import (
 	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/google/go-github/github"
 	uuid "github.com/satori/go.uuid"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 	githuboauth "golang.org/x/oauth2/github"
-	"google.golang.org/protobuf/proto"
 
 	v1 "github.com/jirwin/quadlek/pb/quadlek/plugins/github/v1"
 	"github.com/jirwin/quadlek/quadlek"
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
+	"github.com/jirwin/quadlek/quadlek/storage"
 )
 
+// authStateTTL is how long a user has to finish authenticating to Github.
+const authStateTTL = 15 * time.Minute
+
 var (
 	scopes       = []string{"user:email", "repo"}
 	clientId     string
@@ -62,7 +65,8 @@ func startAuthFlow(stateId string) string {
 	return conf.AuthCodeURL(stateId)
 }
 
-func authFlow(cmdMsg *quadlek.CommandMsg, bkt *bolt.Bucket) error {
+// authFlow starts authenticating the user to Github, and returns the response asking them to do so.
+func authFlow(cmdMsg *quadlek.CommandMsg, bkt storage.Bucket) (*quadlek.CommandResp, error) {
 	stateId := uuid.NewV4().String()
 	authUrl := startAuthFlow(stateId)
 
@@ -70,31 +74,21 @@ func authFlow(cmdMsg *quadlek.CommandMsg, bkt *bolt.Bucket) error {
 		Id:          stateId,
 		UserId:      cmdMsg.Command.UserId,
 		ResponseUrl: cmdMsg.Command.ResponseUrl,
-		ExpireTime:  time.Now().Unix() + int64(time.Minute*15),
+		ExpireTime:  time.Now().Add(authStateTTL).Unix(),
 	}
 
-	authStateBytes, err := proto.Marshal(authState)
-	if err != nil {
-		zap.L().Error("error marshalling auth state", zap.Error(err))
-		return err
+	err := quadlek.PutBucketProto(bkt, "authstate-"+stateId, authState)
+	if err == nil {
+		err = quadlek.ExpireKey(bkt, "authstate-"+stateId, authStateTTL)
 	}
-
-	err = bkt.Put([]byte("authstate-"+stateId), authStateBytes)
 	if err != nil {
-		cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-			Text: "There was an error authenticating to Github",
-		}
-		return err
+		zap.L().Error("error saving auth state", zap.Error(err))
+		return nil, quadlek.UserError("There was an error authenticating to Github")
 	}
 
-	err = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
+	return &quadlek.CommandResp{
 		Text: fmt.Sprintf("You need to authenticate to Github to continue. Please visit %s to do this.", authUrl),
-	})
-	if err != nil {
-		return err
-	}
-
-	return nil
+	}, nil
 }
 
 func getGithubClient(ctx context.Context, authToken *v1.AuthToken) (*github.Client, bool) {
@@ -115,214 +109,158 @@ func getGithubClient(ctx context.Context, authToken *v1.AuthToken) (*github.Clie
 	return client, false
 }
 
-func issueCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
-	for {
-		select {
-		case cmdMsg := <-cmdChannel:
-			cmdMsg.Command.Reply() <- nil
-
-			msg := strings.SplitN(cmdMsg.Command.Text, " ", 2)
-			if len(msg) != 2 {
-				_ = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-					Text: "You must provide a repo and issue title. ex: /issue jirwin/quadlek Make me better!",
-				})
-				continue
-			}
+var issueSpec = &cmdparse.Spec{
+	Name:        "issue",
+	Description: "Create a GitHub issue.",
+	Args: []cmdparse.Arg{
+		{Name: "repo", Type: cmdparse.String, Description: "The repo to create the issue in, e.g. jirwin/quadlek. The org can be omitted if a default is configured."},
+		{Name: "title", Type: cmdparse.Rest, Description: "The title of the issue."},
+	},
+	Examples: []string{"/issue jirwin/quadlek Make me better!"},
+}
 
-			var owner string
-			var repo string
-			repoParts := strings.Split(msg[0], "/")
-			if len(repoParts) == 2 {
-				owner = repoParts[0]
-				repo = repoParts[1]
-			} else if len(repoParts) == 1 {
-				if defaultOwner == "" {
-					_ = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-						Text: "You didn't specify an org for the repo, and no default org was defined.",
-					})
-				}
-				owner = defaultOwner
-				repo = repoParts[0]
-			}
+func issueCommand(ctx context.Context, cmdMsg *quadlek.CommandMsg) (*quadlek.CommandResp, error) {
+	args, err := issueSpec.Parse(cmdMsg.Command.Text)
+	if err != nil {
+		return &quadlek.CommandResp{Text: err.Error()}, nil
+	}
 
-			title := msg[1]
-			if title == "" {
-				_ = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-					Text: "You must provide a title.",
-				})
-				continue
-			}
+	var owner string
+	var repo string
+	repoParts := strings.Split(args.String("repo"), "/")
+	if len(repoParts) == 2 {
+		owner = repoParts[0]
+		repo = repoParts[1]
+	} else if len(repoParts) == 1 {
+		if defaultOwner == "" {
+			return &quadlek.CommandResp{
+				Text: "You didn't specify an org for the repo, and no default org was defined.",
+			}, nil
+		}
+		owner = defaultOwner
+		repo = repoParts[0]
+	}
 
-			err := cmdMsg.Store.UpdateRaw(func(bkt *bolt.Bucket) error {
-				authToken := &v1.AuthToken{}
-				authTokenBytes := bkt.Get([]byte("authtoken-" + cmdMsg.Command.UserId))
-				err := proto.Unmarshal(authTokenBytes, authToken)
-				if err != nil {
-					zap.L().Error("error unmarshalling auth token", zap.Error(err))
-					return err
-				}
-
-				if authToken.Token == nil {
-					err = authFlow(cmdMsg, bkt)
-					if err != nil {
-						zap.L().Error("error during auth flow", zap.Error(err))
-						return err
-					}
-					return nil
-				}
-
-				if authToken.GithubUser == "" {
-					err = authFlow(cmdMsg, bkt)
-					if err != nil {
-						zap.L().Error("error during authflow", zap.Error(err))
-						return err
-					}
-					return nil
-				}
-
-				client, needsReauth := getGithubClient(ctx, authToken)
-				if needsReauth {
-					err = authFlow(cmdMsg, bkt)
-					if err != nil {
-						return err
-					}
-					return nil
-				}
-
-				body := fmt.Sprintf("%s created this issue from slack", authToken.GithubUser)
-				issue, _, err := client.Issues.Create(ctx, owner, repo, &github.IssueRequest{
-					Title: &title,
-					Body:  &body,
-				})
-				if err != nil {
-					zap.L().Error("Error creating issue.", zap.Error(err))
-					return err
-				}
-
-				_ = cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{
-					Text:      fmt.Sprintf("%s created a new issue: %s", authToken.GithubUser, issue.GetHTMLURL()),
-					InChannel: true,
-				})
-
-				return nil
-			})
+	title := args.String("title")
 
-			if err != nil {
-				continue
-			}
+	var resp *quadlek.CommandResp
+	err = cmdMsg.Store.Transaction(func(bkt storage.Bucket) error {
+		authToken, err := quadlek.GetBucketSealedProto[*v1.AuthToken](bkt, "authtoken-"+cmdMsg.Command.UserId)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling auth token: %w", err)
+		}
+
+		if authToken.Token == nil || authToken.GithubUser == "" {
+			resp, err = authFlow(cmdMsg, bkt)
+			return err
+		}
+
+		client, needsReauth := getGithubClient(ctx, authToken)
+		if needsReauth {
+			resp, err = authFlow(cmdMsg, bkt)
+			return err
+		}
 
-		case <-ctx.Done():
-			zap.L().Info("Exiting github command")
-			return
+		body := fmt.Sprintf("%s created this issue from slack", authToken.GithubUser)
+		issue, _, err := client.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+			Title: &title,
+			Body:  &body,
+		})
+		if err != nil {
+			return fmt.Errorf("error creating issue: %w", err)
 		}
+
+		resp = &quadlek.CommandResp{
+			Text:      fmt.Sprintf("%s created a new issue: %s", authToken.GithubUser, issue.GetHTMLURL()),
+			InChannel: true,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	return resp, nil
 }
 
-func githubAuthorizeWebhook(ctx context.Context, whChannel <-chan *quadlek.WebhookMsg) {
-	var err error
-	for {
-		select {
-		case whMsg := <-whChannel:
-			// respond to webhook
-			whMsg.ResponseWriter.WriteHeader(http.StatusOK)
-			_, err = whMsg.ResponseWriter.Write([]byte{})
+func githubAuthorizeWebhook(ctx context.Context, whMsg *quadlek.WebhookMsg) error {
+	// respond to webhook
+	whMsg.ResponseWriter.WriteHeader(http.StatusOK)
+	_, err := whMsg.ResponseWriter.Write([]byte{})
+	if err != nil {
+		return err
+	}
+
+	// process webhook
+	state := whMsg.Request.FormValue("state")
+	whMsg.Request.Body.Close()
+
+	err = whMsg.Store.Transaction(func(bkt storage.Bucket) error {
+		authState, err := quadlek.GetBucketProto[*v1.AuthState](bkt, "authstate-"+state)
+		if err != nil {
+			_ = whMsg.Bot.RespondToSlashCommandContext(ctx, authState.GetResponseUrl(), &quadlek.CommandResp{
+				Text: "Sorry! There was an error logging you into Github.",
+			})
+			return err
+		}
+
+		now := time.Now().Unix()
+		if authState.ExpireTime < now {
+			err := bkt.Delete([]byte("authstate-" + state))
 			if err != nil {
-				continue
+				return err
 			}
-			whMsg.Done <- true
-
-			// process webhook
-			state := whMsg.Request.FormValue("state")
-			whMsg.Request.Body.Close()
-
-			err = whMsg.Store.UpdateRaw(func(bkt *bolt.Bucket) error {
-				authStateBytes := bkt.Get([]byte("authstate-" + state))
-				authState := &v1.AuthState{}
-				err = proto.Unmarshal(authStateBytes, authState)
-				if err != nil {
-					_ = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
-						Text: "Sorry! There was an error logging you into Github.",
-					})
-					return err
-				}
-
-				now := time.Now().Unix()
-				if authState.ExpireTime < now {
-					err := bkt.Delete([]byte("authstate-" + state))
-					if err != nil {
-						return err
-					}
-					err = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
-						Text: "Sorry! There was an error logging you into Github.",
-					})
-					if err != nil {
-						return err
-					}
-					return errors.New("received expired auth request")
-				}
-
-				if state != authState.Id {
-					zap.L().Error("invalid oauth state", zap.String("expected", authState.Id), zap.String("actual", state))
-					return errors.New("received invalid oauth state")
-				}
-
-				oauthConfig := getGithubOauthConfig()
-
-				code := whMsg.Request.FormValue("code")
-				token, err := oauthConfig.Exchange(ctx, code)
-				if err != nil {
-					zap.L().Error("oauth exchange failed", zap.Error(err))
-					return err
-				}
-
-				oauthClient := oauthConfig.Client(ctx, token)
-				client := github.NewClient(oauthClient)
-				user, _, err := client.Users.Get(ctx, "")
-				if err != nil {
-					zap.L().Error("failed to get user", zap.Error(err))
-					return err
-				}
-
-				authToken := &v1.AuthToken{}
-				PopulateFromOauthToken(authToken, token)
-				authToken.Scopes = scopes
-				authToken.GithubUser = user.GetLogin()
-
-				tokenBytes, err := proto.Marshal(authToken)
-				if err != nil {
-					return err
-				}
-				err = bkt.Put([]byte("authtoken-"+authState.UserId), tokenBytes)
-				if err != nil {
-					err = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
-						Text: "Sorry! There was an error logging you into Github.",
-					})
-					if err != nil {
-						return err
-					}
-					zap.L().Error("error storing auth token.", zap.Error(err))
-					return err
-				}
-
-				err = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
-					Text: fmt.Sprintf("Successfully logged into Github as %s. Try your command again please.", authToken.GithubUser),
-				})
-				if err != nil {
-					return err
-				}
-
-				return nil
+			err = whMsg.Bot.RespondToSlashCommandContext(ctx, authState.ResponseUrl, &quadlek.CommandResp{
+				Text: "Sorry! There was an error logging you into Github.",
 			})
 			if err != nil {
-				zap.L().Error("error authenticating to github", zap.Error(err))
-				continue
+				return err
 			}
+			return errors.New("received expired auth request")
+		}
 
-		case <-ctx.Done():
-			zap.L().Info("Exiting github authorize command")
-			return
+		if state != authState.Id {
+			zap.L().Error("invalid oauth state", zap.String("expected", authState.Id), zap.String("actual", state))
+			return errors.New("received invalid oauth state")
 		}
+
+		oauthConfig := getGithubOauthConfig()
+
+		code := whMsg.Request.FormValue("code")
+		token, err := oauthConfig.Exchange(ctx, code)
+		if err != nil {
+			return fmt.Errorf("oauth exchange failed: %w", err)
+		}
+
+		oauthClient := oauthConfig.Client(ctx, token)
+		client := github.NewClient(oauthClient)
+		user, _, err := client.Users.Get(ctx, "")
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		authToken := &v1.AuthToken{}
+		PopulateFromOauthToken(authToken, token)
+		authToken.Scopes = scopes
+		authToken.GithubUser = user.GetLogin()
+
+		err = quadlek.PutBucketSealedProto(bkt, "authtoken-"+authState.UserId, authToken)
+		if err != nil {
+			_ = whMsg.Bot.RespondToSlashCommandContext(ctx, authState.ResponseUrl, &quadlek.CommandResp{
+				Text: "Sorry! There was an error logging you into Github.",
+			})
+			return fmt.Errorf("error storing auth token: %w", err)
+		}
+
+		return whMsg.Bot.RespondToSlashCommandContext(ctx, authState.ResponseUrl, &quadlek.CommandResp{
+			Text: fmt.Sprintf("Successfully logged into Github as %s. Try your command again please.", authToken.GithubUser),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error authenticating to github: %w", err)
 	}
+
+	return nil
 }
 
 func Register(id, secret, owner string) quadlek.Plugin {
@@ -332,10 +270,22 @@ func Register(id, secret, owner string) quadlek.Plugin {
 
 	return quadlek.MakePlugin(
 		"github",
-		[]quadlek.Command{quadlek.MakeCommand("issue", issueCommand)},
+		[]quadlek.Command{quadlek.CommandFunc("issue", issueCommand, quadlek.WithSpec(issueSpec))},
 		nil,
 		nil,
-		[]quadlek.Webhook{quadlek.MakeWebhook("githubAuthorize", githubAuthorizeWebhook)},
+		[]quadlek.Webhook{quadlek.WebhookFunc("githubAuthorize", githubAuthorizeWebhook)},
 		nil,
+		quadlek.WithProtoKeys(
+			quadlek.ProtoKey{Prefix: "authtoken-", Message: &v1.AuthToken{}},
+			quadlek.ProtoKey{Prefix: "authstate-", Message: &v1.AuthState{}},
+		),
+		quadlek.WithSealedKeys("authtoken-"),
+		quadlek.WithMigrations(quadlek.Migration{
+			Version:     1,
+			Description: "Expire authentication states",
+			Migrate: func(bkt storage.Bucket) error {
+				return quadlek.ExpireExistingKeys(bkt, "authstate-", authStateTTL)
+			},
+		}),
 	)
 }
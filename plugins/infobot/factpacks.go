@@ -175,25 +175,23 @@ func (fs *lockingFactStore) Serialize() ([]byte, error) {
 	return out, nil
 }
 
-func (fs *lockingFactStore) Load(facts []byte) error {
-	factStore := &v1.FactStore{}
+// Snapshot returns a copy of the facts that can be stored while the fact store keeps changing.
+func (fs *lockingFactStore) Snapshot() *v1.FactStore {
+	fs.factsMtx.RLock()
+	defer fs.factsMtx.RUnlock()
 
-	if facts == nil {
-		factStore.Facts = make(map[string]*v1.Fact)
-		return nil
-	}
+	return proto.Clone(fs.factStore).(*v1.FactStore)
+}
 
-	err := proto.Unmarshal(facts, factStore)
-	if err != nil {
-		zap.L().Error("error loading facts", zap.Error(err))
-		return err
+// Restore replaces the facts with previously stored ones.
+func (fs *lockingFactStore) Restore(factStore *v1.FactStore) {
+	if factStore.Facts == nil {
+		factStore.Facts = make(map[string]*v1.Fact)
 	}
 
 	fs.factsMtx.Lock()
 	defer fs.factsMtx.Unlock()
 	fs.factStore = factStore
-
-	return nil
 }
 
 func (fs *lockingFactStore) LookupFact(name string) string {
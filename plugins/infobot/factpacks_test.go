@@ -98,3 +98,19 @@ func TestFactStore_Serialize(t *testing.T) {
 	require.NotNil(t, fs.GetFact("roses"))
 	require.Equal(t, "roses are red", Output(fs.GetFact("roses")))
 }
+
+func TestFactStore_SnapshotRestore(t *testing.T) {
+	fs := MakeFactStore()
+	fs.HumanFactSet("roses are red")
+	snapshot := fs.Snapshot()
+
+	fs.HumanFactForget("forget roses")
+	require.Nil(t, fs.GetFact("roses"))
+
+	fs.Restore(snapshot)
+	require.Equal(t, "roses are red", Output(fs.GetFact("roses")))
+
+	fs.Restore(&v1.FactStore{})
+	fs.HumanFactSet("violets are blue")
+	require.Equal(t, "violets are blue", Output(fs.GetFact("violets")))
+}
@@ -5,9 +5,8 @@ import (
 	"fmt"
 	"strings"
 
+	v1 "github.com/jirwin/quadlek/pb/quadlek/plugins/infobot/v1"
 	"github.com/jirwin/quadlek/quadlek"
-
-	"go.uber.org/zap"
 )
 
 var factStore = MakeFactStore()
@@ -15,69 +14,47 @@ var factStore = MakeFactStore()
 const FactStoreKey = "facts"
 
 func load(bot *quadlek.Bot, store *quadlek.Store) error {
-	return store.Get(FactStoreKey, func(rec []byte) error {
-		return factStore.Load(rec)
-	})
-}
-
-func infobot(ctx context.Context, hookChan <-chan *quadlek.HookMsg) {
-
-	for {
-		select {
-
-		case hookMsg := <-hookChan:
-			if !hookMsg.Bot.MsgToBot(hookMsg.Msg.Text) {
-				continue
-			}
-
-			line := strings.TrimPrefix(hookMsg.Msg.Text, fmt.Sprintf("<@%s> ", hookMsg.Bot.GetUserId()))
-
-			if lookup := factStore.LookupFact(line); lookup != "" {
-				hookMsg.Bot.Respond(hookMsg.Msg, lookup)
-				continue
-			}
-
-			if factStore.HumanFactSet(line) {
-				out, err := factStore.Serialize()
-				if err != nil {
-					zap.L().Error("error serializing factstore", zap.Error(err))
-					continue
-				}
-
-				err = hookMsg.Store.Update(FactStoreKey, out)
+	facts, err := quadlek.GetProto[*v1.FactStore](store, FactStoreKey)
+	if err != nil {
+		return err
+	}
+	factStore.Restore(facts)
 
-				if err != nil {
-					zap.L().Error("error while saving factstore", zap.Error(err))
-					continue
-				}
+	return nil
+}
 
-				hookMsg.Bot.Respond(hookMsg.Msg, "Alright. "+line)
-				continue
-			}
+func infobot(ctx context.Context, hookMsg *quadlek.HookMsg) error {
+	if !hookMsg.Bot.MsgToBot(hookMsg.Msg.Text) {
+		return nil
+	}
 
-			if factStore.HumanFactForget(line) {
-				out, err := factStore.Serialize()
-				if err != nil {
-					zap.L().Error("error serializing factstore", zap.Error(err))
-					continue
-				}
+	line := strings.TrimPrefix(hookMsg.Msg.Text, fmt.Sprintf("<@%s> ", hookMsg.Bot.GetUserId()))
 
-				err = hookMsg.Store.Update(FactStoreKey, out)
+	if lookup := factStore.LookupFact(line); lookup != "" {
+		hookMsg.Bot.RespondContext(ctx, hookMsg.Msg, lookup)
+		return nil
+	}
 
-				if err != nil {
-					zap.L().Error("error while saving factstore", zap.Error(err))
-					continue
-				}
+	if factStore.HumanFactSet(line) {
+		err := quadlek.PutProto(hookMsg.Store, FactStoreKey, factStore.Snapshot())
+		if err != nil {
+			return fmt.Errorf("error while saving factstore: %w", err)
+		}
 
-				hookMsg.Bot.Respond(hookMsg.Msg, "Alright. I forgot it.")
-				continue
-			}
+		hookMsg.Bot.RespondContext(ctx, hookMsg.Msg, "Alright. "+line)
+		return nil
+	}
 
-		case <-ctx.Done():
-			zap.L().Info("Shutting down infobot hook.")
-			return
+	if factStore.HumanFactForget(line) {
+		err := quadlek.PutProto(hookMsg.Store, FactStoreKey, factStore.Snapshot())
+		if err != nil {
+			return fmt.Errorf("error while saving factstore: %w", err)
 		}
+
+		hookMsg.Bot.RespondContext(ctx, hookMsg.Msg, "Alright. I forgot it.")
 	}
+
+	return nil
 }
 
 func Register() quadlek.Plugin {
@@ -85,10 +62,11 @@ func Register() quadlek.Plugin {
 		"infobot",
 		nil,
 		[]quadlek.Hook{
-			quadlek.MakeHook(infobot),
+			quadlek.HookFunc(infobot),
 		},
 		nil,
 		nil,
 		load,
+		quadlek.WithProtoKeys(quadlek.ProtoKey{Prefix: FactStoreKey, Message: &v1.FactStore{}}),
 	)
 }
@@ -13,41 +13,29 @@ import (
 	"github.com/jirwin/quadlek/quadlek"
 )
 
-func scoreCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
-	for {
-		select {
-		case cmdMsg := <-cmdChannel:
-			if cmdMsg.Command.Text == "" {
-				cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-					Text: "I need a name to look up the score for.",
-				}
-				continue
-			}
-			err := cmdMsg.Store.Get(cmdMsg.Command.Text, func(val []byte) error {
-				score := string(val)
-				if val == nil {
-					score = "0"
-				}
-
-				cmdMsg.Command.Reply() <- &quadlek.CommandResp{
-					Text:      fmt.Sprintf("Score for %s is %s", cmdMsg.Command.Text, score),
-					InChannel: true,
-				}
-
-				return nil
-			})
-			if err != nil {
-				zap.L().Error("unable to get score", zap.Error(err))
-				cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{ //nolint:errcheck
-					Text: fmt.Sprintf("Unable to fetch score for %s", cmdMsg.Command.Text),
-				})
-			}
+func scoreCommand(ctx context.Context, cmdMsg *quadlek.CommandMsg) (*quadlek.CommandResp, error) {
+	if cmdMsg.Command.Text == "" {
+		return &quadlek.CommandResp{
+			Text: "I need a name to look up the score for.",
+		}, nil
+	}
 
-		case <-ctx.Done():
-			zap.L().Info("Exiting KarmaScoreCommand.")
-			return
+	score := "0"
+	err := cmdMsg.Store.Get(cmdMsg.Command.Text, func(val []byte) error {
+		if val != nil {
+			score = string(val)
 		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch score for %s: %w", cmdMsg.Command.Text, err)
 	}
+
+	return &quadlek.CommandResp{
+		Text:      fmt.Sprintf("Score for %s is %s", cmdMsg.Command.Text, score),
+		InChannel: true,
+	}, nil
 }
 
 var (
@@ -55,6 +43,11 @@ var (
 	mmRegex = regexp.MustCompile(".+--$")
 )
 
+// countUpdate counts a karma change in the given direction, up or down.
+func countUpdate(bot *quadlek.Bot, direction string) {
+	bot.Counter("karma", "updates_total", "Karma changes, by direction.", "direction").WithLabelValues(direction).Inc()
+}
+
 func karmaHook(ctx context.Context, hookChannel <-chan *quadlek.HookMsg) {
 	for {
 		select {
@@ -82,6 +75,8 @@ func karmaHook(ctx context.Context, hookChannel <-chan *quadlek.HookMsg) {
 					})
 					if err != nil {
 						zap.L().Error("Error incrementing value", zap.String("token", t), zap.Error(err))
+					} else {
+						countUpdate(hookMsg.Bot, "up")
 					}
 				}
 
@@ -105,6 +100,8 @@ func karmaHook(ctx context.Context, hookChannel <-chan *quadlek.HookMsg) {
 					})
 					if err != nil {
 						zap.L().Error("Error decrementing value: %s", zap.String("token", t), zap.Error(err))
+					} else {
+						countUpdate(hookMsg.Bot, "down")
 					}
 				}
 			}
@@ -120,7 +117,11 @@ func Register() quadlek.Plugin {
 	return quadlek.MakePlugin(
 		"karma",
 		[]quadlek.Command{
-			quadlek.MakeCommand("score", scoreCommand),
+			quadlek.CommandFunc("score", scoreCommand,
+				quadlek.WithDescription("Show the karma score for a name."),
+				quadlek.WithUsage("<name>"),
+				quadlek.WithExamples("/score jirwin"),
+			),
 		},
 		[]quadlek.Hook{
 			quadlek.MakeHook(karmaHook),
@@ -0,0 +1,31 @@
+package karma
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jirwin/quadlek/quadlek/quadlektest"
+)
+
+func TestKarma(t *testing.T) {
+	h := quadlektest.New(t, quadlektest.WithPlugins(Register()))
+
+	resp := h.Command("U1", "CGENERAL", "/score", "")
+	require.Equal(t, "I need a name to look up the score for.", resp.Text)
+
+	h.Message("U1", "CGENERAL", "jirwin++ thanks! bugs-- jirwin++")
+	h.WaitFor(func() bool {
+		return h.Command("U1", "CGENERAL", "/score", "jirwin").Text == "Score for jirwin is 2"
+	})
+
+	resp = h.Command("U1", "CGENERAL", "/score", "bugs")
+	require.Equal(t, "Score for bugs is -1", resp.Text)
+	require.True(t, resp.InChannel)
+
+	metrics := h.Webhook(httptest.NewRequest(http.MethodGet, "/metrics", nil)).Body.String()
+	require.Contains(t, metrics, `quadlek_karma_updates_total{direction="up"} 2`)
+	require.Contains(t, metrics, `quadlek_karma_updates_total{direction="down"} 1`)
+}
@@ -13,11 +13,12 @@ import (
 
 var tvdbKey string
 
-func getTVDBClient(authToken string) (*tvdb.Client, error) {
+func getTVDBClient(bot *quadlek.Bot, authToken string) (*tvdb.Client, error) {
 	auth := &tvdb.Auth{APIKey: authToken}
 
 	hClient := &http.Client{
-		Timeout: 60 * time.Second,
+		Transport: bot.HttpClient().Transport,
+		Timeout:   60 * time.Second,
 	}
 
 	tClient := tvdb.NewClient(hClient, auth)
@@ -84,7 +85,7 @@ func nextEpCommand(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
 		select {
 		case cmdMsg := <-cmdChannel:
 			text := cmdMsg.Command.Text
-			client, err := getTVDBClient(tvdbKey)
+			client, err := getTVDBClient(cmdMsg.Bot, tvdbKey)
 			if err != nil {
 				cmdMsg.Command.Reply() <- &quadlek.CommandResp{
 					Text: fmt.Sprintf("Show Search Failed: %s", err),
@@ -141,7 +142,10 @@ func Register(apikey string) quadlek.Plugin {
 	return quadlek.MakePlugin(
 		"TVDB",
 		[]quadlek.Command{
-			quadlek.MakeCommand("nextep", nextEpCommand),
+			quadlek.MakeCommand("nextep", nextEpCommand,
+				quadlek.WithDescription("Show when the next episode of a TV show airs."),
+				quadlek.WithUsage("<show...>"),
+			),
 		},
 		nil,
 		nil,
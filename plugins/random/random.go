@@ -175,10 +175,24 @@ func Register() quadlek.Plugin {
 	return quadlek.MakePlugin(
 		"random",
 		[]quadlek.Command{
-			quadlek.MakeCommand("roll", rollCommand),
-			quadlek.MakeCommand("choose", chooseCommand),
-			quadlek.MakeCommand("dice", diceCommand),
-			quadlek.MakeCommand("flip", flipCommand),
+			quadlek.MakeCommand("roll", rollCommand,
+				quadlek.WithDescription("Roll a random number between 0 and max, 100 by default."),
+				quadlek.WithUsage("[max]"),
+				quadlek.WithExamples("/roll", "/roll 20"),
+			),
+			quadlek.MakeCommand("choose", chooseCommand,
+				quadlek.WithDescription("Choose one of a comma separated list of choices."),
+				quadlek.WithUsage("<choice>, <choice>..."),
+				quadlek.WithExamples("/choose pizza, tacos, sushi"),
+			),
+			quadlek.MakeCommand("dice", diceCommand,
+				quadlek.WithDescription("Roll dice in NdS+M notation."),
+				quadlek.WithUsage("<dice>..."),
+				quadlek.WithExamples("/dice 1d6 2d4", "/dice 11d12+2"),
+			),
+			quadlek.MakeCommand("flip", flipCommand,
+				quadlek.WithDescription("Flip a coin."),
+			),
 		},
 		nil,
 		nil,
@@ -2,8 +2,9 @@ package spotify
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/url"
-	"os"
 	"regexp"
 	"strings"
 
@@ -13,7 +14,6 @@ import (
 	"github.com/jirwin/quadlek/quadlek"
 	"github.com/zmb3/spotify"
 	"go.uber.org/zap"
-	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -24,18 +24,16 @@ const (
 var spotifyTrackRegex = regexp.MustCompile(`spotify:track:(\w+)\b`)
 
 func getSharedPlaylist() string {
-	playlist := os.Getenv("SPOTIFY_SHARED_PLAYLIST")
-	if playlist != "" {
-		return playlist
+	if config.SharedPlaylist != "" {
+		return config.SharedPlaylist
 	}
 
 	return sharedPlaylist
 }
 
 func getSharedPlaylistUser() string {
-	playlistUser := os.Getenv("SPOTIFY_SHARED_PLAYLIST_USER")
-	if playlistUser != "" {
-		return playlistUser
+	if config.SharedPlaylistUser != "" {
+		return config.SharedPlaylistUser
 	}
 
 	return sharedPlaylistUser
@@ -67,51 +65,34 @@ func extractSpotifyLink(msg string) []string {
 	return ret
 }
 
-func saveSongsHook(ctx context.Context, hookChannel <-chan *quadlek.HookMsg) {
-	for {
-		select {
-		case hookMsg := <-hookChannel:
-			var tracks []spotify.ID
-			for _, match := range extractSpotifyLink(hookMsg.Msg.Text) {
-				tracks = append(tracks, spotify.ID(match))
-			}
-
-			if len(tracks) == 0 {
-				continue
-			}
-
-			err := hookMsg.Store.Get("authtoken-"+getSharedPlaylistUser(), func(val []byte) error {
-				authToken := &v1.AuthToken{}
-				err := proto.Unmarshal(val, authToken)
-				if err != nil {
-					return err
-				}
-
-				if authToken.Token == nil {
-					zap.L().Error("There wasn't a token for the shared playlist user")
-					return nil
-				}
-				client, needsReauth := getSpotifyClient(authToken)
-				if needsReauth {
-					zap.L().Info("detected a song, but need to reauth before it can be added.")
-				}
-
-				snapshotId, err := client.AddTracksToPlaylist(spotify.ID(getSharedPlaylist()), tracks...)
-				if err != nil {
-					zap.L().Error("error adding tracks to shared playlist", zap.Error(err))
-					return err
-				}
-				zap.L().Info("Spotify snapshot id", zap.String("snapshotId", snapshotId))
-
-				return nil
-			})
-			if err != nil {
-				continue
-			}
-
-		case <-ctx.Done():
-			zap.L().Info("Exiting save song hook")
-			return
-		}
+func saveSongsHook(ctx context.Context, hookMsg *quadlek.HookMsg) error {
+	var tracks []spotify.ID
+	for _, match := range extractSpotifyLink(hookMsg.Msg.Text) {
+		tracks = append(tracks, spotify.ID(match))
+	}
+
+	if len(tracks) == 0 {
+		return nil
 	}
+
+	authToken, err := quadlek.GetSealedProto[*v1.AuthToken](hookMsg.Store, "authtoken-"+getSharedPlaylistUser())
+	if err != nil {
+		return fmt.Errorf("error loading the shared playlist user's auth token: %w", err)
+	}
+
+	if authToken.Token == nil {
+		return errors.New("there wasn't a token for the shared playlist user")
+	}
+	client, needsReauth := getSpotifyClient(authToken)
+	if needsReauth {
+		zap.L().Info("detected a song, but need to reauth before it can be added.")
+	}
+
+	snapshotId, err := client.AddTracksToPlaylist(spotify.ID(getSharedPlaylist()), tracks...)
+	if err != nil {
+		return fmt.Errorf("error adding tracks to shared playlist: %w", err)
+	}
+	zap.L().Info("Spotify snapshot id", zap.String("snapshotId", snapshotId))
+
+	return nil
 }
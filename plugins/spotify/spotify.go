@@ -17,16 +17,30 @@ import (
 
 	"strings"
 
-	"github.com/boltdb/bolt"
 	"github.com/jirwin/quadlek/quadlek"
+	"github.com/jirwin/quadlek/quadlek/storage"
 	uuid "github.com/satori/go.uuid"
 	"github.com/zmb3/spotify"
 	"golang.org/x/oauth2"
-	"google.golang.org/protobuf/proto"
 )
 
 const WebhookRoot = "https://%s/slack/plugin"
 
+// authStateTTL is how long a user has to finish authenticating to Spotify.
+const authStateTTL = 15 * time.Minute
+
+// Config is the spotify section of the bot config.
+type Config struct {
+	// WebhookDomain is the public domain that spotify redirects to after authorizing a user.
+	WebhookDomain string `yaml:"webhook_domain" toml:"webhook_domain"`
+	// SharedPlaylist is the id of the playlist that tracks shared in Slack are added to.
+	SharedPlaylist string `yaml:"shared_playlist" toml:"shared_playlist"`
+	// SharedPlaylistUser is the id of the Slack user whose Spotify account owns the shared playlist.
+	SharedPlaylistUser string `yaml:"shared_playlist_user" toml:"shared_playlist_user"`
+}
+
+var config = &Config{}
+
 var scopes = []string{
 	spotify.ScopePlaylistModifyPublic,
 	spotify.ScopeUserReadCurrentlyPlaying,
@@ -58,7 +72,27 @@ func startAuthFlow(stateId string) string {
 }
 
 func webhookRoot() string {
-	return fmt.Sprintf(WebhookRoot, os.Getenv("SPOTIFY_WEBHOOK_DOMAIN"))
+	return fmt.Sprintf(WebhookRoot, config.WebhookDomain)
+}
+
+func load(bot *quadlek.Bot, store *quadlek.Store) error {
+	err := bot.DecodePluginConfig("spotify", config)
+	if err != nil {
+		return err
+	}
+
+	// Fall back to the environment for deployments that predate the config file.
+	if config.WebhookDomain == "" {
+		config.WebhookDomain = os.Getenv("SPOTIFY_WEBHOOK_DOMAIN")
+	}
+	if config.SharedPlaylist == "" {
+		config.SharedPlaylist = os.Getenv("SPOTIFY_SHARED_PLAYLIST")
+	}
+	if config.SharedPlaylistUser == "" {
+		config.SharedPlaylistUser = os.Getenv("SPOTIFY_SHARED_PLAYLIST_USER")
+	}
+
+	return nil
 }
 
 func getSpotifyAuth() spotify.Authenticator {
@@ -84,7 +118,7 @@ func getSpotifyClient(authToken *v1.AuthToken) (spotify.Client, bool) {
 	return client, false
 }
 
-func authFlow(cmdMsg *quadlek.CommandMsg, bkt *bolt.Bucket) error {
+func authFlow(cmdMsg *quadlek.CommandMsg, bkt storage.Bucket) error {
 	uuid := uuid.NewV4()
 
 	stateId := uuid.String()
@@ -94,16 +128,13 @@ func authFlow(cmdMsg *quadlek.CommandMsg, bkt *bolt.Bucket) error {
 		Id:          stateId,
 		UserId:      cmdMsg.Command.UserId,
 		ResponseUrl: cmdMsg.Command.ResponseUrl,
-		ExpireTime:  time.Now().UnixNano() + int64(time.Minute*15),
+		ExpireTime:  time.Now().Add(authStateTTL).UnixNano(),
 	}
 
-	authStateBytes, err := proto.Marshal(authState)
-	if err != nil {
-		zap.L().Error("error marshalling auth state", zap.Error(err))
-		return err
+	err := quadlek.PutBucketProto(bkt, "authstate-"+stateId, authState)
+	if err == nil {
+		err = quadlek.ExpireKey(bkt, "authstate-"+stateId, authStateTTL)
 	}
-
-	err = bkt.Put([]byte("authstate-"+stateId), authStateBytes)
 	if err != nil {
 		cmdMsg.Command.Reply() <- &quadlek.CommandResp{
 			Text: "There was an error authenticating to Spotify.",
@@ -121,10 +152,8 @@ func nowPlaying(ctx context.Context, cmdChannel <-chan *quadlek.CommandMsg) {
 	for {
 		select {
 		case cmdMsg := <-cmdChannel:
-			err := cmdMsg.Store.UpdateRaw(func(bkt *bolt.Bucket) error {
-				authToken := &v1.AuthToken{}
-				authTokenBytes := bkt.Get([]byte("authtoken-" + cmdMsg.Command.UserId))
-				err := proto.Unmarshal(authTokenBytes, authToken)
+			err := cmdMsg.Store.Transaction(func(bkt storage.Bucket) error {
+				authToken, err := quadlek.GetBucketSealedProto[*v1.AuthToken](bkt, "authtoken-"+cmdMsg.Command.UserId)
 				if err != nil {
 					zap.L().Error("error unmarshalling auth token", zap.Error(err))
 					return err
@@ -198,12 +227,10 @@ func spotifyAuthorizeWebhook(ctx context.Context, whChannel <-chan *quadlek.Webh
 				continue
 			}
 
-			err = whMsg.Store.UpdateRaw(func(bkt *bolt.Bucket) error {
-				authStateBytes := bkt.Get([]byte("authstate-" + stateId[0]))
-				authState := &v1.AuthState{}
-				err := proto.Unmarshal(authStateBytes, authState)
+			err = whMsg.Store.Transaction(func(bkt storage.Bucket) error {
+				authState, err := quadlek.GetBucketProto[*v1.AuthState](bkt, "authstate-"+stateId[0])
 				if err != nil {
-					_ = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
+					_ = whMsg.Bot.RespondToSlashCommandContext(whMsg.Context(), authState.GetResponseUrl(), &quadlek.CommandResp{
 						Text: "Sorry! There was an error logging you into Spotify.",
 					})
 					return err
@@ -215,7 +242,7 @@ func spotifyAuthorizeWebhook(ctx context.Context, whChannel <-chan *quadlek.Webh
 					if err != nil {
 						return err
 					}
-					_ = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
+					_ = whMsg.Bot.RespondToSlashCommandContext(whMsg.Context(), authState.ResponseUrl, &quadlek.CommandResp{
 						Text: "Sorry! There was an error logging you into Spotify.",
 					})
 					return errors.New("Received expired auth request")
@@ -224,7 +251,7 @@ func spotifyAuthorizeWebhook(ctx context.Context, whChannel <-chan *quadlek.Webh
 				auth := getSpotifyAuth()
 				token, err := auth.Token(stateId[0], whMsg.Request)
 				if err != nil {
-					_ = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
+					_ = whMsg.Bot.RespondToSlashCommandContext(whMsg.Context(), authState.ResponseUrl, &quadlek.CommandResp{
 						Text: "Sorry! There was an error logging you into Spotify.",
 					})
 					return err
@@ -234,23 +261,16 @@ func spotifyAuthorizeWebhook(ctx context.Context, whChannel <-chan *quadlek.Webh
 				PopulateFromOauthToken(authToken, token)
 				authToken.Scopes = scopes
 
-				tokenBytes, err := proto.Marshal(authToken)
+				err = quadlek.PutBucketSealedProto(bkt, "authtoken-"+authState.UserId, authToken)
 				if err != nil {
-					_ = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
-						Text: "Sorry! There was an error logging you into Spotify.",
-					})
-					return err
-				}
-				err = bkt.Put([]byte("authtoken-"+authState.UserId), tokenBytes)
-				if err != nil {
-					_ = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
+					_ = whMsg.Bot.RespondToSlashCommandContext(whMsg.Context(), authState.ResponseUrl, &quadlek.CommandResp{
 						Text: "Sorry! There was an error logging you into Spotify.",
 					})
 					zap.L().Error("error storing auth token.")
 					return err
 				}
 
-				_ = whMsg.Bot.RespondToSlashCommand(authState.ResponseUrl, &quadlek.CommandResp{
+				_ = whMsg.Bot.RespondToSlashCommandContext(whMsg.Context(), authState.ResponseUrl, &quadlek.CommandResp{
 					Text: "Successfully logged into Spotify. Try your command again please.",
 				})
 
@@ -272,15 +292,27 @@ func Register() quadlek.Plugin {
 	return quadlek.MakePlugin(
 		"spotify",
 		[]quadlek.Command{
-			quadlek.MakeCommand("nowplaying", nowPlaying),
+			quadlek.MakeCommand("nowplaying", nowPlaying, quadlek.WithDescription("Share what you are listening to on Spotify.")),
 		},
 		[]quadlek.Hook{
-			quadlek.MakeHook(saveSongsHook),
+			quadlek.HookFunc(saveSongsHook),
 		},
 		nil,
 		[]quadlek.Webhook{
 			quadlek.MakeWebhook("spotifyAuthorize", spotifyAuthorizeWebhook),
 		},
-		nil,
+		load,
+		quadlek.WithProtoKeys(
+			quadlek.ProtoKey{Prefix: "authtoken-", Message: &v1.AuthToken{}},
+			quadlek.ProtoKey{Prefix: "authstate-", Message: &v1.AuthState{}},
+		),
+		quadlek.WithSealedKeys("authtoken-"),
+		quadlek.WithMigrations(quadlek.Migration{
+			Version:     1,
+			Description: "Expire authentication states",
+			Migrate: func(bkt storage.Bucket) error {
+				return quadlek.ExpireExistingKeys(bkt, "authstate-", authStateTTL)
+			},
+		}),
 	)
 }
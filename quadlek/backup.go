@@ -0,0 +1,401 @@
+package quadlek
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// exportVersion is the version of the export format written by Export.
+const exportVersion = 1
+
+// Backups are named with the time they were taken, so that they sort oldest first.
+const (
+	backupPrefix     = "quadlek-"
+	backupSuffix     = ".json"
+	backupTimeLayout = "20060102T150405.000000000Z"
+)
+
+// BackupConfig configures scheduled backups of the database.
+type BackupConfig struct {
+	// Dir is the directory backups are written to. Scheduled backups are disabled if it is empty.
+	Dir string `yaml:"dir" toml:"dir"`
+	// Schedule is a cron expression for when backups are taken. Defaults to @daily.
+	Schedule string `yaml:"schedule" toml:"schedule"`
+	// Keep is how many backups are kept in Dir, the oldest are deleted first. Defaults to 7.
+	Keep int `yaml:"keep" toml:"keep"`
+}
+
+// ProtoKey tells exports that the values of the keys starting with Prefix are Message protobufs,
+// so they are exported as readable JSON rather than raw bytes.
+type ProtoKey struct {
+	Prefix  string
+	Message proto.Message
+}
+
+// WithProtoKeys registers the protobuf types stored by a plugin, for exports.
+func WithProtoKeys(keys ...ProtoKey) PluginOption {
+	return func(p *plugin) {
+		p.protoKeys = append(p.protoKeys, keys...)
+	}
+}
+
+// protoKeys holds the protobuf types registered by each plugin.
+type protoKeys struct {
+	mtx  sync.RWMutex
+	keys map[string][]ProtoKey
+}
+
+// register records the protobuf types stored by a plugin.
+func (pk *protoKeys) register(pluginId string, keys []ProtoKey) {
+	pk.mtx.Lock()
+	defer pk.mtx.Unlock()
+
+	if pk.keys == nil {
+		pk.keys = make(map[string][]ProtoKey)
+	}
+	pk.keys[pluginId] = keys
+}
+
+// lookup returns the protobuf type stored at a plugin's key, or nil if it isn't known.
+func (pk *protoKeys) lookup(pluginId, key string) proto.Message {
+	pk.mtx.RLock()
+	defer pk.mtx.RUnlock()
+
+	for _, k := range pk.keys[pluginId] {
+		if strings.HasPrefix(key, k.Prefix) {
+			return k.Message
+		}
+	}
+
+	return nil
+}
+
+// Export is the JSON document written by Bot.Export and read by Bot.Import.
+type Export struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Buckets holds the keys of each exported plugin, by plugin id.
+	Buckets map[string][]ExportEntry `json:"buckets"`
+}
+
+// ExportEntry is an exported key. Only one of Message, Text and Value is set.
+type ExportEntry struct {
+	Key string `json:"key"`
+	// Message is the value as protobuf JSON, for keys registered with WithProtoKeys.
+	Message json.RawMessage `json:"message,omitempty"`
+	// Text is the value of other keys if it is valid UTF-8.
+	Text string `json:"text,omitempty"`
+	// Value is the raw value of anything else, and of every key in a backup.
+	Value []byte `json:"value,omitempty"`
+	// Sealed is true if Value was sealed with Store.PutSealed. Sealed values are exported as they are stored,
+	// so they can only be read with the sealing key.
+	Sealed    bool       `json:"sealed,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Export writes the contents of the given plugins' buckets to w as JSON, or every bucket if no plugin ids are given.
+// Everything is read in one transaction, so the export is consistent while the Bot is running.
+func (b *Bot) Export(w io.Writer, pluginIds ...string) error {
+	return b.export(w, false, pluginIds...)
+}
+
+// export writes an export of the given plugins' buckets to w. If raw is true every value is written as
+// it is stored rather than decoded, so nothing can be lost or fail to decode.
+func (b *Bot) export(w io.Writer, raw bool, pluginIds ...string) error {
+	export := &Export{
+		Version: exportVersion,
+		Created: time.Now().UTC(),
+		Buckets: make(map[string][]ExportEntry),
+	}
+
+	err := b.storage.View(func(tx storage.Tx) error {
+		if len(pluginIds) == 0 {
+			names, err := tx.Buckets()
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				if name != expiryBucket {
+					pluginIds = append(pluginIds, name)
+				}
+			}
+		}
+
+		for _, pluginId := range pluginIds {
+			bkt, err := b.getStore(pluginId).bucket(tx)
+			if err != nil {
+				return err
+			}
+
+			entries := []ExportEntry{}
+			err = bkt.Scan(nil, func(key, value []byte) error {
+				entry, err := b.exportEntry(pluginId, bkt, key, value, raw)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, entry)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			export.Buckets[pluginId] = entries
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(export)
+}
+
+// exportEntry converts a key to its exported form.
+func (b *Bot) exportEntry(pluginId string, bkt *storeBucket, key, value []byte, raw bool) (ExportEntry, error) {
+	entry := ExportEntry{Key: string(key)}
+
+	expiresAt, err := bkt.expiresAt(key)
+	if err != nil {
+		return entry, err
+	}
+	if !expiresAt.IsZero() {
+		expiresAt = expiresAt.UTC()
+		entry.ExpiresAt = &expiresAt
+	}
+
+	if isSealed(value) || raw {
+		entry.Sealed = isSealed(value)
+		entry.Value = append([]byte{}, value...)
+		return entry, nil
+	}
+
+	if msgType := b.protoKeys.lookup(pluginId, entry.Key); msgType != nil {
+		msg := msgType.ProtoReflect().New().Interface()
+		err = proto.Unmarshal(value, msg)
+		if err != nil {
+			return entry, &DecodeError{PluginId: pluginId, Key: entry.Key, Err: err}
+		}
+		entry.Message, err = protojson.Marshal(msg)
+		if err != nil {
+			return entry, err
+		}
+		return entry, nil
+	}
+
+	if utf8.Valid(value) {
+		entry.Text = string(value)
+		return entry, nil
+	}
+
+	entry.Value = append([]byte{}, value...)
+	return entry, nil
+}
+
+// Import replaces the contents of every bucket in an export read from r. Buckets that aren't in the export aren't changed.
+// Everything is written in one transaction, so nothing is changed if the import fails.
+func (b *Bot) Import(r io.Reader) error {
+	export := &Export{}
+	err := json.NewDecoder(r).Decode(export)
+	if err != nil {
+		return err
+	}
+	if export.Version != exportVersion {
+		return fmt.Errorf("unsupported export version %d", export.Version)
+	}
+
+	now := time.Now()
+	return b.storage.Update(func(tx storage.Tx) error {
+		for pluginId, entries := range export.Buckets {
+			if pluginId == expiryBucket {
+				return fmt.Errorf("invalid bucket %s", pluginId)
+			}
+
+			bkt, err := b.getStore(pluginId).bucket(tx)
+			if err != nil {
+				return err
+			}
+
+			var existing [][]byte
+			err = bkt.Bucket.Scan(nil, func(key, value []byte) error {
+				existing = append(existing, append([]byte{}, key...))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, key := range existing {
+				err = bkt.Delete(key)
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, entry := range entries {
+				if entry.ExpiresAt != nil && !entry.ExpiresAt.After(now) {
+					continue
+				}
+
+				value, err := b.importValue(pluginId, entry)
+				if err != nil {
+					return err
+				}
+				err = bkt.Put([]byte(entry.Key), value)
+				if err != nil {
+					return err
+				}
+				if entry.ExpiresAt != nil {
+					err = ExpireKey(bkt, entry.Key, entry.ExpiresAt.Sub(now))
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// importValue converts an exported key back to the value to store.
+func (b *Bot) importValue(pluginId string, entry ExportEntry) ([]byte, error) {
+	switch {
+	case len(entry.Message) > 0:
+		msgType := b.protoKeys.lookup(pluginId, entry.Key)
+		if msgType == nil {
+			return nil, fmt.Errorf("%s in the %s export is a message, but the plugin hasn't registered its type", entry.Key, pluginId)
+		}
+		msg := msgType.ProtoReflect().New().Interface()
+		err := protojson.Unmarshal(entry.Message, msg)
+		if err != nil {
+			return nil, &DecodeError{PluginId: pluginId, Key: entry.Key, Err: err}
+		}
+		return proto.Marshal(msg)
+
+	case entry.Text != "":
+		return []byte(entry.Text), nil
+
+	default:
+		return append([]byte{}, entry.Value...), nil
+	}
+}
+
+// Backup writes an export of every bucket to the backup directory, deletes the oldest backups beyond the number
+// to keep, and returns the path of the new backup. Values are backed up as they are stored, so a backup
+// never depends on decoding them.
+func (b *Bot) Backup() (string, error) {
+	cfg := b.config.Backup
+	if cfg.Dir == "" {
+		return "", fmt.Errorf("no backup directory is configured")
+	}
+
+	err := os.MkdirAll(cfg.Dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first, so a failed backup never looks like a complete one.
+	f, err := os.CreateTemp(cfg.Dir, ".backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	err = b.export(f, true)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	path, err := newBackupPath(cfg.Dir)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", err
+	}
+
+	backups, err := b.Backups()
+	if err != nil {
+		return "", err
+	}
+	if cfg.Keep > 0 && len(backups) > cfg.Keep {
+		for _, old := range backups[cfg.Keep:] {
+			err = os.Remove(filepath.Join(cfg.Dir, old))
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return path, nil
+}
+
+// newBackupPath returns the path for a backup taken now that doesn't replace an existing backup.
+func newBackupPath(dir string) (string, error) {
+	for {
+		path := filepath.Join(dir, backupPrefix+time.Now().UTC().Format(backupTimeLayout)+backupSuffix)
+		_, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// Backups returns the file names of the backups in the backup directory, newest first.
+func (b *Bot) Backups() ([]string, error) {
+	entries, err := os.ReadDir(b.config.Backup.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	return backups, nil
+}
+
+// backupTask is the core scheduled task that takes backups.
+func (b *Bot) backupTask(ctx context.Context, msg *ScheduledTaskMsg) error {
+	path, err := b.Backup()
+	if err != nil {
+		return err
+	}
+	b.Log.Info("backed up the database", zap.String("path", path))
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package quadlek
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	v1 "github.com/jirwin/quadlek/pb/quadlek/plugins/comics/v1"
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func newBackupBot(t *testing.T) *Bot {
+	b := &Bot{storage: storage.NewMemory(), Log: zap.NewNop(), config: defaultConfig()}
+	b.protoKeys.register("comics", []ProtoKey{{Prefix: "templates", Message: &v1.Templates{}}})
+
+	store := b.getStore("comics")
+	require.NoError(t, PutProto(store, "templates", &v1.Templates{Urls: []string{"http://example.com/comic.png"}}))
+	require.NoError(t, store.Update("plain", []byte("hello")))
+	require.NoError(t, store.Update("binary", []byte{0xff, 0x00}))
+	require.NoError(t, store.PutWithTTL("expiring", []byte("soon"), time.Hour))
+	require.NoError(t, b.getStore("karma").Update("jirwin", []byte("5")))
+
+	return b
+}
+
+func TestExport(t *testing.T) {
+	b := newBackupBot(t)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, b.Export(buf, "comics"))
+
+	export := &Export{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), export))
+	require.Equal(t, exportVersion, export.Version)
+	require.Len(t, export.Buckets, 1)
+
+	entries := export.Buckets["comics"]
+	require.Len(t, entries, 4)
+	require.Equal(t, "binary", entries[0].Key)
+	require.Equal(t, []byte{0xff, 0x00}, entries[0].Value)
+	require.Equal(t, "expiring", entries[1].Key)
+	require.NotNil(t, entries[1].ExpiresAt)
+	require.Equal(t, "plain", entries[2].Key)
+	require.Equal(t, "hello", entries[2].Text)
+	require.Equal(t, "templates", entries[3].Key)
+	require.JSONEq(t, `{"urls": ["http://example.com/comic.png"]}`, string(entries[3].Message))
+}
+
+func TestExportImport(t *testing.T) {
+	b := newBackupBot(t)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, b.Export(buf))
+
+	restored := &Bot{storage: storage.NewMemory(), Log: zap.NewNop(), config: defaultConfig()}
+	restored.protoKeys.register("comics", []ProtoKey{{Prefix: "templates", Message: &v1.Templates{}}})
+	require.NoError(t, restored.getStore("comics").Update("stale", []byte("gone")))
+	require.NoError(t, restored.Import(bytes.NewReader(buf.Bytes())))
+
+	for _, pluginId := range []string{"comics", "karma"} {
+		var want, got []string
+		collect := func(keys *[]string) func(key string, value []byte) error {
+			return func(key string, value []byte) error {
+				*keys = append(*keys, key+"="+string(value))
+				return nil
+			}
+		}
+		require.NoError(t, b.getStore(pluginId).Range("", collect(&want)))
+		require.NoError(t, restored.getStore(pluginId).Range("", collect(&got)))
+		require.Equal(t, want, got)
+	}
+
+	expiresAt, err := restored.getStore("comics").ExpiresAt("expiring")
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+	// Messages need their type to be registered to be imported.
+	unregistered := &Bot{storage: storage.NewMemory(), Log: zap.NewNop(), config: defaultConfig()}
+	require.Error(t, unregistered.Import(bytes.NewReader(buf.Bytes())))
+}
+
+func TestBackup(t *testing.T) {
+	b := newBackupBot(t)
+	b.config.Backup.Dir = filepath.Join(t.TempDir(), "backups")
+	b.config.Backup.Keep = 2
+	require.NoError(t, os.MkdirAll(b.config.Backup.Dir, 0700))
+
+	old := []string{"quadlek-20200101T000000Z.json", "quadlek-20200102T000000Z.json"}
+	for _, name := range old {
+		require.NoError(t, os.WriteFile(filepath.Join(b.config.Backup.Dir, name), []byte("{}"), 0600))
+	}
+
+	// Values are backed up as they are stored, even if they don't decode.
+	require.NoError(t, b.getStore("comics").Update("templates-corrupt", []byte{0xff, 0xff}))
+	require.Error(t, b.Export(&bytes.Buffer{}))
+
+	first, err := b.Backup()
+	require.NoError(t, err)
+	path, err := b.Backup()
+	require.NoError(t, err)
+	require.NotEqual(t, first, path)
+
+	backups, err := b.Backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	require.Equal(t, filepath.Base(path), backups[0])
+	require.Equal(t, filepath.Base(first), backups[1])
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	restored := &Bot{storage: storage.NewMemory(), Log: zap.NewNop(), config: defaultConfig()}
+	restored.protoKeys.register("comics", []ProtoKey{{Prefix: "templates", Message: &v1.Templates{}}})
+	require.NoError(t, restored.Import(f))
+
+	templates, err := GetProto[*v1.Templates](restored.getStore("comics"), "templates")
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://example.com/comic.png"}, templates.Urls)
+
+	var corrupt []byte
+	require.NoError(t, restored.getStore("comics").Get("templates-corrupt", func(value []byte) error {
+		corrupt = append([]byte{}, value...)
+		return nil
+	}))
+	require.Equal(t, []byte{0xff, 0xff}, corrupt)
+}
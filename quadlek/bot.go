@@ -7,17 +7,18 @@ package quadlek
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"math/rand"
-	"os"
+	"net/http"
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
-	"github.com/boltdb/bolt"
 	"github.com/slack-go/slack"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
 )
 
 // This is the core struct for the Bot, and provides all methods required for interacting with various Slack APIs.
@@ -25,24 +26,34 @@ import (
 // An instance of the bot is provided to plugins to enable plugins to interact with the Slack API.
 type Bot struct {
 	Log                  *zap.Logger
-	apiKey               string
-	verificationToken    string
+	config               *Config
 	api                  *slack.Client
-	channels             map[string]slack.Channel
-	humanChannels        map[string]slack.Channel
+	httpClient           *http.Client
+	console              *console
+	directory            *directory
+	events               *eventDeduper
 	userId               string
 	botId                string
-	humanUsers           map[string]slack.User
-	users                map[string]slack.User
+	pluginIds            map[string]bool
 	commands             map[string]*registeredCommand
 	cmdChannel           chan *slashCommand
 	webhooks             map[string]*registeredWebhook
 	pluginWebhookChannel chan *PluginWebhook
-	interactionChannel   chan *slack.InteractionCallback
+	interactionChannel   chan *interactionRequest
 	interactions         map[string]*registeredInteraction
 	hooks                []*registeredHook
 	reactionHooks        []*registeredReactionHook
-	db                   *bolt.DB
+	storage              storage.Backend
+	sealer               *sealer
+	supervisor           supervisor
+	limiter              *rateLimiter
+	recorder             *recorder
+	metrics              *metrics
+	tracerProvider       trace.TracerProvider
+	shutdownTracing      func(context.Context) error
+	pluginClient         *http.Client
+	scheduler            scheduler
+	protoKeys            protoKeys
 	ctx                  context.Context
 	cancel               context.CancelFunc
 	wg                   sync.WaitGroup
@@ -67,39 +78,24 @@ func (b *Bot) GetApi() *slack.Client {
 
 // GetChannelId returns the Slack channel ID for a given human-readable channel name.
 func (b *Bot) GetChannelId(chanName string) (string, error) {
-	channel, ok := b.humanChannels[chanName]
-	if !ok {
-		return "", errors.New("Channel not found.")
-	}
-
-	return channel.ID, nil
+	return b.directory.channelId(b.ctx, chanName)
 }
 
 // GetChannel returns the Slack channel object given a channel ID
 func (b *Bot) GetChannel(chanId string) (*slack.Channel, error) {
-	channel, ok := b.channels[chanId]
-	if !ok {
-		return nil, errors.New("Channel not found.")
-	}
-
-	return &channel, nil
+	return b.directory.channel(b.ctx, chanId)
 }
 
 // GetUser returns the Slack user object given a user ID
 func (b *Bot) GetUser(userId string) (*slack.User, error) {
-	user, ok := b.users[userId]
-	if !ok {
-		return nil, errors.New("User not found.")
-	}
-
-	return &user, nil
+	return b.directory.user(b.ctx, userId)
 }
 
 // GetUserName returns the human-readable user name for a given user ID
 func (b *Bot) GetUserName(userId string) (string, error) {
-	user, ok := b.users[userId]
-	if !ok {
-		return "", errors.New("User not found.")
+	user, err := b.directory.user(b.ctx, userId)
+	if err != nil {
+		return "", err
 	}
 
 	return user.Name, nil
@@ -107,35 +103,50 @@ func (b *Bot) GetUserName(userId string) (string, error) {
 
 // GetUserID returns the slack user name for a human readable username
 func (b *Bot) GetUserID(userName string) (string, error) {
-	user, ok := b.humanUsers[userName]
-	if !ok {
-		return "", errors.New("User not found.")
-	}
-
-	return user.ID, nil
+	return b.directory.userId(b.ctx, userName)
 }
 
 // Respond responds to a Slack message
 // The sent message will go to the same channel as the message that is being responded to and will highlight
 // the author of the original message.
 func (b *Bot) Respond(msg *slack.Msg, resp string) {
-	b.api.PostMessage(msg.Channel, slack.MsgOptionText(fmt.Sprintf("<@%s>: %s", msg.User, resp), false)) //nolint:errcheck
+	b.RespondContext(context.Background(), msg, resp)
+}
+
+// RespondContext is Respond with a context, which carries the trace of the message being handled.
+func (b *Bot) RespondContext(ctx context.Context, msg *slack.Msg, resp string) {
+	b.api.PostMessageContext(ctx, msg.Channel, slack.MsgOptionText(fmt.Sprintf("<@%s>: %s", msg.User, resp), false)) //nolint:errcheck
 }
 
 // PostMessage sends a new message to Slack using the provided channel and message string.
 // It returns the channel ID the message was posted to, and the timestamp that the message was posted at.
 // In combination these can be used to identify the exact message that was sent.
 func (b *Bot) PostMessage(channel string, options ...slack.MsgOption) (string, string, error) {
-	return b.api.PostMessage(channel, options...)
+	return b.PostMessageContext(context.Background(), channel, options...)
+}
+
+// PostMessageContext is PostMessage with a context, which carries the trace of the message being handled.
+func (b *Bot) PostMessageContext(ctx context.Context, channel string, options ...slack.MsgOption) (string, string, error) {
+	return b.api.PostMessageContext(ctx, channel, options...)
 }
 
 // Say sends a message to the provided channel
 func (b *Bot) Say(channel string, resp string) {
-	b.api.PostMessage(channel, slack.MsgOptionText(resp, false)) //nolint:errcheck
+	b.SayContext(context.Background(), channel, resp)
+}
+
+// SayContext is Say with a context, which carries the trace of the message being handled.
+func (b *Bot) SayContext(ctx context.Context, channel string, resp string) {
+	b.api.PostMessageContext(ctx, channel, slack.MsgOptionText(resp, false)) //nolint:errcheck
 }
 
 func (b *Bot) OpenView(triggerID string, response slack.ModalViewRequest) (*slack.ViewResponse, error) {
-	r, err := b.api.OpenView(triggerID, response)
+	return b.OpenViewContext(context.Background(), triggerID, response)
+}
+
+// OpenViewContext is OpenView with a context, which carries the trace of the interaction being handled.
+func (b *Bot) OpenViewContext(ctx context.Context, triggerID string, response slack.ModalViewRequest) (*slack.ViewResponse, error) {
+	r, err := b.api.OpenViewContext(ctx, triggerID, response)
 	if err != nil {
 		b.Log.Error("error opening view", zap.Error(err))
 		return nil, err
@@ -147,7 +158,12 @@ func (b *Bot) OpenView(triggerID string, response slack.ModalViewRequest) (*slac
 // React attaches an emojii reaction to a message.
 // Reactions are formatted like: :+1:
 func (b *Bot) React(msg *slack.Msg, reaction string) {
-	b.api.AddReaction(reaction, slack.NewRefToMessage(msg.Channel, msg.Timestamp)) //nolint:errcheck
+	b.ReactContext(context.Background(), msg, reaction)
+}
+
+// ReactContext is React with a context, which carries the trace of the message being handled.
+func (b *Bot) ReactContext(ctx context.Context, msg *slack.Msg, reaction string) {
+	b.api.AddReactionContext(ctx, reaction, slack.NewRefToMessage(msg.Channel, msg.Timestamp)) //nolint:errcheck
 }
 
 func (b *Bot) initInfo() error {
@@ -160,40 +176,14 @@ func (b *Bot) initInfo() error {
 	b.userId = at.UserID
 	b.botId = at.BotID
 
-	pageToken := ""
-	for {
-		channels, nextPage, err := b.api.GetConversations(&slack.GetConversationsParameters{Cursor: pageToken})
-		if err != nil {
-			b.Log.Error("Unable to list channels", zap.Error(err))
-			return err
-		}
-		for _, channel := range channels {
-			b.channels[channel.ID] = channel
-			b.humanChannels[channel.Name] = channel
-		}
-
-		if nextPage == "" {
-			break
-		}
-		pageToken = nextPage
-	}
-
-	users, err := b.api.GetUsers()
+	err = b.directory.sync(b.ctx)
 	if err != nil {
-		b.Log.Error("Unable to list users", zap.Error(err))
+		b.Log.Error("Unable to load users and channels", zap.Error(err))
 		return err
 	}
-	for _, user := range users {
-		b.users[user.ID] = user
-		b.humanUsers[user.Name] = user
-	}
 
-	if v := os.Getenv("COMMIT_SHA"); v != "" {
-		if adminChannel := os.Getenv("ADMIN_SLACK_CHANNEL"); adminChannel != "" {
-			if c, ok := b.humanChannels[adminChannel]; ok {
-				b.Say(c.ID, fmt.Sprintf("I'm back. My version is %s", v))
-			}
-		}
+	if v := b.config.Version; v != "" {
+		b.notifyAdmins(fmt.Sprintf("I'm back. My version is %s", v))
 	}
 
 	return nil
@@ -213,29 +203,46 @@ func (b *Bot) handleEvents() {
 			b.dispatchWebhook(wh)
 
 		// Interaction
-		case ic := <-b.interactionChannel:
-			b.dispatchInteraction(ic)
+		case ir := <-b.interactionChannel:
+			b.dispatchInteraction(ir.ctx, ir.cb)
 		}
 	}
 }
 
 // Start activates the Bot, creating a new API client.
-// It also calls out to the Slack API to obtain all of the channels and users.
+// It also calls out to the Slack API to obtain all of the channels and users, which are kept up to date by
+// Slack events and reloaded hourly.
+//
+// Bots configured with WithSocketMode receive Slack traffic over a socket mode connection,
+// the webhook server is still started to serve plugin webhooks.
 func (b *Bot) Start() {
 	go b.WebhookServer()
+	if b.config.AppToken != "" {
+		go b.SocketModeClient()
+	}
 	go b.handleEvents()
 	err := b.initInfo()
 	if err != nil {
 		panic(err)
 	}
+	b.scheduler.start()
 }
 
 // Stop cancel's the bots main context, closes the DB handle, and disconnects from slack
 func (b *Bot) Stop() {
 	b.cancel()
 	b.wg.Wait()
-	if b.db != nil {
-		b.db.Close()
+	if b.storage != nil {
+		b.storage.Close()
+	}
+	if b.recorder != nil {
+		b.recorder.Close()
+	}
+	if b.shutdownTracing != nil {
+		// Flush the spans that haven't been exported yet, but don't hold up shutting down for long.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = b.shutdownTracing(ctx)
 	}
 }
 
@@ -259,46 +266,137 @@ func (b *Bot) Stop() {
 
 // NewBot creates a new instance of Bot for use.
 //
-// apiKey is the Slack API key that the Bot should use to authenticate
+// The Bot is configured with Options, for example:
 //
-// verificationToken is the webhook token that is used to validate webhooks are coming from slack
+//	bot, err := quadlek.NewBot(ctx,
+//		quadlek.WithConfigFile("quadlek.yaml"),
+//		quadlek.WithApiKey(os.Getenv("SLACK_API_KEY")),
+//	)
 //
-// dbPath is the path to the database on the filesystem.
-func NewBot(parentCtx context.Context, apiKey, verificationToken, dbPath string, debug bool) (*Bot, error) {
+// At a minimum a Slack API key is required, along with either a signing secret to validate webhooks
+// or an app-level token to connect with socket mode.
+func NewBot(parentCtx context.Context, opts ...Option) (*Bot, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		err := opt(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
 	// Seed the RNG with the current time globally
 	rand.Seed(time.Now().UnixNano())
 
-	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	sealer, err := newSealer(cfg.Sealing)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := cfg.openStorage()
 	if err != nil {
 		return nil, err
 	}
 
-	log, err := zap.NewProduction()
+	// Close everything that has been opened if the Bot can't be created. Bolt holds a lock on its file until it is closed.
+	var rec *recorder
+	closeAll := func() {
+		if rec != nil {
+			rec.Close()
+		}
+		backend.Close()
+	}
+
+	log, err := cfg.newLogger()
 	if err != nil {
+		closeAll()
 		return nil, err
 	}
 	zap.ReplaceGlobals(log)
+
+	if cfg.RecordPath != "" {
+		rec, err = openRecorder(cfg.RecordPath)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+
 	ctx, cancel := context.WithCancel(parentCtx)
 
-	return &Bot{
+	tp, shutdownTracing, err := cfg.tracerProvider(ctx)
+	if err != nil {
+		cancel()
+		closeAll()
+		return nil, err
+	}
+
+	apiOpts := []slack.Option{slack.OptionDebug(cfg.Debug)}
+	if cfg.AppToken != "" {
+		apiOpts = append(apiOpts, slack.OptionAppLevelToken(cfg.AppToken))
+	}
+	if cfg.ApiUrl != "" {
+		apiOpts = append(apiOpts, slack.OptionAPIURL(cfg.ApiUrl))
+	}
+
+	m := newMetrics()
+	transport := http.DefaultTransport
+	var con *console
+	if cfg.Console != nil {
+		con = newConsole(log, cfg.Console, cfg.AdminChannel)
+		transport = con
+	}
+	httpClient := &http.Client{Transport: traceTransport(tp, m.transport(transport), func(r *http.Request) string {
+		return "slack " + apiMethod(r)
+	})}
+	apiOpts = append(apiOpts, slack.OptionHTTPClient(httpClient))
+
+	api := slack.New(cfg.ApiKey, apiOpts...)
+	b := &Bot{
 		Log:                  log,
 		ctx:                  ctx,
 		cancel:               cancel,
-		apiKey:               apiKey,
-		verificationToken:    verificationToken,
-		api:                  slack.New(apiKey, slack.OptionDebug(debug)),
-		channels:             make(map[string]slack.Channel, 10),
-		humanChannels:        make(map[string]slack.Channel),
-		humanUsers:           make(map[string]slack.User),
-		users:                make(map[string]slack.User),
+		config:               cfg,
+		api:                  api,
+		httpClient:           httpClient,
+		console:              con,
+		directory:            newDirectory(log, api),
+		events:               newEventDeduper(eventDedupeSize, eventDedupeTTL),
+		pluginIds:            make(map[string]bool),
 		commands:             make(map[string]*registeredCommand),
 		cmdChannel:           make(chan *slashCommand),
 		webhooks:             make(map[string]*registeredWebhook),
 		pluginWebhookChannel: make(chan *PluginWebhook),
-		interactionChannel:   make(chan *slack.InteractionCallback),
+		interactionChannel:   make(chan *interactionRequest),
 		interactions:         make(map[string]*registeredInteraction),
 		reactionHooks:        []*registeredReactionHook{},
 		hooks:                []*registeredHook{},
-		db:                   db,
-	}, nil
+		storage:              backend,
+		sealer:               sealer,
+		recorder:             rec,
+		metrics:              m,
+		tracerProvider:       tp,
+		shutdownTracing:      shutdownTracing,
+		pluginClient:         &http.Client{Transport: traceTransport(tp, http.DefaultTransport, nil)},
+	}
+	m.registerBot(b)
+
+	err = b.RegisterPlugin(b.corePlugin())
+	if err != nil {
+		b.Stop()
+		return nil, err
+	}
+	b.limiter = newRateLimiter(log, b.getStore(corePluginId))
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		b.limiter.run(ctx)
+	}()
+
+	return b, nil
 }
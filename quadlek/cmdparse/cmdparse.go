@@ -0,0 +1,570 @@
+// cmdparse declares the subcommands, arguments and flags that a slash command accepts, and parses the text
+// of an invocation against that declaration.
+//
+// Text is split on whitespace, and single or double quotes (including the curly quotes some Slack clients insert)
+// group words into a single argument. Slack entities such as <@U123|name>, <#C123|name> and <https://example.com>
+// are understood by the User, Channel and URL argument types.
+//
+// When the text doesn't match the declaration, Parse returns a *UsageError with a message suitable for
+// showing to the user.
+package cmdparse
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of an argument or flag.
+type Type int
+
+const (
+	// String is a single word, or a quoted string.
+	String Type = iota
+	// Int is a base 10 integer.
+	Int
+	// Bool is only valid for flags. It is true if the flag is present, or can be set with --flag=false.
+	Bool
+	// User is a Slack user mention, e.g. <@U123|name>. Its value is the user id.
+	User
+	// Channel is a Slack channel mention, e.g. <#C123|name>. Its value is the channel id.
+	Channel
+	// URL is an absolute url, either bare or as a Slack link, e.g. <https://example.com|example>.
+	URL
+	// Rest consumes the remainder of the text as it was typed, quotes and all. It must be the last argument.
+	Rest
+	// UserGroup is a Slack user group mention, e.g. <!subteam^S123|@oncall>. Its value is the user group id.
+	UserGroup
+)
+
+// String returns the name of the type as it is shown in usage messages.
+func (t Type) String() string {
+	switch t {
+	case String:
+		return "string"
+	case Int:
+		return "int"
+	case Bool:
+		return "bool"
+	case User:
+		return "@user"
+	case Channel:
+		return "#channel"
+	case URL:
+		return "url"
+	case Rest:
+		return "text"
+	case UserGroup:
+		return "@group"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
+// Arg is a positional argument.
+type Arg struct {
+	Name        string
+	Type        Type
+	Description string
+	// Optional arguments may be omitted, but only after all of the required arguments.
+	Optional bool
+}
+
+// Flag is a named argument, given as --name value, --name=value, or just --name for Bool flags.
+type Flag struct {
+	Name        string
+	Type        Type
+	Description string
+	// Default is used when the flag isn't given. It is parsed the same way as a value typed by a user.
+	Default string
+}
+
+// Spec declares a command, or a subcommand of another Spec.
+type Spec struct {
+	Name        string
+	Description string
+	Args        []Arg
+	Flags       []Flag
+	Subcommands []*Spec
+	// Examples are full invocations of the command, shown in help.
+	Examples []string
+}
+
+// UsageError is returned when the text of a command doesn't match its Spec.
+type UsageError struct {
+	// Spec is the command or subcommand that was being parsed.
+	Spec *Spec
+	// Path is the name of the command followed by any subcommands that were matched.
+	Path []string
+	Msg  string
+}
+
+// Error returns the problem followed by the usage of the command.
+func (e *UsageError) Error() string {
+	return fmt.Sprintf("%s\nUsage: %s", e.Msg, e.Spec.usage(e.Path))
+}
+
+// Result holds the parsed arguments and flags of a command.
+type Result struct {
+	// Path is the name of the command followed by any subcommands that were matched.
+	Path   []string
+	values map[string]interface{}
+}
+
+// Subcommand returns the subcommands that were matched, separated by spaces. It is empty if none were matched.
+func (r *Result) Subcommand() string {
+	return strings.Join(r.Path[1:], " ")
+}
+
+// Has returns true if the argument or flag was given, or has a default.
+func (r *Result) Has(name string) bool {
+	_, ok := r.values[name]
+	return ok
+}
+
+// String returns the value of a String, User, Channel, UserGroup or Rest argument or flag.
+// User, Channel and UserGroup values are ids, URL values are returned in full.
+func (r *Result) String(name string) string {
+	switch v := r.values[name].(type) {
+	case string:
+		return v
+	case *url.URL:
+		return v.String()
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+// Int returns the value of an Int argument or flag, or 0 if it wasn't given.
+func (r *Result) Int(name string) int {
+	v, _ := r.values[name].(int)
+	return v
+}
+
+// Bool returns the value of a Bool flag, or false if it wasn't given.
+func (r *Result) Bool(name string) bool {
+	v, _ := r.values[name].(bool)
+	return v
+}
+
+// URL returns the value of a URL argument or flag, or nil if it wasn't given.
+func (r *Result) URL(name string) *url.URL {
+	v, _ := r.values[name].(*url.URL)
+	return v
+}
+
+// Usage returns a one line summary of how the command is invoked, e.g. comic <list|del|load>.
+func (s *Spec) Usage() string {
+	return s.usage([]string{s.Name})
+}
+
+// usage returns the summary for the command at path.
+func (s *Spec) usage(path []string) string {
+	sb := &strings.Builder{}
+	sb.WriteString("/")
+	sb.WriteString(strings.Join(path, " "))
+
+	if len(s.Subcommands) > 0 {
+		names := make([]string, 0, len(s.Subcommands))
+		for _, sub := range s.Subcommands {
+			names = append(names, sub.Name)
+		}
+		if len(s.Args) > 0 {
+			fmt.Fprintf(sb, " [%s]", strings.Join(names, "|"))
+		} else {
+			fmt.Fprintf(sb, " <%s>", strings.Join(names, "|"))
+		}
+	}
+
+	for _, f := range s.Flags {
+		if f.Type == Bool {
+			fmt.Fprintf(sb, " [--%s]", f.Name)
+		} else {
+			fmt.Fprintf(sb, " [--%s %s]", f.Name, f.Type)
+		}
+	}
+
+	for _, a := range s.Args {
+		name := a.Name
+		if a.Type == Rest {
+			name += "..."
+		}
+		if a.Optional {
+			fmt.Fprintf(sb, " [%s]", name)
+		} else {
+			fmt.Fprintf(sb, " <%s>", name)
+		}
+	}
+
+	return sb.String()
+}
+
+// Find returns the subcommand at path, e.g. Find("del") for /comic del. It returns nil if there isn't one.
+func (s *Spec) Find(path ...string) *Spec {
+	spec := s
+	for _, name := range path {
+		var next *Spec
+		for _, sub := range spec.Subcommands {
+			if sub.Name == name {
+				next = sub
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		spec = next
+	}
+
+	return spec
+}
+
+// UsageOf returns the usage of the subcommand at path, e.g. UsageOf("del") returns /comic del <id>.
+// It returns an empty string if there is no such subcommand.
+func (s *Spec) UsageOf(path ...string) string {
+	sub := s.Find(path...)
+	if sub == nil {
+		return ""
+	}
+
+	return sub.usage(append([]string{s.Name}, path...))
+}
+
+// Parse parses the text of a command invocation, i.e. everything after the command name.
+func (s *Spec) Parse(text string) (*Result, error) {
+	return s.parse(newTokenizer(text), []string{s.Name})
+}
+
+// parse matches the tokens against the Spec, descending into subcommands as they are found.
+// Tokens are read as they are needed, so a Rest argument can hold text that doesn't tokenize, like a lone quote.
+func (s *Spec) parse(tz *tokenizer, path []string) (*Result, error) {
+	usageErr := func(format string, a ...interface{}) error {
+		return &UsageError{Spec: s, Path: path, Msg: fmt.Sprintf(format, a...)}
+	}
+
+	if len(s.Subcommands) > 0 {
+		// Quoted tokens are never subcommands, so only words are looked at.
+		if word, ok := tz.peekWord(); ok {
+			for _, sub := range s.Subcommands {
+				if strings.EqualFold(sub.Name, word.text) {
+					tz.skip(word)
+					return sub.parse(tz, append(path, sub.Name))
+				}
+			}
+		}
+
+		if len(s.Args) == 0 && !tz.done() {
+			tok, err := tz.next()
+			if err != nil {
+				return nil, usageErr(err.Error())
+			}
+			return nil, usageErr("Unknown subcommand: %s", tok.text)
+		}
+	}
+
+	res := &Result{
+		Path:   path,
+		values: make(map[string]interface{}),
+	}
+
+	for _, f := range s.Flags {
+		if f.Default == "" {
+			continue
+		}
+		v, err := parseValue(f.Type, f.Default)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default for --%s: %w", f.Name, err)
+		}
+		res.values[f.Name] = v
+	}
+
+	argIdx := 0
+	flagsDone := false
+	for !tz.done() {
+		if word, ok := tz.peekWord(); ok && !flagsDone && strings.HasPrefix(word.text, "--") {
+			tz.skip(word)
+			if word.text == "--" {
+				flagsDone = true
+				continue
+			}
+
+			name, value, hasValue := strings.Cut(strings.TrimPrefix(word.text, "--"), "=")
+			flag := s.flag(name)
+			if flag == nil {
+				return nil, usageErr("Unknown flag: --%s", name)
+			}
+
+			if !hasValue {
+				if flag.Type == Bool {
+					value = "true"
+				} else {
+					if tz.done() {
+						return nil, usageErr("--%s needs a %s value", flag.Name, flag.Type)
+					}
+					tok, err := tz.next()
+					if err != nil {
+						return nil, usageErr(err.Error())
+					}
+					value = tok.text
+				}
+			}
+
+			v, err := parseValue(flag.Type, value)
+			if err != nil {
+				return nil, usageErr("Invalid value for --%s: %s", flag.Name, err.Error())
+			}
+			res.values[flag.Name] = v
+			continue
+		}
+
+		if argIdx < len(s.Args) && s.Args[argIdx].Type == Rest {
+			res.values[s.Args[argIdx].Name] = unescapeText(strings.TrimSpace(tz.rest()))
+			argIdx++
+			break
+		}
+
+		tok, err := tz.next()
+		if err != nil {
+			return nil, usageErr(err.Error())
+		}
+		if argIdx >= len(s.Args) {
+			return nil, usageErr("Unexpected argument: %s", tok.text)
+		}
+		arg := s.Args[argIdx]
+		argIdx++
+
+		v, err := parseValue(arg.Type, tok.text)
+		if err != nil {
+			return nil, usageErr("Invalid %s: %s", arg.Name, err.Error())
+		}
+		res.values[arg.Name] = v
+	}
+
+	for _, arg := range s.Args[argIdx:] {
+		if !arg.Optional {
+			return nil, usageErr("Missing %s", arg.Name)
+		}
+	}
+
+	return res, nil
+}
+
+// flag returns the flag with the given name, or nil if there isn't one.
+func (s *Spec) flag(name string) *Flag {
+	for i := range s.Flags {
+		if s.Flags[i].Name == name {
+			return &s.Flags[i]
+		}
+	}
+
+	return nil
+}
+
+// parseValue converts the text of an argument into the Go value for its type.
+func parseValue(t Type, text string) (interface{}, error) {
+	switch t {
+	case String, Rest:
+		return text, nil
+
+	case Int:
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", text)
+		}
+		return i, nil
+
+	case Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not true or false", text)
+		}
+		return b, nil
+
+	case User:
+		id, ok := parseEntity(text, "@")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a user, mention them with @", text)
+		}
+		return id, nil
+
+	case Channel:
+		id, ok := parseEntity(text, "#")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a channel, mention it with #", text)
+		}
+		return id, nil
+
+	case UserGroup:
+		id, ok := parseEntity(text, "!subteam^")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a user group, mention it with @", text)
+		}
+		return id, nil
+
+	case URL:
+		raw := text
+		if strings.HasPrefix(raw, "<") && strings.HasSuffix(raw, ">") {
+			raw, _, _ = strings.Cut(raw[1:len(raw)-1], "|")
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("%q is not a url", text)
+		}
+		return u, nil
+
+	default:
+		return nil, fmt.Errorf("unknown argument type %s", t)
+	}
+}
+
+// parseEntity returns the id from a Slack entity like <@U123|name> for the given sigil.
+func parseEntity(text, sigil string) (string, bool) {
+	if !strings.HasPrefix(text, "<"+sigil) || !strings.HasSuffix(text, ">") {
+		return "", false
+	}
+
+	id, _, _ := strings.Cut(text[len(sigil)+1:len(text)-1], "|")
+	if id == "" {
+		return "", false
+	}
+
+	return id, true
+}
+
+// token is a single word or quoted string from the text of a command.
+type token struct {
+	text string
+	// end is the offset of the rune after the token.
+	end    int
+	quoted bool
+}
+
+// closingQuotes maps each opening quote to the quote that closes it.
+var closingQuotes = map[rune]rune{
+	'"':  '"',
+	'\'': '\'',
+	'“':  '”',
+	'‘':  '’',
+}
+
+// tokenizer splits the text of a command into words, keeping quoted strings together.
+// Slack html-escapes &, < and > in text that isn't part of an entity, so those are unescaped.
+type tokenizer struct {
+	runes []rune
+	// pos is the offset of the next rune to read.
+	pos int
+}
+
+func newTokenizer(text string) *tokenizer {
+	return &tokenizer{runes: []rune(text)}
+}
+
+// skipSpace moves past any whitespace before the next token.
+func (tz *tokenizer) skipSpace() {
+	for tz.pos < len(tz.runes) && isSpace(tz.runes[tz.pos]) {
+		tz.pos++
+	}
+}
+
+// done returns true if there are no tokens left.
+func (tz *tokenizer) done() bool {
+	tz.skipSpace()
+	return tz.pos >= len(tz.runes)
+}
+
+// rest returns the text that hasn't been tokenized, and consumes it.
+func (tz *tokenizer) rest() string {
+	tz.skipSpace()
+	text := string(tz.runes[tz.pos:])
+	tz.pos = len(tz.runes)
+	return text
+}
+
+// peekWord returns the next token without consuming it, if it isn't quoted.
+func (tz *tokenizer) peekWord() (token, bool) {
+	if tz.done() {
+		return token{}, false
+	}
+	if _, ok := closingQuotes[tz.runes[tz.pos]]; ok {
+		return token{}, false
+	}
+
+	return tz.word(), true
+}
+
+// skip consumes a token returned by peekWord.
+func (tz *tokenizer) skip(tok token) {
+	tz.pos = tok.end
+}
+
+// next consumes and returns the next token. It fails if a quoted string isn't closed.
+func (tz *tokenizer) next() (token, error) {
+	tz.skipSpace()
+
+	closing, ok := closingQuotes[tz.runes[tz.pos]]
+	if !ok {
+		tok := tz.word()
+		tz.skip(tok)
+		return tok, nil
+	}
+
+	sb := &strings.Builder{}
+	i := tz.pos + 1
+	for ; i < len(tz.runes) && tz.runes[i] != closing; i++ {
+		if tz.runes[i] == '\\' && i+1 < len(tz.runes) {
+			i++
+		}
+		sb.WriteRune(tz.runes[i])
+	}
+	if i >= len(tz.runes) {
+		return token{}, fmt.Errorf("Missing closing quote")
+	}
+	tz.pos = i + 1
+
+	return token{text: html.UnescapeString(sb.String()), end: tz.pos, quoted: true}, nil
+}
+
+// word returns the unquoted word at the current position.
+func (tz *tokenizer) word() token {
+	i := tz.pos
+	for i < len(tz.runes) && !isSpace(tz.runes[i]) {
+		i++
+	}
+	word := string(tz.runes[tz.pos:i])
+	if !strings.HasPrefix(word, "<") {
+		word = html.UnescapeString(word)
+	}
+
+	return token{text: word, end: i}
+}
+
+// unescapeText html-unescapes text, leaving Slack entities like <@U123|a&amp;b> as they were sent.
+func unescapeText(text string) string {
+	sb := &strings.Builder{}
+	for {
+		start := strings.IndexByte(text, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(text[start:], '>')
+		if end < 0 {
+			break
+		}
+		sb.WriteString(html.UnescapeString(text[:start]))
+		sb.WriteString(text[start : start+end+1])
+		text = text[start+end+1:]
+	}
+	sb.WriteString(html.UnescapeString(text))
+
+	return sb.String()
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == ' '
+}
@@ -0,0 +1,100 @@
+package cmdparse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testSpec = &Spec{
+	Name: "test",
+	Subcommands: []*Spec{
+		{
+			Name: "add",
+			Args: []Arg{
+				{Name: "user", Type: User},
+				{Name: "url", Type: URL},
+				{Name: "note", Type: Rest, Optional: true},
+			},
+			Flags: []Flag{
+				{Name: "count", Type: Int, Default: "1"},
+				{Name: "quiet", Type: Bool},
+				{Name: "channel", Type: Channel},
+				{Name: "group", Type: UserGroup},
+			},
+		},
+		{
+			Name: "say",
+			Args: []Arg{
+				{Name: "a", Type: String},
+				{Name: "b", Type: String},
+			},
+		},
+	},
+}
+
+func TestParse_Entities(t *testing.T) {
+	res, err := testSpec.Parse("add --quiet <@U123|jirwin> <https://example.com/a.gif|example> --count=3 --channel <#C456|general> --group <!subteam^S789|@oncall> hello  there")
+	require.NoError(t, err)
+	require.Equal(t, "add", res.Subcommand())
+	require.Equal(t, "U123", res.String("user"))
+	require.Equal(t, "https://example.com/a.gif", res.URL("url").String())
+	require.Equal(t, "C456", res.String("channel"))
+	require.Equal(t, "S789", res.String("group"))
+	require.Equal(t, 3, res.Int("count"))
+	require.True(t, res.Bool("quiet"))
+	require.Equal(t, "hello  there", res.String("note"))
+}
+
+func TestParse_Defaults(t *testing.T) {
+	res, err := testSpec.Parse("add <@U123> https://example.com")
+	require.NoError(t, err)
+	require.Equal(t, 1, res.Int("count"))
+	require.False(t, res.Bool("quiet"))
+	require.False(t, res.Has("note"))
+}
+
+func TestParse_Quotes(t *testing.T) {
+	res, err := testSpec.Parse(`say "hello world" “it’s &amp; fine”`)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", res.String("a"))
+	require.Equal(t, "it’s & fine", res.String("b"))
+
+	_, err = testSpec.Parse(`say "hello world`)
+	require.Error(t, err)
+}
+
+func TestParse_UsageErrors(t *testing.T) {
+	_, err := testSpec.Parse("nope")
+	require.EqualError(t, err, "Unknown subcommand: nope\nUsage: /test <add|say>")
+
+	_, err = testSpec.Parse("add jirwin https://example.com")
+	require.IsType(t, &UsageError{}, err)
+	require.Contains(t, err.Error(), "Usage: /test add [--count int] [--quiet] [--channel #channel] [--group @group] <user> <url> [note...]")
+
+	_, err = testSpec.Parse("say one")
+	require.EqualError(t, err, "Missing b\nUsage: /test say <a> <b>")
+
+	_, err = testSpec.Parse("say one two three")
+	require.EqualError(t, err, "Unexpected argument: three\nUsage: /test say <a> <b>")
+
+	_, err = testSpec.Parse("add --bogus")
+	require.EqualError(t, err, "Unknown flag: --bogus\nUsage: /test add [--count int] [--quiet] [--channel #channel] [--group @group] <user> <url> [note...]")
+}
+
+func TestParse_Rest(t *testing.T) {
+	spec := &Spec{Name: "gif", Args: []Arg{{Name: "phrase", Type: Rest}}}
+
+	// Rest text isn't tokenized, so unbalanced quotes are kept.
+	res, err := spec.Parse(`'sup`)
+	require.NoError(t, err)
+	require.Equal(t, "'sup", res.String("phrase"))
+
+	res, err = spec.Parse(`tom &amp; jerry &lt;3 <@U123|a&amp;b>`)
+	require.NoError(t, err)
+	require.Equal(t, "tom & jerry <3 <@U123|a&amp;b>", res.String("phrase"))
+
+	res, err = testSpec.Parse(`add <@U123> https://example.com don't "stop"`)
+	require.NoError(t, err)
+	require.Equal(t, `don't "stop"`, res.String("note"))
+}
@@ -0,0 +1,298 @@
+package quadlek
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// Config holds everything needed to configure the core of the Bot.
+// It can be loaded from a YAML or TOML file with LoadConfig, built up with Options, or both.
+//
+// Values in config files may reference environment variables with ${VAR}, which are expanded when the file is read.
+// A $ that isn't part of a ${VAR} reference is left as it is.
+type Config struct {
+	// ApiKey is the Slack API key that the Bot uses to authenticate.
+	ApiKey string `yaml:"api_key" toml:"api_key"`
+	// ApiUrl overrides the base url of the Slack Web API, e.g. to use a proxy or a fake in tests. It must end with a slash.
+	ApiUrl string `yaml:"api_url" toml:"api_url"`
+	// SigningSecret is used to validate that webhooks are coming from Slack.
+	SigningSecret string `yaml:"signing_secret" toml:"signing_secret"`
+	// SigningSecrets are also accepted, so that the signing secret can be rotated without rejecting requests.
+	SigningSecrets []string `yaml:"signing_secrets" toml:"signing_secrets"`
+	// SignatureMaxAge is how old a signed request from Slack may be before it is rejected as a replay. Defaults to 5m.
+	SignatureMaxAge time.Duration `yaml:"signature_max_age" toml:"signature_max_age"`
+	// AppToken is an app-level token (xapp-...). If set, the Bot connects with socket mode.
+	AppToken string `yaml:"app_token" toml:"app_token"`
+	// ListenAddr is the address the webhook server listens on. Defaults to :8000.
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+	// TLSCertFile and TLSKeyFile enable TLS on the webhook server when both are set.
+	TLSCertFile string `yaml:"tls_cert_file" toml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file" toml:"tls_key_file"`
+	// LogLevel is the minimum level that is logged, e.g. debug, info, warn or error. Defaults to info.
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+	// Storage selects the storage backend: bolt, sqlite or memory. Defaults to bolt.
+	Storage string `yaml:"storage" toml:"storage"`
+	// DBPath is the path to the bolt database, or the data source name of the SQLite database. Defaults to quadlek.db.
+	// The SQLite data source name should set a busy timeout, see storage.SQLite.
+	DBPath string `yaml:"db_path" toml:"db_path"`
+	// DBTimeout is how long to wait to obtain the lock on the bolt database. Defaults to 1s.
+	DBTimeout time.Duration `yaml:"db_timeout" toml:"db_timeout"`
+	// SQLDriver is the name of the database/sql driver used for SQLite storage. Defaults to sqlite3.
+	// The driver must be registered by importing it in the program that runs the Bot.
+	SQLDriver string `yaml:"sql_driver" toml:"sql_driver"`
+	// AdminChannel is the name of the channel the Bot announces itself in when it starts.
+	AdminChannel string `yaml:"admin_channel" toml:"admin_channel"`
+	// Admins are the ids of Slack users that always have the admin role.
+	Admins []string `yaml:"admins" toml:"admins"`
+	// Version is announced in the admin channel when the Bot starts.
+	Version string `yaml:"version" toml:"version"`
+	// Debug enables debug logging of Slack API calls.
+	Debug bool `yaml:"debug" toml:"debug"`
+	// Queue configures the queue in front of each handler. Handlers can override it with WithQueue.
+	Queue QueueConfig `yaml:"queue" toml:"queue"`
+	// Sealing configures the keys used to encrypt secrets, such as OAuth tokens, that plugins store.
+	Sealing SealingConfig `yaml:"sealing" toml:"sealing"`
+	// Tracing configures OpenTelemetry tracing.
+	Tracing TracingConfig `yaml:"tracing" toml:"tracing"`
+	// DisableMetrics stops the webhook server from serving Prometheus metrics on /metrics.
+	DisableMetrics bool `yaml:"disable_metrics" toml:"disable_metrics"`
+	// RecordPath is a JSONL file that the requests the Bot receives from Slack are appended to, with tokens and
+	// response urls removed, so they can be replayed with quadlektest. Recording is disabled if it is empty.
+	RecordPath string `yaml:"record_path" toml:"record_path"`
+	// Backup configures scheduled backups of the database.
+	Backup BackupConfig `yaml:"backup" toml:"backup"`
+	// Plugins holds a section per plugin id. Plugins decode their section with Bot.DecodePluginConfig.
+	Plugins map[string]*PluginConfig `yaml:"plugins" toml:"plugins"`
+
+	// Logger overrides the logger the Bot creates from LogLevel.
+	Logger *zap.Logger `yaml:"-" toml:"-"`
+	// Backend overrides the storage backend the Bot opens from Storage.
+	Backend storage.Backend `yaml:"-" toml:"-"`
+	// TracerProvider overrides the TracerProvider the Bot creates from Tracing.
+	TracerProvider trace.TracerProvider `yaml:"-" toml:"-"`
+	// Console runs the Bot against a local console instead of Slack. See WithConsole.
+	Console *ConsoleConfig `yaml:"-" toml:"-"`
+}
+
+// defaultConfig returns the Config that Options and config files are applied on top of.
+func defaultConfig() *Config {
+	return &Config{
+		ListenAddr:      ":8000",
+		SignatureMaxAge: defaultSignatureMaxAge,
+		LogLevel:        "info",
+		Storage:         "bolt",
+		DBPath:          "quadlek.db",
+		DBTimeout:       time.Second,
+		SQLDriver:       "sqlite3",
+		Queue:           defaultQueueConfig,
+		Backup:          BackupConfig{Schedule: "@daily", Keep: 7},
+		Plugins:         make(map[string]*PluginConfig),
+	}
+}
+
+// PluginConfig is a plugin's section of the config. It is kept undecoded until the plugin asks for it,
+// so that each plugin can decode it into its own type.
+type PluginConfig struct {
+	decode func(v interface{}) error
+}
+
+// Decode decodes the plugin's config section into v.
+func (p *PluginConfig) Decode(v interface{}) error {
+	if p == nil || p.decode == nil {
+		return nil
+	}
+
+	return p.decode(v)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (p *PluginConfig) UnmarshalYAML(node *yaml.Node) error {
+	section := *node
+	p.decode = func(v interface{}) error {
+		return section.Decode(v)
+	}
+
+	return nil
+}
+
+// UnmarshalTOML implements toml.Unmarshaler
+func (p *PluginConfig) UnmarshalTOML(data interface{}) error {
+	// The TOML decoder only hands over the generic representation of the table,
+	// so re-encode it to be decoded into the plugin's type later.
+	buf := &bytes.Buffer{}
+	err := toml.NewEncoder(buf).Encode(data)
+	if err != nil {
+		return err
+	}
+
+	section := buf.String()
+	p.decode = func(v interface{}) error {
+		_, err := toml.Decode(section, v)
+		return err
+	}
+
+	return nil
+}
+
+// LoadConfig reads a YAML (.yaml, .yml) or TOML (.toml) config file.
+// Settings that aren't present in the file keep their default values.
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	err := cfg.load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// load reads the config file at path on top of the current values of cfg.
+func (cfg *Config) load(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data := expandEnv(string(raw))
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal([]byte(data), cfg)
+	case ".toml":
+		_, err = toml.Decode(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config file type: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// envRef matches a ${VAR} reference to an environment variable.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references with the value of the environment variable. Unlike os.ExpandEnv, a bare $
+// is left alone, so secrets and keys that contain one are read as they were written.
+func expandEnv(data string) string {
+	return envRef.ReplaceAllStringFunc(data, func(ref string) string {
+		return os.Getenv(envRef.FindStringSubmatch(ref)[1])
+	})
+}
+
+// validate checks that the config contains what is needed to start the Bot.
+func (cfg *Config) validate() error {
+	if cfg.ApiKey == "" && cfg.Console == nil {
+		return fmt.Errorf("a slack api key is required")
+	}
+
+	if cfg.Console != nil && (cfg.Console.In == nil || cfg.Console.Out == nil) {
+		return fmt.Errorf("the console needs an input and an output")
+	}
+
+	if cfg.SigningSecret == "" && len(cfg.SigningSecrets) == 0 && cfg.AppToken == "" && cfg.Console == nil {
+		return fmt.Errorf("a signing secret or an app-level token for socket mode is required")
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("both a tls cert and key are required to enable tls")
+	}
+
+	switch cfg.Storage {
+	case "bolt", "sqlite", "memory":
+	default:
+		return fmt.Errorf("unknown storage %q, must be bolt, sqlite or memory", cfg.Storage)
+	}
+
+	err := cfg.Tracing.validate()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Backup.Dir != "" {
+		_, err := Cron(cfg.Backup.Schedule)
+		if err != nil {
+			return fmt.Errorf("invalid backup schedule %q: %w", cfg.Backup.Schedule, err)
+		}
+	}
+
+	return nil
+}
+
+// openStorage opens the configured storage backend, unless one was provided.
+func (cfg *Config) openStorage() (storage.Backend, error) {
+	if cfg.Backend != nil {
+		return cfg.Backend, nil
+	}
+
+	// The console uses a scratch database, so that trying things out doesn't touch the real one.
+	if cfg.Console != nil {
+		return storage.NewMemory(), nil
+	}
+
+	switch cfg.Storage {
+	case "memory":
+		return storage.NewMemory(), nil
+
+	case "sqlite":
+		db, err := sql.Open(cfg.SQLDriver, cfg.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		backend, err := storage.NewSQLite(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return backend, nil
+
+	default:
+		return storage.OpenBolt(cfg.DBPath, cfg.DBTimeout)
+	}
+}
+
+// newLogger creates the logger for the Bot, unless one was provided.
+func (cfg *Config) newLogger() (*zap.Logger, error) {
+	if cfg.Logger != nil {
+		return cfg.Logger, nil
+	}
+
+	zapCfg := zap.NewProductionConfig()
+	err := zapCfg.Level.UnmarshalText([]byte(cfg.LogLevel))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.LogLevel, err)
+	}
+
+	return zapCfg.Build()
+}
+
+// DecodePluginConfig decodes the config section for pluginId into v.
+// If there is no section for the plugin, v is left untouched so any defaults it holds are kept.
+//
+// Plugins will typically call this from their Load function.
+func (b *Bot) DecodePluginConfig(pluginId string, v interface{}) error {
+	section, ok := b.config.Plugins[pluginId]
+	if !ok {
+		return nil
+	}
+
+	err := section.Decode(v)
+	if err != nil {
+		return fmt.Errorf("error decoding config for plugin %s: %w", pluginId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package quadlek
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestLoadConfigExpandsEnv(t *testing.T) {
+	t.Setenv("QUADLEK_TEST_API_KEY", "xoxb-env")
+
+	path := filepath.Join(t.TempDir(), "quadlek.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("api_key: ${QUADLEK_TEST_API_KEY}\nsigning_secret: a$b$HOME\n"), 0600))
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, "xoxb-env", cfg.ApiKey)
+	require.Equal(t, "a$b$HOME", cfg.SigningSecret)
+}
+
+func TestNewBotClosesStorageOnError(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "quadlek.db")
+
+	_, err := NewBot(context.Background(),
+		WithApiKey("xoxb-test"),
+		WithSigningSecret("secret"),
+		WithDBPath(dbPath),
+		WithLogger(zap.NewNop()),
+		WithRecording(filepath.Join(dir, "missing", "recording.jsonl")),
+	)
+	require.Error(t, err)
+
+	// The bolt lock was released, so the database can be opened again.
+	backend, err := storage.OpenBolt(dbPath, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, backend.Close())
+}
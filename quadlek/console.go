@@ -0,0 +1,429 @@
+package quadlek
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/internal/fakeslack"
+)
+
+const consoleTeamId = "TCONSOLE"
+
+const consoleHelp = `Type a message to send it to the channel, or run a command with /<command> <args>.
+  @quadlek <text>     mention the bot, e.g. @quadlek echo hi
+  :user <name>        send as another user
+  :channel <name>     switch to another channel
+  :react <emoji>      react to the last message in the channel
+  :help               show this help
+  :quit               stop the bot
+`
+
+// ConsoleConfig configures console mode. See WithConsole.
+type ConsoleConfig struct {
+	In  io.Reader
+	Out io.Writer
+	// User is the name of the user lines are sent as. Defaults to developer.
+	User string
+	// Channel is the name of the channel lines are sent to. Defaults to general.
+	Channel string
+}
+
+// console stands in for Slack when the Bot runs in console mode. It reads messages and commands from its input,
+// and serves the Slack Web API from a fake workspace of users and channels, printing everything the Bot sends.
+type console struct {
+	log       *zap.Logger
+	in        io.Reader
+	workspace *fakeslack.Workspace
+
+	// mtx guards everything below, and writes to out.
+	mtx     sync.Mutex
+	out     io.Writer
+	user    string
+	channel string
+}
+
+func newConsole(log *zap.Logger, cfg *ConsoleConfig, adminChannel string) *console {
+	c := &console{
+		log: log,
+		in:  cfg.In,
+		out: cfg.Out,
+	}
+	c.workspace = fakeslack.New(consoleTeamId, fakeslack.Observer{
+		Message: func(msg fakeslack.Message) {
+			c.printMessage(msg.Channel, msg.Text, msg.Ephemeral, msg.Attachments, msg.Blocks.BlockSet)
+		},
+		Reaction: func(reaction fakeslack.Reaction) {
+			c.printf("[%s] %s reacted with :%s:\n", c.where(reaction.Channel), fakeslack.BotName, reaction.Name)
+		},
+		View: c.printView,
+		File: func(file fakeslack.File) {
+			for _, channelId := range file.Channels {
+				c.printMessage(channelId, fmt.Sprintf("uploaded a file: %s %s", file.Name, file.Title), false, nil, nil)
+			}
+		},
+		Unknown: func(method string) {
+			log.Warn("the console doesn't support this slack api method", zap.String("method", method))
+		},
+	})
+
+	user := cfg.User
+	if user == "" {
+		user = "developer"
+	}
+	channel := cfg.Channel
+	if channel == "" {
+		channel = "general"
+	}
+
+	c.user = c.addUser(user).ID
+	c.channel = c.addChannel(channel).ID
+	if adminChannel != "" {
+		c.addChannel(strings.TrimPrefix(adminChannel, "#"))
+	}
+
+	return c
+}
+
+// addUser returns the user named name, adding it to the workspace if it doesn't exist.
+func (c *console) addUser(name string) slack.User {
+	name = strings.TrimPrefix(name, "@")
+	if user, ok := c.workspace.UserByName(name); ok {
+		return user
+	}
+
+	return c.workspace.AddUser("U"+strings.ToUpper(name), name)
+}
+
+// addChannel returns the channel named name, adding it to the workspace if it doesn't exist.
+func (c *console) addChannel(name string) slack.Channel {
+	name = strings.TrimPrefix(name, "#")
+	if channel, ok := c.workspace.ChannelByName(name); ok {
+		return channel
+	}
+
+	return c.workspace.AddChannel("C"+strings.ToUpper(name), name)
+}
+
+// current returns the user and channel lines are sent as.
+func (c *console) current() (slack.User, slack.Channel) {
+	c.mtx.Lock()
+	userId, channelId := c.user, c.channel
+	c.mtx.Unlock()
+
+	user, _ := c.workspace.User(userId)
+	channel, _ := c.workspace.Channel(channelId)
+	return user, channel
+}
+
+func (c *console) printf(format string, args ...interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	fmt.Fprintf(c.out, format, args...)
+}
+
+// run reads lines from the console until its input is closed or :quit is entered.
+func (c *console) run(b *Bot) error {
+	c.printf("%s", consoleHelp)
+
+	scanner := bufio.NewScanner(c.in)
+	for scanner.Scan() {
+		if b.ctx.Err() != nil {
+			return nil
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case line == ":quit":
+			return nil
+		case strings.HasPrefix(line, ":"):
+			c.control(b, line)
+		case strings.HasPrefix(line, "/"):
+			c.command(b, line)
+		default:
+			c.message(b, line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// control handles the console's own :commands.
+func (c *console) control(b *Bot, line string) {
+	name, arg := splitCommand(line[1:])
+
+	switch {
+	case name == "help":
+		c.printf("%s", consoleHelp)
+
+	case name == "user" && arg != "":
+		user := c.addUser(arg)
+		c.mtx.Lock()
+		c.user = user.ID
+		c.mtx.Unlock()
+
+	case name == "channel" && arg != "":
+		channel := c.addChannel(arg)
+		c.mtx.Lock()
+		c.channel = channel.ID
+		c.mtx.Unlock()
+
+	case name == "react" && arg != "":
+		user, channel := c.current()
+		history := c.workspace.History(channel.ID)
+		if len(history) == 0 {
+			c.printf("There are no messages in #%s to react to.\n", channel.Name)
+			return
+		}
+		last := history[len(history)-1]
+
+		b.dispatchEvent(context.Background(), consoleEvent("reaction_added", &slackevents.ReactionAddedEvent{
+			Type:     "reaction_added",
+			User:     user.ID,
+			Reaction: strings.Trim(arg, ":"),
+			ItemUser: last.User,
+			Item: slackevents.Item{
+				Type:      "message",
+				Channel:   channel.ID,
+				Timestamp: last.Timestamp,
+			},
+			EventTimestamp: last.Timestamp,
+		}))
+
+	default:
+		c.printf("Unknown console command %q, type :help for help.\n", line)
+	}
+}
+
+// command runs a slash command, and prints the reply once the plugin responds.
+func (c *console) command(b *Bot, line string) {
+	name, text := splitCommand(line[1:])
+	if b.GetCommand(name) == nil {
+		c.printf("Unknown command /%s.\n", name)
+		return
+	}
+
+	user, channel := c.current()
+	resp, replied := b.runSlashCommand(&slashCommand{
+		TeamId:      consoleTeamId,
+		ChannelId:   channel.ID,
+		ChannelName: channel.Name,
+		UserId:      user.ID,
+		UserName:    user.Name,
+		Command:     "/" + name,
+		Text:        text,
+		ResponseUrl: consoleResponseUrl(channel.ID, user.ID),
+	})
+	if !replied || resp == nil {
+		return
+	}
+
+	prepareSlashCommandResp(resp)
+	c.printMessage(channel.ID, resp.Text, !resp.InChannel, resp.Attachments, resp.Blocks)
+	if resp.InChannel {
+		c.workspace.AddMessage(channel.ID, "", resp.Text)
+	}
+}
+
+// message sends a message to the Bot's hooks. Messages that start with @quadlek also mention the Bot.
+func (c *console) message(b *Bot, text string) {
+	mention := strings.HasPrefix(text, "@"+fakeslack.BotName+" ")
+	if mention {
+		text = fmt.Sprintf("<@%s> %s", fakeslack.BotUserId, strings.TrimPrefix(text, "@"+fakeslack.BotName+" "))
+	}
+
+	user, channel := c.current()
+	ts := c.workspace.AddMessage(channel.ID, user.ID, text)
+
+	b.dispatchEvent(context.Background(), consoleEvent("message", &slackevents.MessageEvent{
+		Type:           "message",
+		User:           user.ID,
+		Text:           text,
+		TimeStamp:      ts,
+		Channel:        channel.ID,
+		ChannelType:    "channel",
+		EventTimeStamp: ts,
+	}))
+
+	if mention {
+		b.dispatchEvent(context.Background(), consoleEvent("app_mention", &slackevents.AppMentionEvent{
+			Type:           "app_mention",
+			User:           user.ID,
+			Text:           text,
+			TimeStamp:      ts,
+			Channel:        channel.ID,
+			EventTimeStamp: ts,
+		}))
+	}
+}
+
+// consoleEvent wraps an inner event the way the Events API delivers it.
+func consoleEvent(eventType string, data interface{}) slackevents.EventsAPIEvent {
+	return slackevents.EventsAPIEvent{
+		Type:   slackevents.CallbackEvent,
+		TeamID: consoleTeamId,
+		InnerEvent: slackevents.EventsAPIInnerEvent{
+			Type: eventType,
+			Data: data,
+		},
+	}
+}
+
+// splitCommand splits a line into its first word and the rest of the line.
+func splitCommand(line string) (string, string) {
+	name, args, _ := strings.Cut(line, " ")
+	return name, strings.TrimSpace(args)
+}
+
+// consoleResponseUrl returns the response url of a slash command run by userId in channelId.
+func consoleResponseUrl(channelId, userId string) string {
+	return fmt.Sprintf("http://console/response/%s/%s", channelId, userId)
+}
+
+// printMessage prints a message the Bot sent to a channel. The caller must not hold the lock.
+func (c *console) printMessage(channelId, text string, ephemeral bool, attachments []slack.Attachment, blocks []slack.Block) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.writeMessage(channelId, text, ephemeral, attachments, blocks)
+}
+
+// where returns how a channel is shown on the console.
+func (c *console) where(channelId string) string {
+	channel, ok := c.workspace.Channel(channelId)
+	if !ok {
+		return channelId
+	}
+	if channel.IsIM {
+		user, _ := c.workspace.User(channel.User)
+		return "@" + user.Name
+	}
+
+	return "#" + channel.Name
+}
+
+// writeMessage prints a message the Bot sent to a channel. The caller must hold the lock.
+func (c *console) writeMessage(channelId, text string, ephemeral bool, attachments []slack.Attachment, blocks []slack.Block) {
+	where := c.where(channelId)
+	who := fakeslack.BotName
+	if ephemeral {
+		who += " (only visible to you)"
+	}
+
+	var lines []string
+	if text != "" {
+		lines = append(lines, strings.Split(text, "\n")...)
+	}
+	for _, block := range blocks {
+		lines = append(lines, blockLines(block)...)
+	}
+	for _, attachment := range attachments {
+		for _, s := range []string{attachment.Pretext, attachment.Title, attachment.TitleLink, attachment.Text, attachment.ImageURL} {
+			if s != "" {
+				lines = append(lines, "| "+s)
+			}
+		}
+	}
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+
+	fmt.Fprintf(c.out, "[%s] %s: %s\n", where, who, lines[0])
+	for _, line := range lines[1:] {
+		fmt.Fprintf(c.out, "    %s\n", line)
+	}
+}
+
+// blockLines renders the text of a block.
+func blockLines(block slack.Block) []string {
+	switch b := block.(type) {
+	case *slack.HeaderBlock:
+		return []string{"*" + b.Text.Text + "*"}
+	case *slack.SectionBlock:
+		var lines []string
+		if b.Text != nil {
+			lines = append(lines, strings.Split(b.Text.Text, "\n")...)
+		}
+		for _, field := range b.Fields {
+			lines = append(lines, field.Text)
+		}
+		return lines
+	case *slack.ContextBlock:
+		var texts []string
+		for _, element := range b.ContextElements.Elements {
+			if text, ok := element.(*slack.TextBlockObject); ok {
+				texts = append(texts, text.Text)
+			}
+		}
+		return []string{strings.Join(texts, " ")}
+	case *slack.ImageBlock:
+		return []string{b.ImageURL}
+	case *slack.DividerBlock:
+		return []string{"---"}
+	}
+
+	return nil
+}
+
+// printView prints a modal the Bot opened.
+func (c *console) printView(view slack.ModalViewRequest) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	title := ""
+	if view.Title != nil {
+		title = view.Title.Text
+	}
+	fmt.Fprintf(c.out, "[modal] %s: %s\n", fakeslack.BotName, title)
+	for _, block := range view.Blocks.BlockSet {
+		for _, line := range blockLines(block) {
+			fmt.Fprintf(c.out, "    %s\n", line)
+		}
+	}
+}
+
+// RoundTrip serves the Bot's requests to the Slack Web API and to slash command response urls from the workspace.
+func (c *console) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	w := httptest.NewRecorder()
+	c.workspace.ServeHTTP(w, req)
+	resp := w.Result()
+	resp.Request = req
+
+	return resp, nil
+}
+
+// RunConsole runs a Bot created with WithConsole. Lines read from the console are sent to the Bot as messages,
+// and lines like /command args run slash commands, with everything the Bot sends printed to the console.
+//
+// RunConsole is used instead of Start, and returns once the console's input is closed or :quit is entered.
+// The Bot still needs to be stopped with Stop.
+func (b *Bot) RunConsole() error {
+	if b.console == nil {
+		return errors.New("the bot wasn't created with WithConsole")
+	}
+
+	go b.handleEvents()
+	err := b.initInfo()
+	if err != nil {
+		return err
+	}
+	b.scheduler.start()
+
+	return b.console.run(b)
+}
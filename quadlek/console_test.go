@@ -0,0 +1,132 @@
+package quadlek
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// consoleOutput is a bytes.Buffer that is safe to read while the console writes to it.
+type consoleOutput struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *consoleOutput) Write(p []byte) (int, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *consoleOutput) String() string {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return o.buf.String()
+}
+
+func TestConsole(t *testing.T) {
+	in, input := io.Pipe()
+	out := &consoleOutput{}
+
+	b, err := NewBot(context.Background(), WithConsole(in, out), WithLogger(zaptest.NewLogger(t)))
+	require.NoError(t, err)
+	defer b.Stop()
+
+	err = b.RegisterPlugin(MakePlugin(
+		"test",
+		[]Command{
+			CommandFunc("echo", func(ctx context.Context, cmdMsg *CommandMsg) (*CommandResp, error) {
+				return &CommandResp{Text: cmdMsg.Command.UserName + " said " + cmdMsg.Command.Text}, nil
+			}),
+			CommandFunc("later", func(ctx context.Context, cmdMsg *CommandMsg) (*CommandResp, error) {
+				err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &CommandResp{Text: "done", InChannel: true})
+				return nil, err
+			}),
+		},
+		[]Hook{
+			HookFunc(func(ctx context.Context, hookMsg *HookMsg) error {
+				if hookMsg.Msg.Text == "ping" {
+					hookMsg.Bot.Say(hookMsg.Msg.Channel, "pong")
+					hookMsg.Bot.React(hookMsg.Msg, "eyes")
+				}
+				return nil
+			}),
+		},
+		[]ReactionHook{
+			ReactionHookFunc(func(ctx context.Context, rhMsg *ReactionHookMsg) error {
+				user, err := rhMsg.Bot.GetUserName(rhMsg.Reaction.User)
+				if err != nil {
+					return err
+				}
+				rhMsg.Bot.Say(rhMsg.Reaction.Item.Channel, fmt.Sprintf("%s reacted with %s", user, rhMsg.Reaction.Reaction))
+				return nil
+			}),
+		},
+		nil,
+		nil,
+	))
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.RunConsole()
+	}()
+
+	waitFor := func(s string) {
+		t.Helper()
+		require.Eventually(t, func() bool { return strings.Contains(out.String(), s) }, 5*time.Second, 10*time.Millisecond, "waiting for %q in:\n%s", s, out.String())
+	}
+	send := func(line string) {
+		t.Helper()
+		_, err := fmt.Fprintln(input, line)
+		require.NoError(t, err)
+	}
+
+	send("/echo hello")
+	waitFor("[#general] quadlek (only visible to you): developer said hello\n")
+
+	send("/later")
+	waitFor("[#general] quadlek: done\n")
+
+	send(":user jirwin")
+	send(":channel random")
+	send("ping")
+	waitFor("[#random] quadlek: pong\n")
+	waitFor("[#random] quadlek reacted with :eyes:\n")
+
+	send(":react tada")
+	waitFor("[#random] quadlek: jirwin reacted with tada\n")
+
+	send("@quadlek echo hi")
+	waitFor("[#random] quadlek (only visible to you): jirwin said hi\n")
+
+	send("/nope")
+	waitFor("Unknown command /nope.\n")
+
+	send(":quit")
+	require.NoError(t, <-done)
+}
+
+func TestConsoleConfig(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Console = &ConsoleConfig{In: strings.NewReader(""), Out: io.Discard}
+	require.NoError(t, cfg.validate())
+
+	backend, err := cfg.openStorage()
+	require.NoError(t, err)
+	defer backend.Close()
+	require.IsType(t, &storage.Memory{}, backend)
+
+	cfg.Console.Out = nil
+	require.Error(t, cfg.validate())
+}
@@ -0,0 +1,107 @@
+package quadlek
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+	"go.uber.org/zap"
+)
+
+// eventDedupeTTL is how long an event id is remembered. Slack retries an event three times over about five minutes.
+const eventDedupeTTL = 15 * time.Minute
+
+// eventDedupeSize is the most event ids that are remembered at once. The oldest are forgotten first.
+const eventDedupeSize = 10000
+
+// seenEvent is an event id and when it was first delivered.
+type seenEvent struct {
+	id string
+	at time.Time
+}
+
+// eventDeduper remembers recently delivered event ids, so that events Slack redelivers are only dispatched once.
+type eventDeduper struct {
+	mtx        sync.Mutex
+	ttl        time.Duration
+	size       int
+	ids        map[string]*list.Element
+	order      *list.List
+	duplicates uint64
+	now        func() time.Time
+}
+
+// newEventDeduper returns an eventDeduper that remembers up to size event ids for ttl.
+func newEventDeduper(size int, ttl time.Duration) *eventDeduper {
+	return &eventDeduper{
+		ttl:   ttl,
+		size:  size,
+		ids:   make(map[string]*list.Element),
+		order: list.New(),
+		now:   time.Now,
+	}
+}
+
+// seen records an event id, and returns true if it has already been recorded.
+func (d *eventDeduper) seen(id string) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	now := d.now()
+	// Ids are recorded in order, so the expired ones are at the front.
+	for e := d.order.Front(); e != nil; e = d.order.Front() {
+		ev := e.Value.(seenEvent)
+		if now.Sub(ev.at) < d.ttl && d.order.Len() < d.size {
+			break
+		}
+		d.order.Remove(e)
+		delete(d.ids, ev.id)
+	}
+
+	if _, ok := d.ids[id]; ok {
+		d.duplicates++
+		return true
+	}
+	d.ids[id] = d.order.PushBack(seenEvent{id: id, at: now})
+
+	return false
+}
+
+// duplicateCount returns how many duplicate events have been seen.
+func (d *eventDeduper) duplicateCount() uint64 {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.duplicates
+}
+
+// duplicateEvent returns true if ev has already been delivered. retryNum and retryReason are the retry details
+// Slack sent with the delivery, if any, and are only used for logging.
+func (b *Bot) duplicateEvent(ev slackevents.EventsAPIEvent, retryNum int, retryReason string) bool {
+	cb, ok := ev.Data.(*slackevents.EventsAPICallbackEvent)
+	if !ok || cb.EventID == "" {
+		return false
+	}
+
+	if !b.events.seen(cb.EventID) {
+		if retryNum > 0 {
+			b.Log.Info("processing retried event",
+				zap.String("event_id", cb.EventID),
+				zap.Int("retry_num", retryNum),
+				zap.String("retry_reason", retryReason),
+			)
+		}
+		return false
+	}
+
+	b.Log.Info("dropping duplicate event",
+		zap.String("event_id", cb.EventID),
+		zap.String("event_type", ev.InnerEvent.Type),
+		zap.Int("retry_num", retryNum),
+		zap.String("retry_reason", retryReason),
+		zap.Uint64("duplicates", b.events.duplicateCount()),
+	)
+
+	return true
+}
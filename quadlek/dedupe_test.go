@@ -0,0 +1,69 @@
+package quadlek
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestEventDeduper(t *testing.T) {
+	d := newEventDeduper(2, time.Minute)
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	require.False(t, d.seen("Ev1"))
+	require.True(t, d.seen("Ev1"))
+	require.False(t, d.seen("Ev2"))
+	require.Equal(t, uint64(1), d.duplicateCount())
+
+	// The oldest id is forgotten once the deduper is full.
+	require.False(t, d.seen("Ev3"))
+	require.False(t, d.seen("Ev1"))
+
+	// Ids are forgotten after the ttl.
+	now = now.Add(time.Minute)
+	require.False(t, d.seen("Ev3"))
+	require.Equal(t, uint64(1), d.duplicateCount())
+}
+
+func TestHandleSlackEventDuplicates(t *testing.T) {
+	b := &Bot{
+		Log:       zap.NewNop(),
+		config:    &Config{SigningSecret: "secret"},
+		directory: newDirectory(zap.NewNop(), nil),
+		events:    newEventDeduper(eventDedupeSize, eventDedupeTTL),
+	}
+
+	deliver := func(userName string, retryNum int) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"type": "event_callback", "event_id": "Ev1", "event": {"type": "team_join", "user": {"id": "U1", "name": "%s"}}}`, userName)
+		r := signedRequest("/slack/event", body, "secret", time.Now())
+		if retryNum > 0 {
+			r.Header.Set("X-Slack-Retry-Num", strconv.Itoa(retryNum))
+			r.Header.Set("X-Slack-Retry-Reason", "http_timeout")
+		}
+
+		w := httptest.NewRecorder()
+		b.slackVerifier().Middleware(b.Log, http.HandlerFunc(b.handleSlackEvent)).ServeHTTP(w, r)
+		return w
+	}
+
+	w := deliver("jirwin", 0)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, w.Flushed)
+
+	// The retry is acknowledged, but not dispatched again.
+	w = deliver("retried", 1)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, uint64(1), b.events.duplicateCount())
+
+	userId, err := b.directory.userId(context.Background(), "jirwin")
+	require.NoError(t, err)
+	require.Equal(t, "U1", userId)
+}
@@ -0,0 +1,363 @@
+package quadlek
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"go.uber.org/zap"
+)
+
+// directoryResyncInterval is how often every user and channel is reloaded from Slack,
+// in case the directory missed an event.
+const directoryResyncInterval = time.Hour
+
+// directoryMissInterval is the least time between reloads caused by looking up a name that isn't in the directory.
+// Slack can only look up users and channels by id, so a name can only be found by reloading everything.
+const directoryMissInterval = time.Minute
+
+// conversationTypes are the kinds of conversations the directory loads.
+var conversationTypes = []string{"public_channel", "private_channel", "mpim", "im"}
+
+var (
+	errChannelNotFound = errors.New("Channel not found.")
+	errUserNotFound    = errors.New("User not found.")
+)
+
+// directory caches the workspace's users and channels. It is kept up to date by Slack events and periodic resyncs,
+// and looks up ids it doesn't know with the Slack API. It is safe for concurrent use.
+type directory struct {
+	api *slack.Client
+	log *zap.Logger
+	now func() time.Time
+
+	mtx          sync.RWMutex
+	channels     map[string]slack.Channel
+	channelIds   map[string]string
+	users        map[string]slack.User
+	userIds      map[string]string
+	channelsSync time.Time
+	usersSync    time.Time
+}
+
+// newDirectory returns an empty directory that loads users and channels with api.
+func newDirectory(log *zap.Logger, api *slack.Client) *directory {
+	return &directory{
+		api:        api,
+		log:        log,
+		now:        time.Now,
+		channels:   make(map[string]slack.Channel),
+		channelIds: make(map[string]string),
+		users:      make(map[string]slack.User),
+		userIds:    make(map[string]string),
+	}
+}
+
+// sync reloads every user and channel.
+func (d *directory) sync(ctx context.Context) error {
+	err := d.syncChannels(ctx)
+	if err != nil {
+		return err
+	}
+
+	return d.syncUsers(ctx)
+}
+
+// syncChannels reloads every channel, including private channels and DMs if the bot has the scopes to list them.
+func (d *directory) syncChannels(ctx context.Context) error {
+	channels, err := d.listChannels(ctx, conversationTypes)
+	var slackErr slack.SlackErrorResponse
+	if errors.As(err, &slackErr) && slackErr.Err == "missing_scope" {
+		d.log.Warn("missing the scopes to list private channels and DMs, only public channels are cached")
+		channels, err = d.listChannels(ctx, conversationTypes[:1])
+	}
+	if err != nil {
+		return err
+	}
+
+	byId := make(map[string]slack.Channel, len(channels))
+	for _, channel := range channels {
+		byId[channel.ID] = channel
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.channels = byId
+	d.channelIds = make(map[string]string, len(byId))
+	for _, channel := range byId {
+		d.indexChannel(channel)
+	}
+	d.channelsSync = d.now()
+
+	return nil
+}
+
+// listChannels lists every conversation of the given types.
+func (d *directory) listChannels(ctx context.Context, types []string) ([]slack.Channel, error) {
+	var channels []slack.Channel
+	cursor := ""
+	for {
+		page, next, err := d.api.GetConversationsContext(ctx, &slack.GetConversationsParameters{
+			Cursor: cursor,
+			Limit:  1000,
+			Types:  types,
+		})
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, page...)
+
+		if next == "" {
+			return channels, nil
+		}
+		cursor = next
+	}
+}
+
+// syncUsers reloads every user.
+func (d *directory) syncUsers(ctx context.Context) error {
+	users, err := d.api.GetUsersContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.users = make(map[string]slack.User, len(users))
+	d.userIds = make(map[string]string, len(users))
+	for _, user := range users {
+		d.users[user.ID] = user
+		d.userIds[user.Name] = user.ID
+	}
+	d.usersSync = d.now()
+
+	return nil
+}
+
+// indexChannel adds channel's name to the name index. Archived channels and DMs aren't indexed.
+// The caller must hold the write lock.
+func (d *directory) indexChannel(channel slack.Channel) {
+	if channel.Name == "" || channel.IsArchived {
+		return
+	}
+	d.channelIds[channel.Name] = channel.ID
+}
+
+// unindexChannel removes channel's name from the name index, if it still points at channel.
+// The caller must hold the write lock.
+func (d *directory) unindexChannel(channel slack.Channel) {
+	if d.channelIds[channel.Name] == channel.ID {
+		delete(d.channelIds, channel.Name)
+	}
+}
+
+// putChannel adds or replaces a channel.
+func (d *directory) putChannel(channel slack.Channel) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if old, ok := d.channels[channel.ID]; ok {
+		d.unindexChannel(old)
+	}
+	d.channels[channel.ID] = channel
+	d.indexChannel(channel)
+}
+
+// updateChannel applies update to a cached channel. Channels that aren't cached are ignored,
+// they are loaded when they are next looked up.
+func (d *directory) updateChannel(channelId string, update func(channel *slack.Channel)) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	channel, ok := d.channels[channelId]
+	if !ok {
+		return
+	}
+	d.unindexChannel(channel)
+	update(&channel)
+	d.channels[channelId] = channel
+	d.indexChannel(channel)
+}
+
+// deleteChannel removes a channel.
+func (d *directory) deleteChannel(channelId string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if channel, ok := d.channels[channelId]; ok {
+		d.unindexChannel(channel)
+		delete(d.channels, channelId)
+	}
+}
+
+// putUser adds or replaces a user.
+func (d *directory) putUser(user slack.User) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if old, ok := d.users[user.ID]; ok && d.userIds[old.Name] == old.ID {
+		delete(d.userIds, old.Name)
+	}
+	d.users[user.ID] = user
+	d.userIds[user.Name] = user.ID
+}
+
+// channel returns the channel with the given id, looking it up with the Slack API if it isn't cached.
+func (d *directory) channel(ctx context.Context, channelId string) (*slack.Channel, error) {
+	d.mtx.RLock()
+	channel, ok := d.channels[channelId]
+	d.mtx.RUnlock()
+	if ok {
+		return &channel, nil
+	}
+
+	found, err := d.api.GetConversationInfoContext(ctx, channelId, false)
+	if err != nil {
+		d.log.Debug("error looking up channel", zap.String("channel", channelId), zap.Error(err))
+		return nil, errChannelNotFound
+	}
+	d.putChannel(*found)
+
+	return found, nil
+}
+
+// channelId returns the id of the channel with the given name. Unknown names reload every channel,
+// at most once every directoryMissInterval.
+func (d *directory) channelId(ctx context.Context, name string) (string, error) {
+	d.mtx.RLock()
+	channelId, ok := d.channelIds[name]
+	stale := d.now().Sub(d.channelsSync) >= directoryMissInterval
+	d.mtx.RUnlock()
+	if ok {
+		return channelId, nil
+	}
+	if !stale {
+		return "", errChannelNotFound
+	}
+
+	err := d.syncChannels(ctx)
+	if err != nil {
+		d.log.Error("error reloading channels", zap.Error(err))
+		return "", errChannelNotFound
+	}
+
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	channelId, ok = d.channelIds[name]
+	if !ok {
+		return "", errChannelNotFound
+	}
+
+	return channelId, nil
+}
+
+// user returns the user with the given id, looking it up with the Slack API if it isn't cached.
+func (d *directory) user(ctx context.Context, userId string) (*slack.User, error) {
+	d.mtx.RLock()
+	user, ok := d.users[userId]
+	d.mtx.RUnlock()
+	if ok {
+		return &user, nil
+	}
+
+	found, err := d.api.GetUserInfoContext(ctx, userId)
+	if err != nil {
+		d.log.Debug("error looking up user", zap.String("user", userId), zap.Error(err))
+		return nil, errUserNotFound
+	}
+	d.putUser(*found)
+
+	return found, nil
+}
+
+// userId returns the id of the user with the given name. Unknown names reload every user,
+// at most once every directoryMissInterval.
+func (d *directory) userId(ctx context.Context, name string) (string, error) {
+	d.mtx.RLock()
+	userId, ok := d.userIds[name]
+	stale := d.now().Sub(d.usersSync) >= directoryMissInterval
+	d.mtx.RUnlock()
+	if ok {
+		return userId, nil
+	}
+	if !stale {
+		return "", errUserNotFound
+	}
+
+	err := d.syncUsers(ctx)
+	if err != nil {
+		d.log.Error("error reloading users", zap.Error(err))
+		return "", errUserNotFound
+	}
+
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	userId, ok = d.userIds[name]
+	if !ok {
+		return "", errUserNotFound
+	}
+
+	return userId, nil
+}
+
+// handleEvent updates the directory from a Slack event. It returns false if the event isn't one the directory handles.
+func (d *directory) handleEvent(ev interface{}) bool {
+	switch ev := ev.(type) {
+	case *slackevents.ChannelCreatedEvent:
+		channel := slack.Channel{IsChannel: ev.Channel.IsChannel}
+		channel.ID = ev.Channel.ID
+		channel.Name = ev.Channel.Name
+		channel.Creator = ev.Channel.Creator
+		channel.Created = slack.JSONTime(ev.Channel.Created)
+		d.putChannel(channel)
+
+	case *slackevents.ChannelRenameEvent:
+		d.updateChannel(ev.Channel.ID, func(channel *slack.Channel) { channel.Name = ev.Channel.Name })
+	case *slackevents.GroupRenameEvent:
+		d.updateChannel(ev.Channel.ID, func(channel *slack.Channel) { channel.Name = ev.Channel.Name })
+
+	case *slackevents.ChannelArchiveEvent:
+		d.updateChannel(ev.Channel, func(channel *slack.Channel) { channel.IsArchived = true })
+	case *slackevents.GroupArchiveEvent:
+		d.updateChannel(ev.Channel, func(channel *slack.Channel) { channel.IsArchived = true })
+	case *slackevents.ChannelUnarchiveEvent:
+		d.updateChannel(ev.Channel, func(channel *slack.Channel) { channel.IsArchived = false })
+	case *slackevents.GroupUnarchiveEvent:
+		d.updateChannel(ev.Channel, func(channel *slack.Channel) { channel.IsArchived = false })
+
+	case *slackevents.ChannelDeletedEvent:
+		d.deleteChannel(ev.Channel)
+	case *slackevents.GroupDeletedEvent:
+		d.deleteChannel(ev.Channel)
+
+	case *slackevents.ChannelIDChangedEvent:
+		d.mtx.RLock()
+		channel, ok := d.channels[ev.OldChannelID]
+		d.mtx.RUnlock()
+		if ok {
+			d.deleteChannel(ev.OldChannelID)
+			channel.ID = ev.NewChannelID
+			d.putChannel(channel)
+		}
+
+	case *slackevents.TeamJoinEvent:
+		if ev.User != nil {
+			d.putUser(*ev.User)
+		}
+	case *slack.UserChangeEvent:
+		d.putUser(ev.User)
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// resyncDirectory is the core scheduled task that reloads every user and channel.
+func (b *Bot) resyncDirectory(ctx context.Context, msg *ScheduledTaskMsg) error {
+	return b.directory.sync(ctx)
+}
@@ -0,0 +1,229 @@
+package quadlek
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeDirectoryApi serves the Slack API methods the directory uses.
+type fakeDirectoryApi struct {
+	mtx        sync.Mutex
+	channels   []map[string]interface{}
+	users      []map[string]interface{}
+	listCalls  int32
+	scopeError bool
+}
+
+func (f *fakeDirectoryApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	_ = r.ParseForm()
+
+	resp := map[string]interface{}{"ok": true}
+	switch r.URL.Path {
+	case "/conversations.list":
+		atomic.AddInt32(&f.listCalls, 1)
+		if f.scopeError && r.Form.Get("types") != "public_channel" {
+			resp = map[string]interface{}{"ok": false, "error": "missing_scope"}
+			break
+		}
+		// Serve one channel per page to exercise pagination.
+		i := 0
+		if cursor := r.Form.Get("cursor"); cursor != "" {
+			i = int(cursor[0] - '0')
+		}
+		resp["channels"] = f.channels[i : i+1]
+		if i+1 < len(f.channels) {
+			resp["response_metadata"] = map[string]string{"next_cursor": string(rune('0' + i + 1))}
+		}
+	case "/users.list":
+		resp["members"] = f.users
+	case "/users.info":
+		resp = map[string]interface{}{"ok": false, "error": "user_not_found"}
+		for _, user := range f.users {
+			if user["id"] == r.Form.Get("user") {
+				resp = map[string]interface{}{"ok": true, "user": user}
+			}
+		}
+	case "/conversations.info":
+		resp = map[string]interface{}{"ok": false, "error": "channel_not_found"}
+		for _, channel := range f.channels {
+			if channel["id"] == r.Form.Get("channel") {
+				resp = map[string]interface{}{"ok": true, "channel": channel}
+			}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newTestDirectory(t *testing.T) (*directory, *fakeDirectoryApi) {
+	fake := &fakeDirectoryApi{
+		channels: []map[string]interface{}{
+			{"id": "C1", "name": "general", "is_channel": true},
+			{"id": "G1", "name": "secret", "is_private": true},
+			{"id": "D1", "is_im": true, "user": "U1"},
+		},
+		users: []map[string]interface{}{
+			{"id": "U1", "name": "jirwin"},
+		},
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	api := slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"))
+	return newDirectory(zap.NewNop(), api), fake
+}
+
+func TestDirectorySync(t *testing.T) {
+	d, _ := newTestDirectory(t)
+	ctx := context.Background()
+	require.NoError(t, d.sync(ctx))
+
+	channelId, err := d.channelId(ctx, "general")
+	require.NoError(t, err)
+	require.Equal(t, "C1", channelId)
+	channelId, err = d.channelId(ctx, "secret")
+	require.NoError(t, err)
+	require.Equal(t, "G1", channelId)
+
+	dm, err := d.channel(ctx, "D1")
+	require.NoError(t, err)
+	require.Equal(t, "U1", dm.User)
+
+	userId, err := d.userId(ctx, "jirwin")
+	require.NoError(t, err)
+	require.Equal(t, "U1", userId)
+}
+
+func TestDirectorySyncMissingScope(t *testing.T) {
+	d, fake := newTestDirectory(t)
+	fake.scopeError = true
+	fake.channels = fake.channels[:1]
+	ctx := context.Background()
+	require.NoError(t, d.sync(ctx))
+
+	channelId, err := d.channelId(ctx, "general")
+	require.NoError(t, err)
+	require.Equal(t, "C1", channelId)
+}
+
+func TestDirectoryEvents(t *testing.T) {
+	d, _ := newTestDirectory(t)
+	ctx := context.Background()
+	require.NoError(t, d.sync(ctx))
+
+	require.True(t, d.handleEvent(&slackevents.ChannelRenameEvent{Channel: slackevents.ChannelRenameInfo{ID: "C1", Name: "lobby"}}))
+	_, err := d.channelId(ctx, "general")
+	require.Error(t, err)
+	channelId, err := d.channelId(ctx, "lobby")
+	require.NoError(t, err)
+	require.Equal(t, "C1", channelId)
+
+	d.handleEvent(&slackevents.GroupArchiveEvent{Channel: "G1"})
+	_, err = d.channelId(ctx, "secret")
+	require.Error(t, err)
+	archived, err := d.channel(ctx, "G1")
+	require.NoError(t, err)
+	require.True(t, archived.IsArchived)
+	d.handleEvent(&slackevents.GroupUnarchiveEvent{Channel: "G1"})
+	channelId, err = d.channelId(ctx, "secret")
+	require.NoError(t, err)
+	require.Equal(t, "G1", channelId)
+
+	d.handleEvent(&slackevents.ChannelCreatedEvent{Channel: slackevents.ChannelCreatedInfo{ID: "C2", Name: "random", IsChannel: true}})
+	channelId, err = d.channelId(ctx, "random")
+	require.NoError(t, err)
+	require.Equal(t, "C2", channelId)
+
+	d.handleEvent(&slackevents.ChannelDeletedEvent{Channel: "C2"})
+	_, err = d.channelId(ctx, "random")
+	require.Error(t, err)
+
+	d.handleEvent(&slackevents.TeamJoinEvent{User: &slack.User{ID: "U2", Name: "newbie"}})
+	userId, err := d.userId(ctx, "newbie")
+	require.NoError(t, err)
+	require.Equal(t, "U2", userId)
+
+	d.handleEvent(&slack.UserChangeEvent{User: slack.User{ID: "U2", Name: "veteran"}})
+	_, err = d.userId(ctx, "newbie")
+	require.Error(t, err)
+	user, err := d.user(ctx, "U2")
+	require.NoError(t, err)
+	require.Equal(t, "veteran", user.Name)
+
+	require.False(t, d.handleEvent(&slackevents.PinAddedEvent{}))
+}
+
+func TestDirectoryFallback(t *testing.T) {
+	d, fake := newTestDirectory(t)
+	ctx := context.Background()
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	// Ids that aren't cached are looked up with the API.
+	user, err := d.user(ctx, "U1")
+	require.NoError(t, err)
+	require.Equal(t, "jirwin", user.Name)
+	channel, err := d.channel(ctx, "G1")
+	require.NoError(t, err)
+	require.Equal(t, "secret", channel.Name)
+	_, err = d.user(ctx, "U404")
+	require.Error(t, err)
+
+	// Unknown names reload everything, but not more than once every directoryMissInterval.
+	channelId, err := d.channelId(ctx, "general")
+	require.NoError(t, err)
+	require.Equal(t, "C1", channelId)
+	calls := atomic.LoadInt32(&fake.listCalls)
+
+	_, err = d.channelId(ctx, "missing")
+	require.Error(t, err)
+	require.Equal(t, calls, atomic.LoadInt32(&fake.listCalls))
+
+	now = now.Add(directoryMissInterval)
+	_, err = d.channelId(ctx, "missing")
+	require.Error(t, err)
+	require.Greater(t, atomic.LoadInt32(&fake.listCalls), calls)
+}
+
+func TestDirectoryConcurrent(t *testing.T) {
+	d, _ := newTestDirectory(t)
+	ctx := context.Background()
+	require.NoError(t, d.sync(ctx))
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				d.handleEvent(&slack.UserChangeEvent{User: slack.User{ID: "U1", Name: "jirwin"}})
+				d.handleEvent(&slackevents.ChannelRenameEvent{Channel: slackevents.ChannelRenameInfo{ID: "C1", Name: "general"}})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = d.user(ctx, "U1")
+				_, _ = d.channelId(ctx, "general")
+			}
+		}()
+	}
+	wg.Wait()
+}
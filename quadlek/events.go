@@ -1,10 +1,12 @@
 package quadlek
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,11 +16,6 @@ import (
 )
 
 func (b *Bot) handleSlackEvent(w http.ResponseWriter, r *http.Request) {
-	err := b.ValidateSlackRequest(r)
-	if err != nil {
-		b.Log.Error("unable to validate request signature")
-		return
-	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		b.Log.Error("unable to read event body")
@@ -43,119 +40,126 @@ func (b *Bot) handleSlackEvent(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(urlEvent.Challenge))
 
 	case slackevents.CallbackEvent:
-		switch iev := ev.InnerEvent.Data.(type) {
-
-		case *slackevents.MessageEvent:
-			// Ignore things the bot has said
-			if iev.BotID != b.GetBotId() {
-				hookMsg := &slack.Msg{
-					Channel:         iev.Channel,
-					User:            iev.User,
-					Text:            iev.Text,
-					Timestamp:       iev.TimeStamp,
-					ThreadTimestamp: iev.ThreadTimeStamp,
-					BotID:           iev.BotID,
-					Username:        iev.Username,
-				}
-				b.dispatchHooks(hookMsg)
-			}
-
-		case *slackevents.AppMentionEvent:
-			if iev.User != "" {
-				// Hack to handle slash commands from messages
-				if strings.HasPrefix(iev.Text, fmt.Sprintf("<@%s> ", b.userId)) {
-					tokens := strings.Split(iev.Text, " ")
-					if len(tokens) > 1 {
-						cmdName := tokens[1]
-						cmd := b.GetCommand(cmdName)
-						if cmd == nil {
-							return
-						}
-						channel, err := b.GetChannel(iev.Channel)
-						if err != nil {
-							b.Log.Error("error getting channel", zap.Error(err), zap.String("channel", iev.Channel))
-							return
-						}
-						user, err := b.GetUser(iev.User)
-						if err != nil {
-							b.Log.Error("error getting user", zap.Error(err))
-							return
-						}
-						slashCmd := &slashCommand{
-							TeamId:      ev.TeamID,
-							ChannelId:   channel.ID,
-							ChannelName: channel.Name,
-							UserId:      user.ID,
-							UserName:    user.Name,
-							Command:     tokens[1],
-							Text:        strings.Join(tokens[2:], " "),
-						}
-
-						respChan := make(chan *CommandResp)
-						slashCmd.responseChan = respChan
+		// Acknowledge the event before dispatching it, Slack redelivers events that aren't acknowledged within three seconds.
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusOK)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
 
-						go func() {
-							timer := time.NewTimer(time.Millisecond * 2500)
+		retryNum, _ := strconv.Atoi(r.Header.Get("X-Slack-Retry-Num"))
+		if b.duplicateEvent(ev, retryNum, r.Header.Get("X-Slack-Retry-Reason")) {
+			return
+		}
+		b.record(RecordedEvent, body)
+		b.dispatchEvent(traceContext(r.Context()), ev)
+	}
+}
 
-							for {
-								select {
-								case <-timer.C:
-									return
+// dispatchEvent routes an Events API callback event to the hooks, commands and caches interested in it.
+// It is shared by the webhook server and the socket mode client. ctx carries the trace of the event.
+func (b *Bot) dispatchEvent(ctx context.Context, ev slackevents.EventsAPIEvent) {
+	b.metrics.event(ev.InnerEvent.Type)
+
+	switch iev := ev.InnerEvent.Data.(type) {
+
+	case *slackevents.MessageEvent:
+		// Ignore things the bot has said
+		if iev.BotID != b.GetBotId() {
+			hookMsg := &slack.Msg{
+				Channel:         iev.Channel,
+				User:            iev.User,
+				Text:            iev.Text,
+				Timestamp:       iev.TimeStamp,
+				ThreadTimestamp: iev.ThreadTimeStamp,
+				BotID:           iev.BotID,
+				Username:        iev.Username,
+			}
+			b.dispatchHooks(ctx, hookMsg)
+		}
 
-								case resp := <-respChan:
-									timer.Stop()
-									if resp != nil {
-										msgOpts := []slack.MsgOption{
-											slack.MsgOptionText(resp.Text, false),
-											slack.MsgOptionAttachments(resp.Attachments...),
-										}
+	case *slackevents.AppMentionEvent:
+		if iev.User != "" {
+			// Hack to handle slash commands from messages
+			if strings.HasPrefix(iev.Text, fmt.Sprintf("<@%s> ", b.userId)) {
+				tokens := strings.Split(iev.Text, " ")
+				if len(tokens) > 1 {
+					cmdName := tokens[1]
+					cmd := b.GetCommand(cmdName)
+					if cmd == nil {
+						return
+					}
+					channel, err := b.GetChannel(iev.Channel)
+					if err != nil {
+						b.Log.Error("error getting channel", zap.Error(err), zap.String("channel", iev.Channel))
+						return
+					}
+					user, err := b.GetUser(iev.User)
+					if err != nil {
+						b.Log.Error("error getting user", zap.Error(err))
+						return
+					}
+					slashCmd := &slashCommand{
+						TeamId:      ev.TeamID,
+						ChannelId:   channel.ID,
+						ChannelName: channel.Name,
+						UserId:      user.ID,
+						UserName:    user.Name,
+						Command:     "/" + cmdName,
+						Text:        strings.Join(tokens[2:], " "),
+						ctx:         ctx,
+					}
 
-										if !resp.InChannel {
-											msgOpts = append(msgOpts, slack.MsgOptionPostEphemeral(iev.User))
-										}
+					respChan := make(chan *CommandResp)
+					slashCmd.responseChan = respChan
+					slashCmd.expired = make(chan struct{})
+
+					go func() {
+						defer close(slashCmd.expired)
+						timer := time.NewTimer(time.Millisecond * 2500)
+
+						for {
+							select {
+							case <-timer.C:
+								return
+
+							case resp := <-respChan:
+								timer.Stop()
+								if resp != nil {
+									msgOpts := []slack.MsgOption{
+										slack.MsgOptionText(resp.Text, false),
+										slack.MsgOptionAttachments(resp.Attachments...),
+										slack.MsgOptionBlocks(resp.Blocks...),
+									}
 
-										_, _, _ = b.api.PostMessage(iev.Channel, msgOpts...)
-										return
+									if !resp.InChannel {
+										msgOpts = append(msgOpts, slack.MsgOptionPostEphemeral(iev.User))
 									}
 
+									_, _, _ = b.api.PostMessageContext(ctx, iev.Channel, msgOpts...)
 									return
 								}
-							}
-						}()
 
-						cmd.Command.Channel() <- &CommandMsg{
-							Bot:     b,
-							Command: slashCmd,
-							Store:   b.getStore(cmd.PluginId),
+								return
+							}
 						}
+					}()
 
-					}
+					b.dispatchCommand(slashCmd)
 				}
 			}
+		}
 
-		case *slackevents.ReactionAddedEvent:
-			b.dispatchReactions(iev)
-
-		case *slackevents.MemberJoinedChannelEvent:
-			if iev.User == b.userId {
-				b.Say(iev.Channel, fmt.Sprintf("Thanks for inviting me <@%s>. I'm alive!", iev.Inviter))
-			}
-
-		case *slack.ChannelCreatedEvent:
-			if iev.Channel.IsChannel {
-				channel, err := b.api.GetConversationInfo(iev.Channel.ID, false)
-				if err != nil {
-					b.Log.Error("Unable to add channel", zap.Error(err))
-					return
-				}
-				b.humanChannels[channel.Name] = *channel
-			}
+	case *slackevents.ReactionAddedEvent:
+		b.dispatchReactions(ctx, iev)
 
-		case *slack.UserChangeEvent:
-			b.users[iev.User.ID] = iev.User
-			b.humanUsers[iev.User.Name] = iev.User
+	case *slackevents.MemberJoinedChannelEvent:
+		if iev.User == b.userId {
+			b.SayContext(ctx, iev.Channel, fmt.Sprintf("Thanks for inviting me <@%s>. I'm alive!", iev.Inviter))
+		}
 
-		default:
+	default:
+		if !b.directory.handleEvent(iev) {
 			b.Log.Info("unhandled event", zap.Any("event", iev))
 		}
 	}
@@ -0,0 +1,268 @@
+package quadlek
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// expiryBucket holds the expiry time of every key that has one, keyed by plugin id and key.
+// The colon keeps it from clashing with a plugin id, since RegisterPlugin rejects ids containing one.
+const expiryBucket = "quadlek:expiry"
+
+// sweepInterval is how often expired keys are deleted. They are treated as absent as soon as they expire.
+const sweepInterval = time.Minute
+
+// ErrNotStoreBucket is returned by ExpireKey if it is passed a bucket that didn't come from a Store.
+var ErrNotStoreBucket = errors.New("the bucket wasn't passed to Store.Transaction")
+
+// expiryKey returns the key in the expiry bucket for a plugin's key.
+func expiryKey(pluginId string, key []byte) []byte {
+	k := make([]byte, 0, len(pluginId)+1+len(key))
+	k = append(k, pluginId...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// storeBucket is the bucket passed to Store.Transaction and Store.View. Expired keys are treated as absent.
+type storeBucket struct {
+	storage.Bucket
+	pluginId string
+	expiry   storage.Bucket
+	sealer   *sealer
+	now      time.Time
+}
+
+// expiresAt returns when key expires, or the zero time if it doesn't.
+func (b *storeBucket) expiresAt(key []byte) (time.Time, error) {
+	val, err := b.expiry.Get(expiryKey(b.pluginId, key))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(val) != 8 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(val))), nil
+}
+
+// expired returns true if key has expired.
+func (b *storeBucket) expired(key []byte) (bool, error) {
+	expiresAt, err := b.expiresAt(key)
+	if err != nil {
+		return false, err
+	}
+
+	return !expiresAt.IsZero() && !b.now.Before(expiresAt), nil
+}
+
+// clearExpiry removes key's expiry time, if it has one.
+func (b *storeBucket) clearExpiry(key []byte) error {
+	ek := expiryKey(b.pluginId, key)
+	val, err := b.expiry.Get(ek)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		return nil
+	}
+
+	return b.expiry.Delete(ek)
+}
+
+// Get implements storage.Bucket
+func (b *storeBucket) Get(key []byte) ([]byte, error) {
+	expired, err := b.expired(key)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, nil
+	}
+
+	return b.Bucket.Get(key)
+}
+
+// Put implements storage.Bucket. Writing a key removes its expiry time.
+func (b *storeBucket) Put(key, value []byte) error {
+	err := b.Bucket.Put(key, value)
+	if err != nil {
+		return err
+	}
+
+	return b.clearExpiry(key)
+}
+
+// Delete implements storage.Bucket
+func (b *storeBucket) Delete(key []byte) error {
+	err := b.Bucket.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	return b.clearExpiry(key)
+}
+
+// Scan implements storage.Bucket
+func (b *storeBucket) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	return b.ScanWith(storage.ScanOptions{Prefix: prefix}, fn)
+}
+
+// ScanWith implements storage.Bucket
+func (b *storeBucket) ScanWith(opts storage.ScanOptions, fn func(key, value []byte) error) error {
+	return b.Bucket.ScanWith(opts, func(key, value []byte) error {
+		expired, err := b.expired(key)
+		if err != nil {
+			return err
+		}
+		if expired {
+			return nil
+		}
+
+		return fn(key, value)
+	})
+}
+
+// ExpireKey makes key expire ttl from now. bkt must be the bucket passed to Store.Transaction.
+// Writing the key again removes its expiry time.
+func ExpireKey(bkt storage.Bucket, key string, ttl time.Duration) error {
+	sb, ok := bkt.(*storeBucket)
+	if !ok {
+		return ErrNotStoreBucket
+	}
+
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, uint64(sb.now.Add(ttl).UnixNano()))
+
+	return sb.expiry.Put(expiryKey(sb.pluginId, []byte(key)), val)
+}
+
+// ExpireExistingKeys sets keys starting with prefix that don't expire to expire after ttl. It is meant for the
+// migration of a plugin that starts storing keys with a TTL, so keys stored before then don't live forever.
+// bkt must be the bucket passed to the migration.
+func ExpireExistingKeys(bkt storage.Bucket, prefix string, ttl time.Duration) error {
+	sb, ok := bkt.(*storeBucket)
+	if !ok {
+		return ErrNotStoreBucket
+	}
+
+	var keys []string
+	err := sb.Scan([]byte(prefix), func(key, value []byte) error {
+		expiresAt, err := sb.expiresAt(key)
+		if err != nil {
+			return err
+		}
+		if expiresAt.IsZero() {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		err = ExpireKey(sb, key, ttl)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PutWithTTL stores the value at the provided key, which expires after ttl.
+func (s *Store) PutWithTTL(key string, value []byte, ttl time.Duration) error {
+	return s.Transaction(func(bkt storage.Bucket) error {
+		err := bkt.Put([]byte(key), value)
+		if err != nil {
+			return err
+		}
+
+		return ExpireKey(bkt, key, ttl)
+	})
+}
+
+// ExpiresAt returns when key expires, or the zero time if it doesn't.
+func (s *Store) ExpiresAt(key string) (time.Time, error) {
+	var expiresAt time.Time
+	err := s.View(func(bkt storage.Bucket) error {
+		var err error
+		expiresAt, err = bkt.(*storeBucket).expiresAt([]byte(key))
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return expiresAt, nil
+}
+
+// sweepExpiredKeys is the core scheduled task that deletes expired keys from every plugin's bucket.
+func (b *Bot) sweepExpiredKeys(ctx context.Context, msg *ScheduledTaskMsg) error {
+	swept, err := b.sweepExpired(time.Now())
+	if err != nil {
+		return err
+	}
+	if swept > 0 {
+		b.Log.Debug("swept expired keys", zap.Int("count", swept))
+	}
+
+	return nil
+}
+
+// sweepExpired deletes every key that has expired by now, and returns how many were deleted.
+func (b *Bot) sweepExpired(now time.Time) (int, error) {
+	swept := 0
+	err := b.storage.Update(func(tx storage.Tx) error {
+		expiry, err := tx.Bucket(expiryBucket)
+		if err != nil {
+			return err
+		}
+
+		// Collect the keys first, deleting them while scanning isn't safe with every backend.
+		var expired [][]byte
+		err = expiry.Scan(nil, func(key, value []byte) error {
+			if len(value) == 8 && now.UnixNano() >= int64(binary.BigEndian.Uint64(value)) {
+				expired = append(expired, append([]byte{}, key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, ek := range expired {
+			i := bytes.IndexByte(ek, 0)
+			if i < 0 {
+				continue
+			}
+			pluginBkt, err := tx.Bucket(string(ek[:i]))
+			if err != nil {
+				return err
+			}
+			err = pluginBkt.Delete(ek[i+1:])
+			if err != nil {
+				return err
+			}
+			err = expiry.Delete(ek)
+			if err != nil {
+				return err
+			}
+		}
+		swept = len(expired)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return swept, nil
+}
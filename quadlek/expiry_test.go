@@ -0,0 +1,102 @@
+package quadlek
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestExpiringKeys(t *testing.T) {
+	backend := storage.NewMemory()
+	b := &Bot{storage: backend}
+	store := b.getStore("test")
+
+	require.NoError(t, store.PutWithTTL("short", []byte("a"), -time.Second))
+	require.NoError(t, store.PutWithTTL("long", []byte("b"), time.Hour))
+	require.NoError(t, store.Update("forever", []byte("c")))
+
+	expiresAt, err := store.ExpiresAt("long")
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+	expiresAt, err = store.ExpiresAt("forever")
+	require.NoError(t, err)
+	require.True(t, expiresAt.IsZero())
+
+	require.NoError(t, store.Get("short", func(val []byte) error {
+		require.Nil(t, val)
+		return nil
+	}))
+
+	var keys []string
+	require.NoError(t, store.Range("", func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	require.Equal(t, []string{"forever", "long"}, keys)
+
+	swept, err := b.sweepExpired(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, swept)
+
+	require.NoError(t, backend.View(func(tx storage.Tx) error {
+		bkt, err := tx.Bucket("test")
+		require.NoError(t, err)
+		val, err := bkt.Get([]byte("short"))
+		require.NoError(t, err)
+		require.Nil(t, val)
+		return nil
+	}))
+
+	swept, err = b.sweepExpired(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, swept)
+}
+
+func TestExpiringKeysRewrite(t *testing.T) {
+	b := &Bot{storage: storage.NewMemory()}
+	store := b.getStore("test")
+
+	require.NoError(t, store.PutWithTTL("key", []byte("a"), -time.Second))
+	require.NoError(t, store.Update("key", []byte("b")))
+
+	expiresAt, err := store.ExpiresAt("key")
+	require.NoError(t, err)
+	require.True(t, expiresAt.IsZero())
+
+	swept, err := b.sweepExpired(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 0, swept)
+
+	require.Equal(t, ErrNotStoreBucket, ExpireKey(nil, "key", time.Minute))
+}
+
+func TestExpireExistingKeys(t *testing.T) {
+	b := &Bot{storage: storage.NewMemory(), Log: zap.NewNop()}
+	store := b.getStore("test")
+
+	require.NoError(t, store.Update("state-old", []byte("a")))
+	require.NoError(t, store.PutWithTTL("state-new", []byte("b"), time.Minute))
+	require.NoError(t, store.Update("other", []byte("c")))
+
+	require.NoError(t, b.migrate("test", []Migration{{Version: 1, Migrate: func(bkt storage.Bucket) error {
+		return ExpireExistingKeys(bkt, "state-", time.Hour)
+	}}}))
+
+	for key, want := range map[string]time.Duration{"state-old": time.Hour, "state-new": time.Minute} {
+		expiresAt, err := store.ExpiresAt(key)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(want), expiresAt, 10*time.Second, key)
+	}
+
+	expiresAt, err := store.ExpiresAt("other")
+	require.NoError(t, err)
+	require.True(t, expiresAt.IsZero())
+
+	// Plugin ids can't clash with the expiry bucket.
+	require.Error(t, b.RegisterPlugin(MakePlugin(expiryBucket, nil, nil, nil, nil, nil)))
+}
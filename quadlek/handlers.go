@@ -0,0 +1,259 @@
+package quadlek
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
+)
+
+// defaultHandlerTimeout is how long a single invocation of a handler func may run unless overridden with WithTimeout.
+const defaultHandlerTimeout = time.Minute
+
+// CommandHandlerFunc handles a single slash command invocation.
+// A non-nil response is sent back to the user. A returned error is logged and reported to the user with an ephemeral
+// message, which only includes the error if it was created with UserError.
+type CommandHandlerFunc func(ctx context.Context, msg *CommandMsg) (*CommandResp, error)
+
+// HookHandlerFunc handles a single message seen by the Bot. A returned error is logged.
+type HookHandlerFunc func(ctx context.Context, msg *HookMsg) error
+
+// ReactionHookHandlerFunc handles a single reaction. A returned error is logged.
+type ReactionHookHandlerFunc func(ctx context.Context, msg *ReactionHookMsg) error
+
+// WebhookHandlerFunc handles a single request to a plugin webhook.
+// A returned error is logged, and if the handler hasn't written a response a 500 is returned.
+type WebhookHandlerFunc func(ctx context.Context, msg *WebhookMsg) error
+
+// InteractionHandlerFunc handles a single interaction.
+// A returned error is logged and, when Slack provides a response url, reported to the user with an ephemeral message.
+type InteractionHandlerFunc func(ctx context.Context, msg *InteractionMsg) error
+
+// WithTimeout sets how long a single invocation of a handler func may run before its context is cancelled.
+func WithTimeout(timeout time.Duration) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.timeout = timeout
+	}
+}
+
+// CommandFunc returns a Command that calls fn for each invocation.
+// The Bot runs the receive loop, so plugins don't have to.
+func CommandFunc(name string, fn CommandHandlerFunc, opts ...HandlerOption) Command {
+	ho := makeHandlerOptions(opts)
+
+	return &command{
+		name:    name,
+		channel: make(chan *CommandMsg),
+		opts:    ho,
+		runFunc: func(ctx context.Context, cmdChan <-chan *CommandMsg) {
+			handleLoop(ctx, cmdChan, func(cmdMsg *CommandMsg) {
+				resp, err := invoke(ctx, cmdMsg.ctx, ho.timeout, cmdMsg.Bot, cmdMsg.Store, "command", name, func(ctx context.Context) (*CommandResp, error) {
+					return fn(ctx, cmdMsg)
+				})
+				if err != nil {
+					resp = errorResponse(err)
+				}
+
+				err = cmdMsg.Bot.respondToCommand(cmdMsg.Command, resp)
+				if err != nil {
+					cmdMsg.Bot.Log.Error("error responding to command", zap.String("command", name), zap.Error(err))
+				}
+			})
+		},
+	}
+}
+
+// HookFunc returns a Hook that calls fn for each message.
+func HookFunc(fn HookHandlerFunc, opts ...HandlerOption) Hook {
+	ho := makeHandlerOptions(opts)
+
+	return &hook{
+		channel: make(chan *HookMsg),
+		opts:    ho,
+		runFunc: func(ctx context.Context, hookChan <-chan *HookMsg) {
+			handleLoop(ctx, hookChan, func(hookMsg *HookMsg) {
+				_, _ = invoke(ctx, hookMsg.ctx, ho.timeout, hookMsg.Bot, hookMsg.Store, "hook", "", func(ctx context.Context) (struct{}, error) {
+					return struct{}{}, fn(ctx, hookMsg)
+				})
+			})
+		},
+	}
+}
+
+// ReactionHookFunc returns a ReactionHook that calls fn for each reaction.
+func ReactionHookFunc(fn ReactionHookHandlerFunc, opts ...HandlerOption) ReactionHook {
+	ho := makeHandlerOptions(opts)
+
+	return &reactionHook{
+		channel: make(chan *ReactionHookMsg),
+		opts:    ho,
+		runFunc: func(ctx context.Context, reactionChan <-chan *ReactionHookMsg) {
+			handleLoop(ctx, reactionChan, func(rhMsg *ReactionHookMsg) {
+				_, _ = invoke(ctx, rhMsg.ctx, ho.timeout, rhMsg.Bot, rhMsg.Store, "reaction_hook", "", func(ctx context.Context) (struct{}, error) {
+					return struct{}{}, fn(ctx, rhMsg)
+				})
+			})
+		},
+	}
+}
+
+// WebhookFunc returns a Webhook that calls fn for each request.
+// The Bot signals that the request is done once fn returns, so fn shouldn't write to WebhookMsg.Done.
+func WebhookFunc(name string, fn WebhookHandlerFunc, opts ...HandlerOption) Webhook {
+	ho := makeHandlerOptions(opts)
+
+	return &webhook{
+		name:    name,
+		channel: make(chan *WebhookMsg),
+		opts:    ho,
+		runFunc: func(ctx context.Context, whChan <-chan *WebhookMsg) {
+			handleLoop(ctx, whChan, func(whMsg *WebhookMsg) {
+				w := &trackingResponseWriter{ResponseWriter: whMsg.ResponseWriter}
+				whMsg.ResponseWriter = w
+
+				_, err := invoke(ctx, whMsg.ctx, ho.timeout, whMsg.Bot, whMsg.Store, "webhook", name, func(ctx context.Context) (struct{}, error) {
+					return struct{}{}, fn(ctx, whMsg)
+				})
+				if err != nil && !w.wroteHeader {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+
+				if whMsg.Done != nil {
+					whMsg.Done <- true
+				}
+			})
+		},
+	}
+}
+
+// InteractionFunc returns an Interaction that calls fn for each interaction.
+func InteractionFunc(name string, fn InteractionHandlerFunc, opts ...HandlerOption) Interaction {
+	ho := makeHandlerOptions(opts)
+
+	return &interaction{
+		name:    name,
+		channel: make(chan *InteractionMsg),
+		opts:    ho,
+		runFunc: func(ctx context.Context, interactionChan <-chan *InteractionMsg) {
+			handleLoop(ctx, interactionChan, func(icMsg *InteractionMsg) {
+				_, err := invoke(ctx, icMsg.ctx, ho.timeout, icMsg.Bot, icMsg.Store, "interaction", name, func(ctx context.Context) (struct{}, error) {
+					return struct{}{}, fn(ctx, icMsg)
+				})
+				if err != nil && icMsg.Interaction.ResponseURL != "" {
+					err = icMsg.Bot.RespondToSlashCommandContext(icMsg.Context(), icMsg.Interaction.ResponseURL, errorResponse(err))
+					if err != nil {
+						icMsg.Bot.Log.Error("error responding to interaction", zap.String("interaction", name), zap.Error(err))
+					}
+				}
+			})
+		},
+	}
+}
+
+// handleLoop calls handle for each message received on ch until ctx is cancelled.
+func handleLoop[T any](ctx context.Context, ch <-chan T, handle func(msg T)) {
+	for {
+		select {
+		case msg := <-ch:
+			handle(msg)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// invoke calls fn with a context that is cancelled after timeout, and traced as part of parent, the context of
+// the message being handled. Errors returned by fn, and panics, are logged along with the plugin id and returned.
+func invoke[T any](ctx, parent context.Context, timeout time.Duration, b *Bot, store *Store, kind, name string, fn func(ctx context.Context) (T, error)) (ret T, err error) {
+	if timeout <= 0 {
+		timeout = defaultHandlerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pluginId := ""
+	if store != nil {
+		pluginId = store.pluginId
+	}
+
+	ctx, span := b.startHandlerSpan(ctx, parent, pluginId, kind, name)
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			b.reportPanic(pluginId, kind, name, r, debug.Stack())
+		}
+		b.metrics.handled(pluginId, kind, name, time.Since(start), err)
+		EndSpan(span, err)
+	}()
+
+	ret, err = fn(ctx)
+	if err != nil {
+		b.Log.Error("plugin handler failed",
+			zap.String("plugin", pluginId),
+			zap.String("kind", kind),
+			zap.String("name", name),
+			zap.Error(err),
+		)
+	}
+
+	return ret, err
+}
+
+// UserError returns an error that is shown to the user when a handler returns it, e.g. to explain what was wrong
+// with their request. Other errors, which can hold details like panics or storage errors, are only logged.
+func UserError(format string, a ...interface{}) error {
+	return &userError{msg: fmt.Sprintf(format, a...)}
+}
+
+// userError is an error created with UserError.
+type userError struct {
+	msg string
+}
+
+// Error implements error
+func (e *userError) Error() string {
+	return e.msg
+}
+
+// errorResponse is the ephemeral response sent to users when a handler fails. Only errors created with UserError,
+// and usage errors from parsing the command, are shown. The full error has already been logged by invoke.
+func errorResponse(err error) *CommandResp {
+	var ue *userError
+	if errors.As(err, &ue) {
+		return &CommandResp{Text: ue.msg}
+	}
+	var usageErr *cmdparse.UsageError
+	if errors.As(err, &usageErr) {
+		return &CommandResp{Text: usageErr.Error()}
+	}
+
+	return &CommandResp{
+		Text: "Sorry. I was unable to complete your request. :cry:",
+	}
+}
+
+// trackingResponseWriter records whether a webhook handler has started writing its response.
+type trackingResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *trackingResponseWriter) WriteHeader(statusCode int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements http.ResponseWriter
+func (w *trackingResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,133 @@
+package quadlek
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
+)
+
+func TestErrorResponse(t *testing.T) {
+	b := &Bot{Log: zap.NewNop()}
+	_, err := invoke(context.Background(), context.Background(), 0, b, nil, "command", "boom", func(ctx context.Context) (struct{}, error) {
+		panic("nil map in storage layer")
+	})
+	require.Error(t, err)
+	require.Equal(t, "Sorry. I was unable to complete your request. :cry:", errorResponse(err).Text)
+	require.Equal(t, "Sorry. I was unable to complete your request. :cry:", errorResponse(errors.New("bolt: database not open")).Text)
+
+	require.Equal(t, "No such show.", errorResponse(fmt.Errorf("lookup: %w", UserError("No such show."))).Text)
+
+	_, err = (&cmdparse.Spec{Name: "g", Args: []cmdparse.Arg{{Name: "n", Type: cmdparse.Int}}}).Parse("x")
+	require.Contains(t, errorResponse(err).Text, "Usage: /g <n>")
+}
+
+// runHandler calls run until the test ends.
+func runHandler(t *testing.T, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestCommandFunc(t *testing.T) {
+	b := &Bot{Log: zap.NewNop()}
+	timedOut := make(chan error, 1)
+	cmd := CommandFunc("echo", func(ctx context.Context, msg *CommandMsg) (*CommandResp, error) {
+		switch msg.Command.Text {
+		case "slow":
+			<-ctx.Done()
+			timedOut <- ctx.Err()
+			return nil, ctx.Err()
+		case "bad":
+			return nil, UserError("That's not something I can echo.")
+		case "panic":
+			panic("boom")
+		}
+		return &CommandResp{Text: msg.Command.Text}, nil
+	}, WithTimeout(10*time.Millisecond))
+	runHandler(t, cmd.Run)
+
+	run := func(text string) *CommandResp {
+		sc := &slashCommand{Text: text, responseChan: make(chan *CommandResp), expired: make(chan struct{})}
+		cmd.Channel() <- &CommandMsg{Bot: b, Command: sc, Store: &Store{pluginId: "echo"}}
+		return <-sc.responseChan
+	}
+
+	// Each invocation is handled by the same loop.
+	require.Equal(t, "hi", run("hi").Text)
+	require.Equal(t, "again", run("again").Text)
+
+	// The invocation's context is cancelled after its timeout, and the error is reported to the user.
+	require.Equal(t, "Sorry. I was unable to complete your request. :cry:", run("slow").Text)
+	require.ErrorIs(t, <-timedOut, context.DeadlineExceeded)
+	require.Equal(t, "That's not something I can echo.", run("bad").Text)
+
+	// A panic is reported to the supervisor, and the loop keeps running.
+	require.Equal(t, "Sorry. I was unable to complete your request. :cry:", run("panic").Text)
+	statuses := b.PluginStatuses()
+	require.Len(t, statuses, 1)
+	require.Equal(t, "echo", statuses[0].PluginId)
+	require.Equal(t, 1, statuses[0].Panics)
+	require.Equal(t, "hi", run("hi").Text)
+}
+
+func TestHookFunc(t *testing.T) {
+	b := &Bot{Log: zap.NewNop()}
+	seen := make(chan string)
+	hook := HookFunc(func(ctx context.Context, msg *HookMsg) error {
+		seen <- msg.Msg.Text
+		return errors.New("hook failed")
+	})
+	runHandler(t, hook.Run)
+
+	// A failing message doesn't stop the loop.
+	for _, text := range []string{"one", "two"} {
+		hook.Channel() <- &HookMsg{Bot: b, Msg: &slack.Msg{Text: text}, Store: &Store{pluginId: "echo"}}
+		require.Equal(t, text, <-seen)
+	}
+}
+
+func TestWebhookFunc(t *testing.T) {
+	b := &Bot{Log: zap.NewNop()}
+	wh := WebhookFunc("deploy", func(ctx context.Context, msg *WebhookMsg) error {
+		if msg.Request.URL.Query().Get("accept") != "" {
+			msg.ResponseWriter.WriteHeader(http.StatusAccepted)
+		}
+		return errors.New("deploy failed")
+	})
+	runHandler(t, wh.Run)
+
+	run := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		done := make(chan bool, 1)
+		wh.Channel() <- &WebhookMsg{
+			Bot:            b,
+			Request:        httptest.NewRequest(http.MethodPost, target, nil),
+			ResponseWriter: w,
+			Store:          &Store{pluginId: "deploys"},
+			Done:           done,
+		}
+		require.True(t, <-done)
+		return w
+	}
+
+	// A 500 is written if the handler fails before responding, but not over its own response.
+	require.Equal(t, http.StatusInternalServerError, run("/slack/plugin/deploy").Code)
+	require.Equal(t, http.StatusAccepted, run("/slack/plugin/deploy?accept=1").Code)
+}
@@ -0,0 +1,229 @@
+package quadlek
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
+)
+
+// corePluginId is the id of the plugin that holds the Bot's built in commands. Other plugins can't use it.
+const corePluginId = "quadlek"
+
+// corePlugin returns the plugin for the Bot's built in commands.
+func (b *Bot) corePlugin() Plugin {
+	return MakePlugin(
+		corePluginId,
+		[]Command{
+			CommandFunc("help", b.helpCommand, WithSpec(helpSpec)),
+		},
+		nil,
+		nil,
+		nil,
+		nil,
+		WithScheduledTasks(b.coreTasks()...),
+	)
+}
+
+// coreTasks returns the core plugin's scheduled tasks.
+func (b *Bot) coreTasks() []ScheduledTask {
+	tasks := []ScheduledTask{
+		MakeScheduledTask("sweep-expired-keys", Every(sweepInterval), b.sweepExpiredKeys),
+		MakeScheduledTask("resync-directory", Every(directoryResyncInterval), b.resyncDirectory),
+	}
+	if b.config.Backup.Dir != "" {
+		tasks = append(tasks, MakeScheduledTask("backup", MustCron(b.config.Backup.Schedule), b.backupTask))
+	}
+
+	return tasks
+}
+
+var helpSpec = &cmdparse.Spec{
+	Name:        "help",
+	Description: "List the available commands, or show how to use one.",
+	Args: []cmdparse.Arg{
+		{Name: "command", Type: cmdparse.Rest, Optional: true, Description: "The command, and optionally subcommand, to show help for."},
+	},
+	Examples: []string{"/help", "/help comic", "/help comic del"},
+}
+
+// helpCommand lists every command, or shows the details of one command.
+func (b *Bot) helpCommand(ctx context.Context, cmdMsg *CommandMsg) (*CommandResp, error) {
+	args, err := helpSpec.Parse(cmdMsg.Command.Text)
+	if err != nil {
+		return &CommandResp{Text: err.Error()}, nil
+	}
+
+	path := strings.Fields(strings.TrimPrefix(args.String("command"), "/"))
+	if len(path) == 0 {
+		return b.commandList(), nil
+	}
+
+	return b.commandHelp(path), nil
+}
+
+// commandHelpInfo is the documentation a command was registered with.
+type commandHelpInfo struct {
+	name        string
+	pluginId    string
+	description string
+	usage       string
+	examples    []string
+	spec        *cmdparse.Spec
+}
+
+// helpInfo collects the documentation for a registered command.
+func helpInfo(cmd *registeredCommand) *commandHelpInfo {
+	opts := getHandlerOptions(cmd.Command)
+	info := &commandHelpInfo{
+		name:        cmd.Command.GetName(),
+		pluginId:    cmd.PluginId,
+		description: opts.description,
+		examples:    opts.examples,
+		spec:        opts.spec,
+	}
+
+	if opts.usage != "" {
+		info.usage = fmt.Sprintf("/%s %s", info.name, opts.usage)
+	} else if opts.spec != nil {
+		info.usage = opts.spec.UsageOf()
+	} else {
+		info.usage = "/" + info.name
+	}
+
+	if opts.spec != nil {
+		if info.description == "" {
+			info.description = opts.spec.Description
+		}
+		if len(info.examples) == 0 {
+			info.examples = opts.spec.Examples
+		}
+	}
+
+	return info
+}
+
+// commandList lists every command, grouped by plugin.
+func (b *Bot) commandList() *CommandResp {
+	byPlugin := make(map[string][]*commandHelpInfo)
+	for _, cmd := range b.commands {
+		info := helpInfo(cmd)
+		byPlugin[info.pluginId] = append(byPlugin[info.pluginId], info)
+	}
+
+	pluginIds := make([]string, 0, len(byPlugin))
+	for pluginId := range byPlugin {
+		pluginIds = append(pluginIds, pluginId)
+	}
+	sort.Strings(pluginIds)
+
+	text := &strings.Builder{}
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Commands", false, false)),
+	}
+	for _, pluginId := range pluginIds {
+		infos := byPlugin[pluginId]
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].name < infos[j].name
+		})
+
+		section := &strings.Builder{}
+		fmt.Fprintf(section, "*%s*", pluginId)
+		for _, info := range infos {
+			fmt.Fprintf(section, "\n`/%s`", info.name)
+			if info.description != "" {
+				fmt.Fprintf(section, " %s", info.description)
+			}
+		}
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, section.String(), false, false), nil, nil))
+		fmt.Fprintf(text, "%s\n", section.String())
+	}
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "Use `/help <command>` to see how to use a command.", false, false)))
+
+	return &CommandResp{
+		Text:   text.String(),
+		Blocks: blocks,
+	}
+}
+
+// commandHelp shows the description, usage and examples for the command or subcommand at path.
+func (b *Bot) commandHelp(path []string) *CommandResp {
+	cmd := b.GetCommand(path[0])
+	if cmd == nil {
+		return &CommandResp{Text: fmt.Sprintf("There is no /%s command. Use /help to list the available commands.", path[0])}
+	}
+	info := helpInfo(cmd)
+
+	spec := info.spec
+	if len(path) > 1 {
+		if info.spec == nil || info.spec.Find(path[1:]...) == nil {
+			return &CommandResp{Text: fmt.Sprintf("/%s has no %s subcommand. Use /help %s to see how to use it.", path[0], strings.Join(path[1:], " "), path[0])}
+		}
+		spec = info.spec.Find(path[1:]...)
+		info.description = spec.Description
+		info.usage = info.spec.UsageOf(path[1:]...)
+		info.examples = spec.Examples
+	}
+
+	var sections []string
+	if info.description != "" {
+		sections = append(sections, info.description)
+	}
+	sections = append(sections, fmt.Sprintf("*Usage*\n`%s`", info.usage))
+
+	if spec != nil {
+		if len(spec.Args) > 0 {
+			lines := []string{"*Arguments*"}
+			for _, arg := range spec.Args {
+				lines = append(lines, fmt.Sprintf("• `%s` %s", arg.Name, arg.Description))
+			}
+			sections = append(sections, strings.Join(lines, "\n"))
+		}
+
+		if len(spec.Flags) > 0 {
+			lines := []string{"*Flags*"}
+			for _, flag := range spec.Flags {
+				line := fmt.Sprintf("• `--%s` %s", flag.Name, flag.Description)
+				if flag.Default != "" {
+					line += fmt.Sprintf(" (default %s)", flag.Default)
+				}
+				lines = append(lines, line)
+			}
+			sections = append(sections, strings.Join(lines, "\n"))
+		}
+
+		if len(spec.Subcommands) > 0 {
+			lines := []string{"*Subcommands*"}
+			for _, sub := range spec.Subcommands {
+				lines = append(lines, fmt.Sprintf("• `%s` %s", info.spec.UsageOf(append(path[1:len(path):len(path)], sub.Name)...), sub.Description))
+			}
+			sections = append(sections, strings.Join(lines, "\n"))
+		}
+	}
+
+	if len(info.examples) > 0 {
+		lines := []string{"*Examples*"}
+		for _, example := range info.examples {
+			lines = append(lines, fmt.Sprintf("`%s`", example))
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+
+	title := "/" + strings.Join(path, " ")
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, title, false, false)),
+	}
+	for _, section := range sections {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, section, false, false), nil, nil))
+	}
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("From the %s plugin.", info.pluginId), false, false)))
+
+	return &CommandResp{
+		Text:   fmt.Sprintf("%s\n%s", title, strings.Join(sections, "\n")),
+		Blocks: blocks,
+	}
+}
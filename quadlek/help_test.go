@@ -0,0 +1,41 @@
+package quadlek
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
+)
+
+func TestHelp(t *testing.T) {
+	spec := &cmdparse.Spec{
+		Name: "comic",
+		Subcommands: []*cmdparse.Spec{
+			{Name: "del", Description: "Delete a template.", Args: []cmdparse.Arg{{Name: "id", Type: cmdparse.Int}}},
+		},
+	}
+	noop := func(ctx context.Context, msg *CommandMsg) (*CommandResp, error) { return nil, nil }
+
+	b := &Bot{commands: map[string]*registeredCommand{
+		"comic": {PluginId: "comics", Command: CommandFunc("comic", noop, WithSpec(spec), WithDescription("Make a comic."))},
+		"roll":  {PluginId: "random", Command: CommandFunc("roll", noop, WithUsage("[max]"))},
+	}}
+
+	resp := b.commandList()
+	require.Equal(t, "*comics*\n`/comic` Make a comic.\n*random*\n`/roll`\n", resp.Text)
+
+	resp = b.commandHelp([]string{"roll"})
+	require.Contains(t, resp.Text, "`/roll [max]`")
+
+	resp = b.commandHelp([]string{"comic"})
+	require.Contains(t, resp.Text, "`/comic <del>`")
+	require.Contains(t, resp.Text, "• `/comic del <id>` Delete a template.")
+
+	resp = b.commandHelp([]string{"comic", "del"})
+	require.Contains(t, resp.Text, "Delete a template.\n*Usage*\n`/comic del <id>`")
+
+	resp = b.commandHelp([]string{"comic", "nope"})
+	require.Equal(t, "/comic has no nope subcommand. Use /help comic to see how to use it.", resp.Text)
+}
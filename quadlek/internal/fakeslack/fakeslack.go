@@ -0,0 +1,485 @@
+// Package fakeslack is a fake of the parts of the Slack Web API the Bot and plugins use. It serves a workspace of
+// users, channels and message history, and tells its Observer about everything the Bot sends.
+// It backs both the console and quadlektest.
+package fakeslack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	// BotUserId is the user id of the Bot in the workspace.
+	BotUserId = "UQUADLEK"
+	// BotId is the bot id of the Bot in the workspace.
+	BotId = "BQUADLEK"
+	// BotName is the name of the Bot in the workspace.
+	BotName = "quadlek"
+)
+
+// Message is a message the Bot sent to Slack.
+type Message struct {
+	Channel         string
+	Text            string
+	Timestamp       string
+	ThreadTimestamp string
+	Attachments     []slack.Attachment
+	Blocks          slack.Blocks
+	// User is the user an ephemeral message was shown to, or the user that ran the command for a response url message.
+	User      string
+	Ephemeral bool
+	// ResponseUrl is true if the message was the response to a slash command, sent to the command's response url.
+	ResponseUrl bool
+}
+
+// Reaction is a reaction the Bot added to a message.
+type Reaction struct {
+	Channel   string
+	Timestamp string
+	Name      string
+}
+
+// Call is a request the Bot made to Slack.
+type Call struct {
+	// Method is the Slack API method, or response_url for replies sent to a slash command's response url.
+	Method string            `json:"method"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// File is a file the Bot uploaded.
+type File struct {
+	Channels []string
+	Name     string
+	Title    string
+}
+
+// Observer is told about the calls the Bot makes. Any of the funcs can be nil.
+// They are called without the workspace locked, so they can look up users and channels.
+type Observer struct {
+	Call     func(call Call)
+	Message  func(msg Message)
+	Reaction func(reaction Reaction)
+	View     func(view slack.ModalViewRequest)
+	File     func(file File)
+	// Unknown is called with the methods that aren't faked. They fail with an unknown_method error.
+	Unknown func(method string)
+}
+
+// Workspace is the fake Slack. It is an http.Handler that serves the Web API under /api/, and slash command
+// response urls, /response/<channel>/<user>.
+type Workspace struct {
+	teamId   string
+	observer Observer
+
+	mtx      sync.Mutex
+	users    map[string]slack.User
+	channels map[string]slack.Channel
+	history  map[string][]slack.Message
+	lastTs   int
+	views    int
+}
+
+// New returns a workspace with the Bot's user.
+func New(teamId string, observer Observer) *Workspace {
+	w := &Workspace{
+		teamId:   teamId,
+		observer: observer,
+		users:    make(map[string]slack.User),
+		channels: make(map[string]slack.Channel),
+		history:  make(map[string][]slack.Message),
+	}
+	w.AddUser(BotUserId, BotName)
+
+	return w
+}
+
+// AddUser adds a user to the workspace, replacing any user with the same id.
+func (w *Workspace) AddUser(id, name string) slack.User {
+	user := slack.User{ID: id, Name: name, RealName: name, TeamID: w.teamId, IsBot: id == BotUserId}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.users[id] = user
+
+	return user
+}
+
+// AddChannel adds a public channel the Bot is a member of to the workspace, replacing any channel with the same id.
+func (w *Workspace) AddChannel(id, name string) slack.Channel {
+	channel := slack.Channel{IsChannel: true, IsMember: true}
+	channel.ID = id
+	channel.Name = name
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.channels[id] = channel
+
+	return channel
+}
+
+// User returns the user with the given id.
+func (w *Workspace) User(id string) (slack.User, bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	user, ok := w.users[id]
+	return user, ok
+}
+
+// Channel returns the channel with the given id.
+func (w *Workspace) Channel(id string) (slack.Channel, bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	channel, ok := w.channels[id]
+	return channel, ok
+}
+
+// UserByName returns the user with the given name.
+func (w *Workspace) UserByName(name string) (slack.User, bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, user := range w.users {
+		if user.Name == name {
+			return user, true
+		}
+	}
+
+	return slack.User{}, false
+}
+
+// ChannelByName returns the channel with the given name.
+func (w *Workspace) ChannelByName(name string) (slack.Channel, bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, channel := range w.channels {
+		if channel.Name == name {
+			return channel, true
+		}
+	}
+
+	return slack.Channel{}, false
+}
+
+// AddMessage adds a message from userId to a channel's history, and returns its timestamp. Messages from the Bot
+// have an empty userId.
+func (w *Workspace) AddMessage(channelId, userId, text string) string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return w.addHistory(channelId, userId, text, "")
+}
+
+// History returns a channel's messages, oldest first.
+func (w *Workspace) History(channelId string) []slack.Message {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return append([]slack.Message{}, w.history[channelId]...)
+}
+
+// nextTs returns a new message timestamp. The caller must hold the lock.
+func (w *Workspace) nextTs() string {
+	w.lastTs++
+	return fmt.Sprintf("1600000000.%06d", w.lastTs)
+}
+
+// addHistory adds a message to a channel's history, and returns its timestamp. The caller must hold the lock.
+func (w *Workspace) addHistory(channelId, userId, text, threadTs string) string {
+	msg := slack.Message{}
+	msg.Type = "message"
+	msg.Channel = channelId
+	msg.User = userId
+	msg.Text = text
+	msg.Timestamp = w.nextTs()
+	msg.ThreadTimestamp = threadTs
+	if userId == "" {
+		msg.User = BotUserId
+		msg.BotID = BotId
+	}
+	w.history[channelId] = append(w.history[channelId], msg)
+
+	return msg.Timestamp
+}
+
+// send records a message the Bot sent, and returns it with its timestamp. Messages other than ephemeral ones are
+// added to the channel's history. The caller must hold the lock.
+func (w *Workspace) send(msg Message) Message {
+	if msg.Ephemeral {
+		msg.Timestamp = w.nextTs()
+		return msg
+	}
+
+	msg.Timestamp = w.addHistory(msg.Channel, "", msg.Text, msg.ThreadTimestamp)
+	return msg
+}
+
+// ServeHTTP implements http.Handler
+func (w *Workspace) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/response/") {
+		w.serveResponseUrl(rw, r)
+		return
+	}
+
+	var body []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		body, _ = io.ReadAll(r.Body)
+	} else {
+		_ = r.ParseMultipartForm(1 << 20)
+	}
+
+	params := JSONParams(body)
+	if body == nil {
+		params = make(map[string]string, len(r.Form))
+		for k := range r.Form {
+			if k != "token" {
+				params[k] = r.Form.Get(k)
+			}
+		}
+	}
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if w.observer.Call != nil {
+		w.observer.Call(Call{Method: method, Params: params})
+	}
+
+	resp, err, notify := w.call(method, r, body)
+	if notify != nil {
+		notify()
+	}
+	if err != "" {
+		resp = map[string]interface{}{"ok": false, "error": err}
+	} else {
+		resp["ok"] = true
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(resp)
+}
+
+// call serves a Slack API method. It returns the response or a Slack error code, and a func that tells the
+// observer about the call, which is run once the lock is released.
+func (w *Workspace) call(method string, r *http.Request, body []byte) (map[string]interface{}, string, func()) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	switch method {
+	case "auth.test":
+		return map[string]interface{}{"user_id": BotUserId, "bot_id": BotId, "user": BotName, "team_id": w.teamId}, "", nil
+
+	case "users.list":
+		users := make([]slack.User, 0, len(w.users))
+		for _, user := range w.users {
+			users = append(users, user)
+		}
+		sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+		return map[string]interface{}{"members": users}, "", nil
+
+	case "users.info":
+		user, ok := w.users[r.FormValue("user")]
+		if !ok {
+			return nil, "user_not_found", nil
+		}
+		return map[string]interface{}{"user": user}, "", nil
+
+	case "usergroups.users.list":
+		return map[string]interface{}{"users": []string{}}, "", nil
+
+	case "conversations.list":
+		channels := make([]slack.Channel, 0, len(w.channels))
+		for _, channel := range w.channels {
+			channels = append(channels, channel)
+		}
+		sort.Slice(channels, func(i, j int) bool { return channels[i].ID < channels[j].ID })
+		return map[string]interface{}{"channels": channels}, "", nil
+
+	case "conversations.info":
+		channel, ok := w.channels[r.FormValue("channel")]
+		if !ok {
+			return nil, "channel_not_found", nil
+		}
+		return map[string]interface{}{"channel": channel}, "", nil
+
+	case "conversations.open":
+		userId := strings.Split(r.FormValue("users"), ",")[0]
+		if _, ok := w.users[userId]; !ok {
+			return nil, "user_not_found", nil
+		}
+		channel := slack.Channel{}
+		channel.ID = "D" + strings.TrimPrefix(userId, "U")
+		channel.IsIM = true
+		channel.User = userId
+		w.channels[channel.ID] = channel
+		return map[string]interface{}{"channel": channel}, "", nil
+
+	case "conversations.history":
+		history := w.history[r.FormValue("channel")]
+		// Slack returns the newest messages first.
+		messages := make([]slack.Message, 0, len(history))
+		for i := len(history) - 1; i >= 0; i-- {
+			messages = append(messages, history[i])
+		}
+		return map[string]interface{}{"messages": messages, "has_more": false}, "", nil
+
+	case "chat.postMessage", "chat.postEphemeral":
+		channel := r.FormValue("channel")
+		if _, ok := w.channels[channel]; !ok {
+			return nil, "channel_not_found", nil
+		}
+		msg := Message{
+			Channel:         channel,
+			Text:            r.FormValue("text"),
+			ThreadTimestamp: r.FormValue("thread_ts"),
+			User:            r.FormValue("user"),
+			Ephemeral:       method == "chat.postEphemeral",
+		}
+		if attachments := r.FormValue("attachments"); attachments != "" {
+			_ = json.Unmarshal([]byte(attachments), &msg.Attachments)
+		}
+		if blocks := r.FormValue("blocks"); blocks != "" {
+			_ = json.Unmarshal([]byte(blocks), &msg.Blocks)
+		}
+
+		msg = w.send(msg)
+		notify := func() {
+			if w.observer.Message != nil {
+				w.observer.Message(msg)
+			}
+		}
+		if msg.Ephemeral {
+			return map[string]interface{}{"message_ts": msg.Timestamp}, "", notify
+		}
+		return map[string]interface{}{"channel": channel, "ts": msg.Timestamp}, "", notify
+
+	case "reactions.add":
+		reaction := Reaction{
+			Channel:   r.FormValue("channel"),
+			Timestamp: r.FormValue("timestamp"),
+			Name:      r.FormValue("name"),
+		}
+		return map[string]interface{}{}, "", func() {
+			if w.observer.Reaction != nil {
+				w.observer.Reaction(reaction)
+			}
+		}
+
+	case "views.open", "views.push", "views.update":
+		req := struct {
+			View slack.ModalViewRequest `json:"view"`
+		}{}
+		err := json.Unmarshal(body, &req)
+		if err != nil {
+			return nil, "invalid_arguments", nil
+		}
+		w.views++
+		return map[string]interface{}{"view": map[string]interface{}{"id": fmt.Sprintf("V%d", w.views), "type": req.View.Type}}, "", func() {
+			if w.observer.View != nil {
+				w.observer.View(req.View)
+			}
+		}
+
+	case "files.upload":
+		file := File{
+			Channels: strings.Split(r.FormValue("channels"), ","),
+			Name:     r.FormValue("filename"),
+			Title:    r.FormValue("title"),
+		}
+		if r.MultipartForm != nil && len(r.MultipartForm.File["file"]) > 0 {
+			file.Name = r.MultipartForm.File["file"][0].Filename
+		}
+		return map[string]interface{}{"file": map[string]interface{}{"id": "FQUADLEK", "name": file.Name}}, "", func() {
+			if w.observer.File != nil {
+				w.observer.File(file)
+			}
+		}
+	}
+
+	return nil, "unknown_method", func() {
+		if w.observer.Unknown != nil {
+			w.observer.Unknown(method)
+		}
+	}
+}
+
+// serveResponseUrl serves the responses plugins send to a slash command's response url, /response/<channel>/<user>.
+func (w *Workspace) serveResponseUrl(rw http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/response/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(rw, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := struct {
+		Text         string             `json:"text"`
+		Attachments  []slack.Attachment `json:"attachments"`
+		Blocks       slack.Blocks       `json:"blocks"`
+		ResponseType string             `json:"response_type"`
+	}{}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := JSONParams(body)
+	params["channel"] = parts[0]
+	params["user"] = parts[1]
+	if w.observer.Call != nil {
+		w.observer.Call(Call{Method: "response_url", Params: params})
+	}
+
+	w.mtx.Lock()
+	msg := w.send(Message{
+		Channel:     parts[0],
+		User:        parts[1],
+		Text:        resp.Text,
+		Attachments: resp.Attachments,
+		Blocks:      resp.Blocks,
+		Ephemeral:   resp.ResponseType != "in_channel",
+		ResponseUrl: true,
+	})
+	w.mtx.Unlock()
+	if w.observer.Message != nil {
+		w.observer.Message(msg)
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write([]byte(`{"ok":true}`))
+}
+
+// JSONParams flattens the top level of a JSON object into call params. Strings are unquoted, nulls are dropped,
+// and other values are kept as JSON. It returns nil if body isn't a JSON object.
+func JSONParams(body []byte) map[string]string {
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(body, &fields) != nil {
+		return nil
+	}
+
+	params := make(map[string]string, len(fields))
+	for k, v := range fields {
+		if string(v) == "null" {
+			continue
+		}
+		var str string
+		if json.Unmarshal(v, &str) == nil {
+			params[k] = str
+			continue
+		}
+		params[k] = string(v)
+	}
+
+	return params
+}
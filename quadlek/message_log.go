@@ -1,9 +1,9 @@
 package quadlek
 
 import (
-	"time"
-
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/slack-go/slack"
 )
@@ -26,6 +26,11 @@ type MessageLotOpts struct {
 
 // GetMessageLog uses channel and a set of options to get historical messages from the Slack API.
 func (b *Bot) GetMessageLog(channel string, opts MessageLotOpts) ([]slack.Message, error) {
+	return b.GetMessageLogContext(context.Background(), channel, opts)
+}
+
+// GetMessageLogContext is GetMessageLog with a context, which carries the trace of the message being handled.
+func (b *Bot) GetMessageLogContext(ctx context.Context, channel string, opts MessageLotOpts) ([]slack.Message, error) {
 	params := &slack.GetConversationHistoryParameters{}
 	if opts.Count != 0 {
 		params.Limit = opts.Count
@@ -36,7 +41,7 @@ func (b *Bot) GetMessageLog(channel string, opts MessageLotOpts) ([]slack.Messag
 		params.Oldest = oldestTs
 	}
 	params.ChannelID = channel
-	history, err := b.api.GetConversationHistory(params)
+	history, err := b.api.GetConversationHistoryContext(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -61,14 +66,20 @@ func (b *Bot) GetMessageLog(channel string, opts MessageLotOpts) ([]slack.Messag
 	return msgs, nil
 }
 
+// GetMessage returns the message in channel posted at ts.
 func (b *Bot) GetMessage(channel, ts string) (slack.Message, error) {
+	return b.GetMessageContext(context.Background(), channel, ts)
+}
+
+// GetMessageContext is GetMessage with a context, which carries the trace of the message being handled.
+func (b *Bot) GetMessageContext(ctx context.Context, channel, ts string) (slack.Message, error) {
 	params := &slack.GetConversationHistoryParameters{}
 	params.Limit = 1
 	params.Latest = ts
 	params.Inclusive = true
 	params.ChannelID = channel
 
-	history, err := b.api.GetConversationHistory(params)
+	history, err := b.api.GetConversationHistoryContext(ctx, params)
 	if err != nil {
 		return slack.Message{}, err
 	}
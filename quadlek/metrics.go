@@ -0,0 +1,278 @@
+package quadlek
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.uber.org/zap"
+)
+
+const metricsNamespace = "quadlek"
+
+// metrics are the Bot's own Prometheus metrics. Plugins register theirs in the same registry, see Bot.Counter.
+//
+// A nil *metrics records nothing, so Bots that weren't created with NewBot still work.
+type metrics struct {
+	registry        *prometheus.Registry
+	events          *prometheus.CounterVec
+	interactions    *prometheus.CounterVec
+	commands        *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	handlerErrors   *prometheus.CounterVec
+	apiRequests     *prometheus.CounterVec
+	apiDuration     *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_total",
+			Help:      "Events received from Slack, by event type.",
+		}, []string{"type"}),
+		interactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "interactions_total",
+			Help:      "Interactions received from Slack, by interaction type.",
+		}, []string{"type"}),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "commands_total",
+			Help:      "Slash commands dispatched to plugins, by plugin and command.",
+		}, []string{"plugin", "command"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "handler_duration_seconds",
+			Help:      "How long plugin handlers take, by plugin, kind of handler and name.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"plugin", "kind", "name"}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "handler_errors_total",
+			Help:      "Plugin handlers that returned an error or panicked, by plugin, kind of handler and name.",
+		}, []string{"plugin", "kind", "name"}),
+		apiRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "slack_api_requests_total",
+			Help:      "Requests to the Slack API, by method and result. The result is ok, the Slack error code, or the HTTP status.",
+		}, []string{"method", "result"}),
+		apiDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "slack_api_request_duration_seconds",
+			Help:      "How long requests to the Slack API take, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.events,
+		m.interactions,
+		m.commands,
+		m.handlerDuration,
+		m.handlerErrors,
+		m.apiRequests,
+		m.apiDuration,
+	)
+
+	return m
+}
+
+// registerBot registers the metrics that are read from the Bot when they are collected.
+func (m *metrics) registerBot(b *Bot) {
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_duplicate_total",
+			Help:      "Events that were dropped because Slack delivered them more than once.",
+		}, func() float64 {
+			return float64(b.events.duplicateCount())
+		}),
+		&queueCollector{bot: b},
+	)
+}
+
+func (m *metrics) event(eventType string) {
+	if m == nil {
+		return
+	}
+	m.events.WithLabelValues(eventType).Inc()
+}
+
+func (m *metrics) interaction(interactionType string) {
+	if m == nil {
+		return
+	}
+	m.interactions.WithLabelValues(interactionType).Inc()
+}
+
+func (m *metrics) command(pluginId, command string) {
+	if m == nil {
+		return
+	}
+	m.commands.WithLabelValues(pluginId, command).Inc()
+}
+
+// handled records how long a plugin handler took, and whether it failed.
+func (m *metrics) handled(pluginId, kind, name string, took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.handlerDuration.WithLabelValues(pluginId, kind, name).Observe(took.Seconds())
+	if err != nil {
+		m.handlerErrors.WithLabelValues(pluginId, kind, name).Inc()
+	}
+}
+
+// transport instruments the requests the Bot makes to Slack with next.
+func (m *metrics) transport(next http.RoundTripper) http.RoundTripper {
+	return &metricsTransport{metrics: m, next: next}
+}
+
+// metricsTransport counts and times requests to the Slack API. Slack reports most errors with a 200 and an
+// error code in the body, so the body is peeked at to find the result.
+type metricsTransport struct {
+	metrics *metrics
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := apiMethod(req)
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.metrics.apiDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		t.metrics.apiRequests.WithLabelValues(method, "error").Inc()
+		return nil, err
+	}
+
+	result := fmt.Sprintf("http_%d", resp.StatusCode)
+	if resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.metrics.apiRequests.WithLabelValues(method, "error").Inc()
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		apiResp := struct {
+			Ok    bool   `json:"ok"`
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(body, &apiResp) == nil {
+			switch {
+			case apiResp.Ok:
+				result = "ok"
+			case apiResp.Error != "":
+				result = apiResp.Error
+			}
+		}
+	}
+	t.metrics.apiRequests.WithLabelValues(method, result).Inc()
+
+	return resp, nil
+}
+
+// apiMethod returns the Slack API method a request is for, e.g. chat.postMessage.
+// Requests to anything other than the Web API are replies to a response url.
+func apiMethod(req *http.Request) string {
+	_, method, ok := strings.Cut(req.URL.Path, "/api/")
+	if !ok || method == "" {
+		return "response_url"
+	}
+
+	return method
+}
+
+var (
+	queueLengthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "queue", "length"),
+		"Messages waiting in a handler's queue, by plugin, kind of handler and name.",
+		[]string{"plugin", "kind", "name"}, nil,
+	)
+	queueDroppedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "queue", "dropped_total"),
+		"Messages dropped because a handler's queue was full, by plugin, kind of handler and name.",
+		[]string{"plugin", "kind", "name"}, nil,
+	)
+)
+
+// queueCollector reports the state of the handler queues, see Bot.QueueStats.
+type queueCollector struct {
+	bot *Bot
+}
+
+// Describe implements prometheus.Collector
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueLengthDesc
+	ch <- queueDroppedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range c.bot.QueueStats() {
+		ch <- prometheus.MustNewConstMetric(queueLengthDesc, prometheus.GaugeValue, float64(stats.Len), stats.PluginId, stats.Kind, stats.Name)
+		ch <- prometheus.MustNewConstMetric(queueDroppedDesc, prometheus.CounterValue, float64(stats.Dropped), stats.PluginId, stats.Kind, stats.Name)
+	}
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// MetricsRegisterer returns the Registerer plugins can register their own Prometheus collectors with.
+// Metric names are prefixed with quadlek_<pluginId>_, and the metrics are served with the Bot's on /metrics.
+func (b *Bot) MetricsRegisterer(pluginId string) prometheus.Registerer {
+	prefix := fmt.Sprintf("%s_%s_", metricsNamespace, invalidMetricChars.ReplaceAllString(pluginId, "_"))
+	if b.metrics == nil {
+		return prometheus.WrapRegistererWithPrefix(prefix, prometheus.NewRegistry())
+	}
+
+	return prometheus.WrapRegistererWithPrefix(prefix, b.metrics.registry)
+}
+
+// Counter returns a counter for a plugin, e.g. Counter("karma", "updates_total", "Karma changes.", "direction").
+// Calling it again with the same name returns the same counter, so plugins don't need to hold on to it.
+func (b *Bot) Counter(pluginId, name, help string, labels ...string) *prometheus.CounterVec {
+	return registerMetric(b, pluginId, prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels))
+}
+
+// Gauge returns a gauge for a plugin. See Counter.
+func (b *Bot) Gauge(pluginId, name, help string, labels ...string) *prometheus.GaugeVec {
+	return registerMetric(b, pluginId, prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels))
+}
+
+// Histogram returns a histogram for a plugin. If buckets is nil, prometheus.DefBuckets are used. See Counter.
+func (b *Bot) Histogram(pluginId, name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	return registerMetric(b, pluginId, prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels))
+}
+
+// registerMetric registers c for a plugin, or returns the collector already registered under its name.
+// If c can't be registered it is logged and returned anyway, so the plugin can still use it.
+func registerMetric[T prometheus.Collector](b *Bot, pluginId string, c T) T {
+	err := b.MetricsRegisterer(pluginId).Register(c)
+	if err == nil {
+		return c
+	}
+
+	if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+	if b.Log != nil {
+		b.Log.Error("unable to register metric", zap.String("plugin", pluginId), zap.Error(err))
+	}
+
+	return c
+}
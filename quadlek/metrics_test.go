@@ -0,0 +1,72 @@
+package quadlek
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack/slackevents"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/chat.postMessage") {
+			_, _ = w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+
+	b, err := NewBot(context.Background(),
+		WithApiKey("xoxb-test"),
+		WithSigningSecret("secret"),
+		WithApiUrl(srv.URL+"/api/"),
+		WithStorage(storage.NewMemory()),
+		WithLogger(zap.NewNop()),
+	)
+	require.NoError(t, err)
+	defer b.Stop()
+
+	b.Say("CGENERAL", "hi")
+	_, err = b.api.AuthTest()
+	require.NoError(t, err)
+	b.dispatchEvent(context.Background(), slackevents.EventsAPIEvent{
+		InnerEvent: slackevents.EventsAPIInnerEvent{Type: "reaction_added", Data: &slackevents.ReactionAddedEvent{}},
+	})
+	_, _ = invoke(context.Background(), context.Background(), 0, b, b.getStore("test"), "command", "fail", func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, errors.New("boom")
+	})
+
+	// Plugin metrics are registered once, however often they are asked for.
+	b.Counter("karma-test", "updates_total", "Karma changes.", "direction").WithLabelValues("up").Inc()
+	b.Counter("karma-test", "updates_total", "Karma changes.", "direction").WithLabelValues("up").Inc()
+
+	w := httptest.NewRecorder()
+	b.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, metric := range []string{
+		`quadlek_slack_api_requests_total{method="chat.postMessage",result="channel_not_found"} 1`,
+		`quadlek_slack_api_requests_total{method="auth.test",result="ok"} 1`,
+		`quadlek_events_total{type="reaction_added"} 1`,
+		`quadlek_handler_errors_total{kind="command",name="fail",plugin="test"} 1`,
+		`quadlek_handler_duration_seconds_count{kind="command",name="fail",plugin="test"} 1`,
+		`quadlek_events_duplicate_total 0`,
+		`quadlek_karma_test_updates_total{direction="up"} 2`,
+	} {
+		require.Contains(t, w.Body.String(), metric)
+	}
+
+	b.config.DisableMetrics = true
+	w = httptest.NewRecorder()
+	b.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
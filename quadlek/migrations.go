@@ -0,0 +1,92 @@
+package quadlek
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// migrationPrefix is the prefix of the core plugin's keys that record the schema version of each plugin's bucket.
+const migrationPrefix = "migration:"
+
+// Migration upgrades the data in a plugin's bucket to Version.
+// Migrations that haven't been applied to a plugin's bucket run in order when the plugin is registered, before it is loaded.
+// If one fails the plugin isn't registered.
+type Migration struct {
+	// Version numbers start at 1 and must increase by one for each migration.
+	Version     int
+	Description string
+	// Migrate runs in a single transaction with the plugin's bucket. If it returns an error nothing it wrote is kept.
+	Migrate func(bkt storage.Bucket) error
+}
+
+// SchemaVersion returns the version of the last migration applied to a plugin's bucket, or 0 if none have been.
+func (b *Bot) SchemaVersion(pluginId string) (int, error) {
+	version := 0
+	err := b.getStore(corePluginId).Get(migrationPrefix+pluginId, func(val []byte) error {
+		if len(val) == 0 {
+			return nil
+		}
+
+		var err error
+		version, err = strconv.Atoi(string(val))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// migrate applies the migrations that haven't been applied to a plugin's bucket.
+// Each migration and the version it brings the bucket to are stored in the same transaction.
+func (b *Bot) migrate(pluginId string, migrations []Migration) error {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return fmt.Errorf("migration %d for %s must have version %d", m.Version, pluginId, i+1)
+		}
+		if m.Migrate == nil {
+			return fmt.Errorf("migration %d for %s has no Migrate function", m.Version, pluginId)
+		}
+	}
+
+	version, err := b.SchemaVersion(pluginId)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("the %s bucket is at version %d, which is newer than this build of the plugin supports", pluginId, version)
+	}
+
+	pluginStore := b.getStore(pluginId)
+	coreStore := b.getStore(corePluginId)
+	for _, m := range migrations[version:] {
+		err = b.storage.Update(func(tx storage.Tx) error {
+			pluginBkt, err := pluginStore.bucket(tx)
+			if err != nil {
+				return err
+			}
+			coreBkt, err := coreStore.bucket(tx)
+			if err != nil {
+				return err
+			}
+
+			err = m.Migrate(pluginBkt)
+			if err != nil {
+				return err
+			}
+
+			return coreBkt.Put([]byte(migrationPrefix+pluginId), []byte(strconv.Itoa(m.Version)))
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d for %s failed: %w", m.Version, pluginId, pluginStore.withPluginId(err))
+		}
+		b.Log.Info("applied migration", zap.String("plugin", pluginId), zap.Int("version", m.Version), zap.String("description", m.Description))
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package quadlek
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestMigrate(t *testing.T) {
+	b := &Bot{storage: storage.NewMemory(), Log: zap.NewNop()}
+	store := b.getStore("test")
+
+	var ran []int
+	migration := func(version int, key string) Migration {
+		return Migration{
+			Version: version,
+			Migrate: func(bkt storage.Bucket) error {
+				ran = append(ran, version)
+				return bkt.Put([]byte(key), []byte("done"))
+			},
+		}
+	}
+
+	require.NoError(t, b.migrate("test", []Migration{migration(1, "one")}))
+	require.Equal(t, []int{1}, ran)
+
+	require.NoError(t, b.migrate("test", []Migration{migration(1, "one"), migration(2, "two")}))
+	require.Equal(t, []int{1, 2}, ran)
+
+	version, err := b.SchemaVersion("test")
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+
+	require.NoError(t, store.Get("two", func(val []byte) error {
+		require.Equal(t, []byte("done"), val)
+		return nil
+	}))
+
+	failed := errors.New("failed")
+	err = b.migrate("test", []Migration{migration(1, "one"), migration(2, "two"), {
+		Version: 3,
+		Migrate: func(bkt storage.Bucket) error {
+			require.NoError(t, bkt.Put([]byte("three"), []byte("partial")))
+			return failed
+		},
+	}})
+	require.ErrorIs(t, err, failed)
+
+	version, err = b.SchemaVersion("test")
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+	require.NoError(t, store.Get("three", func(val []byte) error {
+		require.Nil(t, val)
+		return nil
+	}))
+
+	require.Error(t, b.migrate("test", []Migration{migration(1, "one")}))
+}
+
+func TestMigrateValidatesVersions(t *testing.T) {
+	b := &Bot{storage: storage.NewMemory(), Log: zap.NewNop()}
+	noop := func(bkt storage.Bucket) error { return nil }
+
+	require.Error(t, b.migrate("test", []Migration{{Version: 2, Migrate: noop}}))
+	require.Error(t, b.migrate("test", []Migration{{Version: 1, Migrate: noop}, {Version: 1, Migrate: noop}}))
+	require.Error(t, b.migrate("test", []Migration{{Version: 1}}))
+
+	version, err := b.SchemaVersion("test")
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+}
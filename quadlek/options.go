@@ -0,0 +1,252 @@
+package quadlek
+
+import (
+	"encoding/base64"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// Option configures the Bot when it is created with NewBot.
+// Options are applied in order, so later options override earlier ones, including values read by WithConfigFile.
+type Option func(cfg *Config) error
+
+// WithConfig replaces the entire configuration with the provided Config.
+func WithConfig(config *Config) Option {
+	return func(cfg *Config) error {
+		*cfg = *config
+		if cfg.Plugins == nil {
+			cfg.Plugins = make(map[string]*PluginConfig)
+		}
+		return nil
+	}
+}
+
+// WithConfigFile reads a YAML or TOML config file. See LoadConfig.
+func WithConfigFile(path string) Option {
+	return func(cfg *Config) error {
+		return cfg.load(path)
+	}
+}
+
+// WithApiKey sets the Slack API key the Bot uses to authenticate.
+func WithApiKey(apiKey string) Option {
+	return func(cfg *Config) error {
+		cfg.ApiKey = apiKey
+		return nil
+	}
+}
+
+// WithApiUrl overrides the base url of the Slack Web API. It must end with a slash.
+func WithApiUrl(url string) Option {
+	return func(cfg *Config) error {
+		cfg.ApiUrl = url
+		return nil
+	}
+}
+
+// WithSigningSecret sets the secret used to validate that webhooks are coming from Slack.
+// Requests signed with any of others are also accepted, e.g. the previous secret while it is being rotated.
+func WithSigningSecret(secret string, others ...string) Option {
+	return func(cfg *Config) error {
+		cfg.SigningSecret = secret
+		cfg.SigningSecrets = others
+		return nil
+	}
+}
+
+// WithSocketMode makes the Bot receive events, slash commands and interactions over a Slack socket mode
+// connection instead of the webhook server.
+//
+// appToken is the app-level token (xapp-...) with the connections:write scope used to open the connection.
+func WithSocketMode(appToken string) Option {
+	return func(cfg *Config) error {
+		cfg.AppToken = appToken
+		return nil
+	}
+}
+
+// WithConsole runs the Bot against a local console instead of Slack, to try out plugins without a workspace.
+// The console has its own users and channels, and the Bot keeps its data in a scratch in-memory database
+// unless WithStorage is used. Run the Bot with RunConsole instead of Start.
+func WithConsole(in io.Reader, out io.Writer) Option {
+	return func(cfg *Config) error {
+		cfg.Console = &ConsoleConfig{In: in, Out: out}
+		return nil
+	}
+}
+
+// WithListenAddr sets the address the webhook server listens on.
+func WithListenAddr(addr string) Option {
+	return func(cfg *Config) error {
+		cfg.ListenAddr = addr
+		return nil
+	}
+}
+
+// WithTLS makes the webhook server serve TLS using the provided certificate and key files.
+func WithTLS(certFile, keyFile string) Option {
+	return func(cfg *Config) error {
+		cfg.TLSCertFile = certFile
+		cfg.TLSKeyFile = keyFile
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the Bot. It takes precedence over WithLogLevel.
+func WithLogger(log *zap.Logger) Option {
+	return func(cfg *Config) error {
+		cfg.Logger = log
+		return nil
+	}
+}
+
+// WithLogLevel sets the minimum level that is logged, e.g. debug, info, warn or error.
+func WithLogLevel(level string) Option {
+	return func(cfg *Config) error {
+		cfg.LogLevel = level
+		return nil
+	}
+}
+
+// WithStorage sets the storage backend the Bot keeps plugin data in, e.g. storage.NewMemory() in tests.
+// It takes precedence over the storage configured in a config file.
+func WithStorage(backend storage.Backend) Option {
+	return func(cfg *Config) error {
+		cfg.Backend = backend
+		return nil
+	}
+}
+
+// WithDBPath sets the path to the bolt database, or the data source name of the SQLite database.
+func WithDBPath(path string) Option {
+	return func(cfg *Config) error {
+		cfg.DBPath = path
+		return nil
+	}
+}
+
+// WithDBTimeout sets how long to wait to obtain the lock on the bolt database.
+func WithDBTimeout(timeout time.Duration) Option {
+	return func(cfg *Config) error {
+		cfg.DBTimeout = timeout
+		return nil
+	}
+}
+
+// WithAdminChannel sets the name of the channel the Bot announces itself in.
+func WithAdminChannel(channel string) Option {
+	return func(cfg *Config) error {
+		cfg.AdminChannel = channel
+		return nil
+	}
+}
+
+// WithAdmins sets the ids of Slack users that always have the admin role.
+func WithAdmins(userIds ...string) Option {
+	return func(cfg *Config) error {
+		cfg.Admins = userIds
+		return nil
+	}
+}
+
+// WithVersion sets the version that is announced in the admin channel when the Bot starts.
+func WithVersion(version string) Option {
+	return func(cfg *Config) error {
+		cfg.Version = version
+		return nil
+	}
+}
+
+// WithDebug enables debug logging of Slack API calls.
+func WithDebug(debug bool) Option {
+	return func(cfg *Config) error {
+		cfg.Debug = debug
+		return nil
+	}
+}
+
+// WithQueueConfig sets the default configuration for the queue in front of each handler.
+func WithQueueConfig(queue QueueConfig) Option {
+	return func(cfg *Config) error {
+		cfg.Queue = queue
+		return nil
+	}
+}
+
+// WithSealingKey sets the key that secrets stored by plugins are encrypted with. key must be 32 bytes.
+// Values sealed with a previous key can still be opened if it is passed in oldKeys, by key id.
+func WithSealingKey(keyId string, key []byte, oldKeys map[string][]byte) Option {
+	return func(cfg *Config) error {
+		cfg.Sealing.KeyId = keyId
+		cfg.Sealing.Key = base64.StdEncoding.EncodeToString(key)
+		cfg.Sealing.OldKeys = make(map[string]string, len(oldKeys))
+		for id, oldKey := range oldKeys {
+			cfg.Sealing.OldKeys[id] = base64.StdEncoding.EncodeToString(oldKey)
+		}
+		return nil
+	}
+}
+
+// WithTracing configures OpenTelemetry tracing, e.g. TracingConfig{Exporter: "stdout"} to print spans locally.
+func WithTracing(tracing TracingConfig) Option {
+	return func(cfg *Config) error {
+		cfg.Tracing = tracing
+		return nil
+	}
+}
+
+// WithTracerProvider sets the TracerProvider the Bot traces with, for programs that set up OpenTelemetry themselves.
+// It takes precedence over WithTracing.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(cfg *Config) error {
+		cfg.TracerProvider = tp
+		return nil
+	}
+}
+
+// WithRecording appends the requests the Bot receives from Slack to the JSONL file at path. See Config.RecordPath.
+func WithRecording(path string) Option {
+	return func(cfg *Config) error {
+		cfg.RecordPath = path
+		return nil
+	}
+}
+
+// WithBackupConfig configures scheduled backups of the database. Fields left empty keep their defaults.
+func WithBackupConfig(backup BackupConfig) Option {
+	return func(cfg *Config) error {
+		if backup.Schedule == "" {
+			backup.Schedule = cfg.Backup.Schedule
+		}
+		if backup.Keep == 0 {
+			backup.Keep = cfg.Backup.Keep
+		}
+		cfg.Backup = backup
+		return nil
+	}
+}
+
+// WithPluginConfig sets the config section for pluginId. v must be encodable as YAML.
+func WithPluginConfig(pluginId string, v interface{}) Option {
+	return func(cfg *Config) error {
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		section := &PluginConfig{}
+		err = yaml.Unmarshal(out, section)
+		if err != nil {
+			return err
+		}
+		cfg.Plugins[pluginId] = section
+
+		return nil
+	}
+}
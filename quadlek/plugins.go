@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/slack-go/slack/slackevents"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -15,6 +17,8 @@ import (
 	"net/http"
 
 	"github.com/slack-go/slack"
+
+	"github.com/jirwin/quadlek/quadlek/cmdparse"
 )
 
 // Command is the interface that plugins implement for slash commands.
@@ -29,6 +33,7 @@ type Command interface {
 type registeredCommand struct {
 	PluginId string
 	Command  Command
+	queue    *dispatchQueue[*CommandMsg]
 }
 
 // command is a an implementation of the Command interface
@@ -36,6 +41,7 @@ type command struct {
 	name    string
 	channel chan *CommandMsg
 	runFunc func(ctx context.Context, cmdChan <-chan *CommandMsg)
+	opts    handlerOptions
 }
 
 // GetName returns the name of the command. This name should match the slash command configured in slack.
@@ -53,12 +59,19 @@ func (c *command) Run(ctx context.Context) {
 	c.runFunc(ctx, c.channel)
 }
 
+// handlerOptions returns the options the command was made with.
+func (c *command) handlerOptions() *handlerOptions {
+	return &c.opts
+}
+
 // MakeCommand is a helper function that accepts a name and a runFunc, and returns a Command.
-func MakeCommand(name string, runFn func(ctx context.Context, cmdChan <-chan *CommandMsg)) Command {
+// The Bot can't tell when runFn is done with a message, so it isn't timed or traced like CommandFunc is.
+func MakeCommand(name string, runFn func(ctx context.Context, cmdChan <-chan *CommandMsg), opts ...HandlerOption) Command {
 	return &command{
 		name:    name,
 		runFunc: runFn,
 		channel: make(chan *CommandMsg),
+		opts:    makeHandlerOptions(opts),
 	}
 }
 
@@ -67,12 +80,19 @@ type CommandMsg struct {
 	Bot     *Bot
 	Command *slashCommand
 	Store   *Store
+	ctx     context.Context
+}
+
+// Context returns the context the command was dispatched with, which carries the trace of the request from Slack.
+func (m *CommandMsg) Context() context.Context {
+	return msgContext(m.ctx)
 }
 
 // CommandResp is the struct that is used to respond to a command if interaction is required.
 type CommandResp struct {
 	Text         string             `json:"text"`
 	Attachments  []slack.Attachment `json:"attachments"`
+	Blocks       []slack.Block      `json:"blocks,omitempty"`
 	ResponseType string             `json:"response_type"`
 	InChannel    bool               `json:"-"`
 }
@@ -89,6 +109,7 @@ type Interaction interface {
 type registeredInteraction struct {
 	PluginId    string
 	Interaction Interaction
+	queue       *dispatchQueue[*InteractionMsg]
 }
 
 // interaction is a an implementation of the Interaction interface
@@ -96,6 +117,7 @@ type interaction struct {
 	name    string
 	channel chan *InteractionMsg
 	runFunc func(ctx context.Context, interactionChan <-chan *InteractionMsg)
+	opts    handlerOptions
 }
 
 // GetName returns the name of the Interaction. This name should match the slash Interaction configured in slack.
@@ -113,12 +135,19 @@ func (c *interaction) Run(ctx context.Context) {
 	c.runFunc(ctx, c.channel)
 }
 
+// handlerOptions returns the options the Interaction was made with.
+func (c *interaction) handlerOptions() *handlerOptions {
+	return &c.opts
+}
+
 // MakeInteraction is a helper function that accepts a name and a runFunc, and returns a Interaction.
-func MakeInteraction(name string, runFn func(ctx context.Context, cmdChan <-chan *InteractionMsg)) Interaction {
+// The Bot can't tell when runFn is done with a message, so it isn't timed or traced like InteractionFunc is.
+func MakeInteraction(name string, runFn func(ctx context.Context, cmdChan <-chan *InteractionMsg), opts ...HandlerOption) Interaction {
 	return &interaction{
 		name:    name,
 		runFunc: runFn,
 		channel: make(chan *InteractionMsg),
+		opts:    makeHandlerOptions(opts),
 	}
 }
 
@@ -127,6 +156,12 @@ type InteractionMsg struct {
 	Bot         *Bot
 	Interaction *slack.InteractionCallback
 	Store       *Store
+	ctx         context.Context
+}
+
+// Context returns the context the interaction was dispatched with, which carries the trace of the request from Slack.
+func (m *InteractionMsg) Context() context.Context {
+	return msgContext(m.ctx)
 }
 
 // Hook is the interface that a plugin can implement to create a hook.
@@ -142,18 +177,26 @@ type HookMsg struct {
 	Bot   *Bot
 	Msg   *slack.Msg
 	Store *Store
+	ctx   context.Context
+}
+
+// Context returns the context the message was dispatched with, which carries the trace of the event from Slack.
+func (m *HookMsg) Context() context.Context {
+	return msgContext(m.ctx)
 }
 
 // registeredHook is the struct used internally to represent a registered hook.
 type registeredHook struct {
 	PluginId string
 	Hook     Hook
+	queue    *dispatchQueue[*HookMsg]
 }
 
 // hook is an internal implementation of the Hook interface.
 type hook struct {
 	channel chan *HookMsg
 	runFunc func(ctx context.Context, hookChan <-chan *HookMsg)
+	opts    handlerOptions
 }
 
 // Channel returns the channel for the Bot to write HookMsg objects to.
@@ -166,11 +209,18 @@ func (h *hook) Run(ctx context.Context) {
 	h.runFunc(ctx, h.channel)
 }
 
-// MakeHook is a helper function that accepts a runFunc and returns a Hook
-func MakeHook(runFunc func(ctx context.Context, hookChan <-chan *HookMsg)) Hook {
+// handlerOptions returns the options the hook was made with.
+func (h *hook) handlerOptions() *handlerOptions {
+	return &h.opts
+}
+
+// MakeHook is a helper function that accepts a runFunc and returns a Hook.
+// The Bot can't tell when runFunc is done with a message, so it isn't timed or traced like HookFunc is.
+func MakeHook(runFunc func(ctx context.Context, hookChan <-chan *HookMsg), opts ...HandlerOption) Hook {
 	return &hook{
 		channel: make(chan *HookMsg),
 		runFunc: runFunc,
+		opts:    makeHandlerOptions(opts),
 	}
 }
 
@@ -186,18 +236,26 @@ type ReactionHookMsg struct {
 	Bot      *Bot
 	Reaction *slackevents.ReactionAddedEvent
 	Store    *Store
+	ctx      context.Context
+}
+
+// Context returns the context the reaction was dispatched with, which carries the trace of the event from Slack.
+func (m *ReactionHookMsg) Context() context.Context {
+	return msgContext(m.ctx)
 }
 
 // registeredReactionHook is the internal struct that represents a registered plugin.
 type registeredReactionHook struct {
 	PluginId     string
 	ReactionHook ReactionHook
+	queue        *dispatchQueue[*ReactionHookMsg]
 }
 
 // registeredHook is the internal struct that implements ReactionHook
 type reactionHook struct {
 	channel chan *ReactionHookMsg
 	runFunc func(ctx context.Context, reactionHookChan <-chan *ReactionHookMsg)
+	opts    handlerOptions
 }
 
 // Channel returns the channel that the Bot writes ReactionHookMsgs to
@@ -210,11 +268,18 @@ func (r *reactionHook) Run(ctx context.Context) {
 	r.runFunc(ctx, r.channel)
 }
 
-// MakeReactionHook is a helper function that returns a ReactionHook
-func MakeReactionHook(runFunc func(ctx context.Context, reactionHookChan <-chan *ReactionHookMsg)) ReactionHook {
+// handlerOptions returns the options the reaction hook was made with.
+func (r *reactionHook) handlerOptions() *handlerOptions {
+	return &r.opts
+}
+
+// MakeReactionHook is a helper function that returns a ReactionHook.
+// The Bot can't tell when runFunc is done with a message, so it isn't timed or traced like ReactionHookFunc is.
+func MakeReactionHook(runFunc func(ctx context.Context, reactionHookChan <-chan *ReactionHookMsg), opts ...HandlerOption) ReactionHook {
 	return &reactionHook{
 		channel: make(chan *ReactionHookMsg),
 		runFunc: runFunc,
+		opts:    makeHandlerOptions(opts),
 	}
 }
 
@@ -232,12 +297,39 @@ type WebhookMsg struct {
 	ResponseWriter http.ResponseWriter
 	Store          *Store
 	Done           chan bool
+	// UserId is the Slack user the request was made for, if the webhook was made with WithWebhookUser.
+	UserId string
+	ctx    context.Context
+	// expired is closed once the Bot stops waiting on Done.
+	expired chan struct{}
+	// dropped is closed if the queue discards the message before it reaches the plugin.
+	dropped chan struct{}
+}
+
+// expiry returns a channel that is closed once the Bot stops waiting for the plugin to handle the webhook.
+// It is nil for webhooks that aren't waited on.
+func (m *WebhookMsg) expiry() <-chan struct{} {
+	return m.expired
+}
+
+// discard tells the Bot that the webhook was dropped without reaching the plugin.
+func (m *WebhookMsg) discard() {
+	if m.dropped != nil {
+		close(m.dropped)
+	}
+}
+
+// Context returns the context the request was dispatched with, which carries its trace.
+// Unlike the request's context, it isn't cancelled once the Bot stops waiting for the plugin to respond.
+func (m *WebhookMsg) Context() context.Context {
+	return msgContext(m.ctx)
 }
 
 // registeredWebhook is the internal struct that represents a registered webhook
 type registeredWebhook struct {
 	PluginId string
 	Webhook  Webhook
+	queue    *dispatchQueue[*WebhookMsg]
 }
 
 // webhook is an implementation of the Webhook interface
@@ -245,6 +337,7 @@ type webhook struct {
 	name    string
 	channel chan *WebhookMsg
 	runFunc func(ctx context.Context, webhookChan <-chan *WebhookMsg)
+	opts    handlerOptions
 }
 
 // GetName returns the name of the webhook
@@ -262,12 +355,98 @@ func (wh *webhook) Run(ctx context.Context) {
 	wh.runFunc(ctx, wh.channel)
 }
 
-// MakeWebhook is a helper function that returns a Webhook
-func MakeWebhook(name string, runFunc func(ctx context.Context, whChan <-chan *WebhookMsg)) Webhook {
+// handlerOptions returns the options the webhook was made with.
+func (wh *webhook) handlerOptions() *handlerOptions {
+	return &wh.opts
+}
+
+// MakeWebhook is a helper function that returns a Webhook.
+// The Bot can't tell when runFunc is done with a message, so it isn't timed or traced like WebhookFunc is.
+func MakeWebhook(name string, runFunc func(ctx context.Context, whChan <-chan *WebhookMsg), opts ...HandlerOption) Webhook {
 	return &webhook{
 		name:    name,
 		runFunc: runFunc,
 		channel: make(chan *WebhookMsg),
+		opts:    makeHandlerOptions(opts),
+	}
+}
+
+// HandlerOption configures how the Bot dispatches messages to a command, hook, reaction hook, interaction or webhook.
+// They are passed to the Make helpers, e.g. MakeHook(runFunc, WithQueue(cfg)).
+type HandlerOption func(opts *handlerOptions)
+
+// handlerOptions holds the options a handler was made with.
+type handlerOptions struct {
+	queue       *QueueConfig
+	timeout     time.Duration
+	description string
+	usage       string
+	examples    []string
+	spec        *cmdparse.Spec
+	roles       []string
+	rateLimit   *RateLimit
+	verifier    *SignatureVerifier
+	webhookUser func(r *http.Request) (string, error)
+}
+
+// configuredHandler is implemented by handlers that carry handlerOptions.
+type configuredHandler interface {
+	handlerOptions() *handlerOptions
+}
+
+// makeHandlerOptions applies opts to a new handlerOptions
+func makeHandlerOptions(opts []HandlerOption) handlerOptions {
+	ho := handlerOptions{}
+	for _, opt := range opts {
+		opt(&ho)
+	}
+
+	return ho
+}
+
+// getHandlerOptions returns the options for any handler. Handlers that don't carry options get the defaults.
+func getHandlerOptions(h interface{}) *handlerOptions {
+	if ch, ok := h.(configuredHandler); ok {
+		return ch.handlerOptions()
+	}
+
+	return &handlerOptions{}
+}
+
+// WithQueue overrides the Bot's queue configuration for a single handler.
+func WithQueue(cfg QueueConfig) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.queue = &cfg
+	}
+}
+
+// WithDescription sets the one line description of a command that is shown by /help.
+func WithDescription(description string) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.description = description
+	}
+}
+
+// WithUsage sets the arguments of a command that are shown by /help after its name, e.g. "<url> <phrase...>".
+func WithUsage(usage string) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.usage = usage
+	}
+}
+
+// WithExamples sets full example invocations of a command that are shown by /help, e.g. "/roll 20".
+func WithExamples(examples ...string) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.examples = examples
+	}
+}
+
+// WithSpec documents a command with the cmdparse.Spec it parses its text with.
+// /help uses the spec's description, arguments, flags, subcommands and examples unless they are set with
+// WithDescription, WithUsage or WithExamples.
+func WithSpec(spec *cmdparse.Spec) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.spec = spec
 	}
 }
 
@@ -293,6 +472,22 @@ type ReactionHookPlugin interface {
 	Plugin
 	GetReactionHooks() []ReactionHook
 }
+type ScheduledTaskPlugin interface {
+	Plugin
+	GetScheduledTasks() []ScheduledTask
+}
+type MigrationPlugin interface {
+	Plugin
+	GetMigrations() []Migration
+}
+type ProtoKeyPlugin interface {
+	Plugin
+	GetProtoKeys() []ProtoKey
+}
+type SealedKeyPlugin interface {
+	Plugin
+	GetSealedPrefixes() []string
+}
 type LoadPlugin interface {
 	Plugin
 	Load(bot *Bot, store *Store) error
@@ -313,6 +508,10 @@ type plugin struct {
 	hooks         []Hook
 	reactionHooks []ReactionHook
 	webhooks      []Webhook
+	tasks         []ScheduledTask
+	migrations    []Migration
+	protoKeys     []ProtoKey
+	sealedKeys    []string
 	loadFn        loadPluginFn
 }
 
@@ -341,20 +540,67 @@ func (p *plugin) GetReactionHooks() []ReactionHook {
 	return p.reactionHooks
 }
 
+// GetScheduledTasks returns all of the scheduled tasks registered with the plugin.
+func (p *plugin) GetScheduledTasks() []ScheduledTask {
+	return p.tasks
+}
+
+// GetMigrations returns all of the migrations registered with the plugin.
+func (p *plugin) GetMigrations() []Migration {
+	return p.migrations
+}
+
+// GetProtoKeys returns the protobuf types stored by the plugin.
+func (p *plugin) GetProtoKeys() []ProtoKey {
+	return p.protoKeys
+}
+
+// GetSealedPrefixes returns the prefixes of the keys the plugin seals.
+func (p *plugin) GetSealedPrefixes() []string {
+	return p.sealedKeys
+}
+
 // Load executes the load function specified by the plugin
 func (p *plugin) Load(bot *Bot, store *Store) error {
 	return p.loadFn(bot, store)
 }
 
+// PluginOption adds optional capabilities to a plugin made with MakePlugin.
+type PluginOption func(p *plugin)
+
+// WithScheduledTasks adds scheduled tasks to a plugin.
+func WithScheduledTasks(tasks ...ScheduledTask) PluginOption {
+	return func(p *plugin) {
+		p.tasks = append(p.tasks, tasks...)
+	}
+}
+
+// WithMigrations adds schema migrations to a plugin.
+func WithMigrations(migrations ...Migration) PluginOption {
+	return func(p *plugin) {
+		p.migrations = append(p.migrations, migrations...)
+	}
+}
+
+// WithSealedKeys declares the prefixes of the keys a plugin stores with PutSealed. Whenever the plugin is registered,
+// values under them that aren't sealed with the current key are resealed, so secrets stored before a sealing key was
+// configured, or before the key was rotated, are sealed with it. This is what encrypts a plugin's existing secrets,
+// so it doesn't need a migration of its own to do it.
+func WithSealedKeys(prefixes ...string) PluginOption {
+	return func(p *plugin) {
+		p.sealedKeys = append(p.sealedKeys, prefixes...)
+	}
+}
+
 // MakePlugin is a helper function that returns a Plugin.
-func MakePlugin(id string, commands []Command, hooks []Hook, reactionHooks []ReactionHook, webhooks []Webhook, loadFunction loadPluginFn) Plugin {
+func MakePlugin(id string, commands []Command, hooks []Hook, reactionHooks []ReactionHook, webhooks []Webhook, loadFunction loadPluginFn, opts ...PluginOption) Plugin {
 	if loadFunction == nil {
 		loadFunction = func(bot *Bot, store *Store) error {
 			return nil
 		}
 	}
 
-	return &plugin{
+	p := &plugin{
 		id:            id,
 		commands:      commands,
 		hooks:         hooks,
@@ -362,6 +608,11 @@ func MakePlugin(id string, commands []Command, hooks []Hook, reactionHooks []Rea
 		reactionHooks: reactionHooks,
 		loadFn:        loadFunction,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
 }
 
 // plugin is an internal implementation of Plugin
@@ -437,8 +688,9 @@ func (b *Bot) GetInteraction(callbackID string) *registeredInteraction {
 // getStore returns the database handle for the given pluginId
 func (b *Bot) getStore(pluginId string) *Store {
 	return &Store{
-		db:       b.db,
+		backend:  b.storage,
 		pluginId: pluginId,
+		sealer:   b.sealer,
 	}
 }
 
@@ -457,11 +709,39 @@ func (b *Bot) RegisterPlugin(p interface{}) error {
 		return errors.New("Must provide a unique plugin id.")
 	}
 
+	// Internal buckets such as the expiry bucket have a colon in their name so they can't clash with a plugin.
+	if strings.Contains(plugin.GetId(), ":") {
+		return fmt.Errorf("invalid plugin id %s: ids can't contain a colon", plugin.GetId())
+	}
+
+	if b.pluginIds[plugin.GetId()] {
+		return fmt.Errorf("Plugin already registered: %s", plugin.GetId())
+	}
+	b.pluginIds[plugin.GetId()] = true
+
 	err := b.InitPluginBucket(plugin.GetId())
 	if err != nil {
 		return err
 	}
 
+	if pp, ok := plugin.(ProtoKeyPlugin); ok {
+		b.protoKeys.register(pp.GetId(), pp.GetProtoKeys())
+	}
+
+	if mp, ok := plugin.(MigrationPlugin); ok {
+		err = b.migrate(mp.GetId(), mp.GetMigrations())
+		if err != nil {
+			return err
+		}
+	}
+
+	if sp, ok := plugin.(SealedKeyPlugin); ok {
+		err = b.resealPlugin(sp.GetId(), sp.GetSealedPrefixes())
+		if err != nil {
+			return err
+		}
+	}
+
 	if lp, ok := plugin.(LoadPlugin); ok {
 		err = lp.Load(b, b.getStore(lp.GetId()))
 		if err != nil {
@@ -475,46 +755,67 @@ func (b *Bot) RegisterPlugin(p interface{}) error {
 			if ok {
 				return fmt.Errorf("Command already exists: %s", command.GetName())
 			}
+			queue := newDispatchQueue(b.Log, b.queueConfig(command), cp.GetId(), "command", command.GetName(), command.Channel())
 			b.commands[command.GetName()] = &registeredCommand{
 				PluginId: cp.GetId(),
 				Command:  command,
+				queue:    queue,
 			}
-			b.wg.Add(1)
+			b.wg.Add(2)
 			go func(c Command) {
 				defer b.wg.Done()
 
-				c.Run(b.ctx)
+				b.supervise(cp.GetId(), "command", c.GetName(), true, c.Run)
 			}(command)
+			go func() {
+				defer b.wg.Done()
+
+				queue.run(b.ctx)
+			}()
 		}
 	}
 
 	if hp, ok := plugin.(HookPlugin); ok {
-		for _, hook := range hp.GetHooks() {
+		for i, hook := range hp.GetHooks() {
+			queue := newDispatchQueue(b.Log, b.queueConfig(hook), hp.GetId(), "hook", strconv.Itoa(i), hook.Channel())
 			b.hooks = append(b.hooks, &registeredHook{
 				PluginId: hp.GetId(),
 				Hook:     hook,
+				queue:    queue,
 			})
-			b.wg.Add(1)
-			go func(h Hook) {
+			b.wg.Add(2)
+			go func(h Hook, name string) {
 				defer b.wg.Done()
 
-				h.Run(b.ctx)
-			}(hook)
+				b.supervise(hp.GetId(), "hook", name, true, h.Run)
+			}(hook, strconv.Itoa(i))
+			go func() {
+				defer b.wg.Done()
+
+				queue.run(b.ctx)
+			}()
 		}
 	}
 
 	if rp, ok := plugin.(ReactionHookPlugin); ok {
-		for _, reactionHook := range rp.GetReactionHooks() {
+		for i, reactionHook := range rp.GetReactionHooks() {
+			queue := newDispatchQueue(b.Log, b.queueConfig(reactionHook), rp.GetId(), "reaction_hook", strconv.Itoa(i), reactionHook.Channel())
 			b.reactionHooks = append(b.reactionHooks, &registeredReactionHook{
 				PluginId:     rp.GetId(),
 				ReactionHook: reactionHook,
+				queue:        queue,
 			})
-			b.wg.Add(1)
-			go func(r ReactionHook) {
+			b.wg.Add(2)
+			go func(r ReactionHook, name string) {
+				defer b.wg.Done()
+
+				b.supervise(rp.GetId(), "reaction_hook", name, true, r.Run)
+			}(reactionHook, strconv.Itoa(i))
+			go func() {
 				defer b.wg.Done()
 
-				r.Run(b.ctx)
-			}(reactionHook)
+				queue.run(b.ctx)
+			}()
 		}
 	}
 
@@ -524,16 +825,26 @@ func (b *Bot) RegisterPlugin(p interface{}) error {
 			if ok {
 				return fmt.Errorf("Webhook already exists: %s", wHook.GetName())
 			}
+			if opts := getHandlerOptions(wHook); len(opts.roles) > 0 && (opts.verifier == nil || opts.webhookUser == nil) {
+				return fmt.Errorf("Webhook %s requires roles, so it must be signed and use WithWebhookUser", wHook.GetName())
+			}
+			queue := newDispatchQueue(b.Log, b.queueConfig(wHook), wp.GetId(), "webhook", wHook.GetName(), wHook.Channel())
 			b.webhooks[wHook.GetName()] = &registeredWebhook{
 				PluginId: wp.GetId(),
 				Webhook:  wHook,
+				queue:    queue,
 			}
-			b.wg.Add(1)
+			b.wg.Add(2)
 			go func(wh Webhook) {
 				defer b.wg.Done()
 
-				wh.Run(b.ctx)
+				b.supervise(wp.GetId(), "webhook", wh.GetName(), true, wh.Run)
 			}(wHook)
+			go func() {
+				defer b.wg.Done()
+
+				queue.run(b.ctx)
+			}()
 		}
 	}
 
@@ -543,21 +854,46 @@ func (b *Bot) RegisterPlugin(p interface{}) error {
 			if ok {
 				return fmt.Errorf("Interaction plugin already exists:  %s", ic.GetName())
 			}
+			queue := newDispatchQueue(b.Log, b.queueConfig(ic), ip.GetId(), "interaction", ic.GetName(), ic.Channel())
 			b.interactions[ic.GetName()] = &registeredInteraction{
 				PluginId:    ip.GetId(),
 				Interaction: ic,
+				queue:       queue,
 			}
-			b.wg.Add(1)
+			b.wg.Add(2)
 			go func(s Interaction) {
 				defer b.wg.Done()
-				s.Run(b.ctx)
+				b.supervise(ip.GetId(), "interaction", s.GetName(), true, s.Run)
 			}(ic)
+			go func() {
+				defer b.wg.Done()
+
+				queue.run(b.ctx)
+			}()
+		}
+	}
+
+	if sp, ok := plugin.(ScheduledTaskPlugin); ok {
+		for _, task := range sp.GetScheduledTasks() {
+			err := b.registerTask(sp.GetId(), task)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// queueConfig returns the queue configuration for a handler, preferring the handler's own over the Bot's.
+func (b *Bot) queueConfig(h interface{}) QueueConfig {
+	if cfg := getHandlerOptions(h).queue; cfg != nil {
+		return *cfg
+	}
+
+	return b.config.Queue
+}
+
 // dispatchCommand parses an incoming slash command and sends it to the plugin it is registered to
 func (b *Bot) dispatchCommand(slashCmd *slashCommand) {
 	if slashCmd.Command == "" {
@@ -569,16 +905,63 @@ func (b *Bot) dispatchCommand(slashCmd *slashCommand) {
 	if cmd == nil {
 		return
 	}
+	b.metrics.command(cmd.PluginId, cmdName)
 
-	cmd.Command.Channel() <- &CommandMsg{
+	msg := &CommandMsg{
 		Bot:     b,
 		Command: slashCmd,
 		Store:   b.getStore(cmd.PluginId),
+		ctx:     slashCmd.ctx,
 	}
+
+	opts := getHandlerOptions(cmd.Command)
+	if len(opts.roles) == 0 {
+		b.pushCommand(cmd, msg, opts)
+		return
+	}
+
+	// Checking roles may need the Slack API, so don't hold up the event loop.
+	go func() {
+		if !b.authorize(slashCmd.UserId, opts.roles) {
+			err := b.respondToCommand(slashCmd, &CommandResp{Text: denialText(opts.roles, slashCmd.Command)})
+			if err != nil {
+				b.Log.Error("error responding to denied command", zap.String("command", cmdName), zap.Error(err))
+			}
+			return
+		}
+
+		b.pushCommand(cmd, msg, opts)
+	}()
 }
 
-// dispatchWebhook parses an incoming webhook and sends it to the plugin it is registered to
-func (b *Bot) dispatchInteraction(cb *slack.InteractionCallback) {
+// pushCommand queues a command for its plugin, unless the user is over the command's rate limit.
+func (b *Bot) pushCommand(cmd *registeredCommand, msg *CommandMsg, opts *handlerOptions) {
+	ok, wait := b.limiter.allow(opts.rateLimit, "command:"+cmd.Command.GetName(), msg.Command.UserId, msg.Command.ChannelId)
+	if !ok {
+		// Responding waits on the command, so it mustn't hold up the event loop.
+		go func() {
+			err := b.respondToCommand(msg.Command, &CommandResp{Text: slowDownText(msg.Command.Command, wait)})
+			if err != nil {
+				b.Log.Error("error responding to rate limited command", zap.String("command", cmd.Command.GetName()), zap.Error(err))
+			}
+		}()
+		return
+	}
+
+	cmd.queue.push(msg)
+}
+
+// interactionRequest is an interaction waiting to be dispatched, along with the context of the request it came in on.
+type interactionRequest struct {
+	ctx context.Context
+	cb  *slack.InteractionCallback
+}
+
+// dispatchInteraction sends an interaction to the plugin that registered its callback id. ctx carries the trace of
+// the request it came in on.
+func (b *Bot) dispatchInteraction(ctx context.Context, cb *slack.InteractionCallback) {
+	b.metrics.interaction(string(cb.Type))
+
 	callbackID := ""
 	switch cb.Type {
 	case "view_submission":
@@ -592,11 +975,27 @@ func (b *Bot) dispatchInteraction(cb *slack.InteractionCallback) {
 		return
 	}
 
-	ic.Interaction.Channel() <- &InteractionMsg{
+	msg := &InteractionMsg{
 		Bot:         b,
 		Interaction: cb,
 		Store:       b.getStore(ic.PluginId),
+		ctx:         ctx,
+	}
+
+	roles := getHandlerOptions(ic.Interaction).roles
+	if len(roles) == 0 {
+		ic.queue.push(msg)
+		return
 	}
+
+	go func() {
+		if !b.authorize(cb.User.ID, roles) {
+			b.denyInteraction(msg.Context(), cb, roles, ic.Interaction.GetName())
+			return
+		}
+
+		ic.queue.push(msg)
+	}()
 }
 
 // dispatchWebhook parses an incoming webhook and sends it to the plugin it is registered to
@@ -606,33 +1005,42 @@ func (b *Bot) dispatchWebhook(webhook *PluginWebhook) {
 		return
 	}
 
-	wh.Webhook.Channel() <- &WebhookMsg{
+	wh.queue.push(&WebhookMsg{
 		Bot:            b,
 		Request:        webhook.Request,
 		ResponseWriter: webhook.ResponseWriter,
 		Store:          b.getStore(wh.PluginId),
-	}
+		ctx:            traceContext(webhook.Request.Context()),
+	})
 }
 
 // dispatchReactions sends a reaction to all registered reaction hooks
-func (b *Bot) dispatchReactions(ev *slackevents.ReactionAddedEvent) {
+func (b *Bot) dispatchReactions(ctx context.Context, ev *slackevents.ReactionAddedEvent) {
 	for _, reactionHook := range b.reactionHooks {
-		reactionHook.ReactionHook.Channel() <- &ReactionHookMsg{
+		reactionHook.queue.push(&ReactionHookMsg{
 			Bot:      b,
 			Reaction: ev,
 			Store:    b.getStore(reactionHook.PluginId),
-		}
+			ctx:      ctx,
+		})
 	}
 }
 
 // dispatchHooks sends a slack message to all registered hooks
-func (b *Bot) dispatchHooks(msg *slack.Msg) {
+func (b *Bot) dispatchHooks(ctx context.Context, msg *slack.Msg) {
 	for _, hook := range b.hooks {
-		hook.Hook.Channel() <- &HookMsg{
+		limit := getHandlerOptions(hook.Hook).rateLimit
+		if ok, _ := b.limiter.allow(limit, fmt.Sprintf("hook:%s:%s", hook.PluginId, hook.queue.name), msg.User, msg.Channel); !ok {
+			b.Log.Debug("hook rate limited", zap.String("plugin", hook.PluginId), zap.String("user", msg.User), zap.String("channel", msg.Channel))
+			continue
+		}
+
+		hook.queue.push(&HookMsg{
 			Bot:   b,
 			Msg:   msg,
 			Store: b.getStore(hook.PluginId),
-		}
+			ctx:   ctx,
+		})
 	}
 }
 
@@ -649,6 +1057,11 @@ func prepareSlashCommandResp(cmd *CommandResp) {
 
 // RespondToSlashCommand sends a command response to the slack API in order to respond to a slash command.
 func (b *Bot) RespondToSlashCommand(url string, cmdResp *CommandResp) error {
+	return b.RespondToSlashCommandContext(context.Background(), url, cmdResp)
+}
+
+// RespondToSlashCommandContext is RespondToSlashCommand with a context, which carries the trace of the command being handled.
+func (b *Bot) RespondToSlashCommandContext(ctx context.Context, url string, cmdResp *CommandResp) error {
 	prepareSlashCommandResp(cmdResp)
 
 	jsonBytes, err := json.Marshal(cmdResp)
@@ -656,19 +1069,18 @@ func (b *Bot) RespondToSlashCommand(url string, cmdResp *CommandResp) error {
 		b.Log.Error("error marshalling json.", zap.Error(err))
 		return err
 	}
-	data := bytes.NewBuffer(jsonBytes)
-	err = json.NewEncoder(data).Encode(cmdResp)
-	if err != nil {
-		return err
-	}
-	resp, err := http.Post(url, "application/json", data)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonBytes))
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.httpClient.Do(req)
 	if err != nil {
 		b.Log.Error("error responding to slash command.", zap.Error(err))
 		return err
 	}
+	defer resp.Body.Close()
+
 	return nil
 }
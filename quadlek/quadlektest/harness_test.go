@@ -0,0 +1,90 @@
+package quadlektest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jirwin/quadlek/plugins/echo"
+	"github.com/jirwin/quadlek/quadlek"
+	"github.com/jirwin/quadlek/quadlek/quadlektest"
+)
+
+func TestHarnessEcho(t *testing.T) {
+	h := quadlektest.New(t, quadlektest.WithPlugins(echo.Register()))
+	h.Slack.AddUser("U1", "jirwin")
+
+	resp := h.Command("U1", "CGENERAL", "echo", "hello")
+	require.Equal(t, "hello", resp.Text)
+	require.False(t, resp.InChannel)
+
+	ts := h.Message("U1", "CGENERAL", "hi there")
+	messages := h.WaitForMessages(1)
+	require.Equal(t, "CGENERAL", messages[0].Channel)
+	require.Equal(t, "<@U1>: echo: hi there", messages[0].Text)
+
+	h.Reaction("U1", "CGENERAL", ts, ":tada:")
+	messages = h.WaitForMessages(2)
+	require.Equal(t, "<@U1> added a reaction! :tada:", messages[1].Text)
+
+	// Mentioning the bot runs the command, and the reply is only shown to the user.
+	h.Mention("U1", "CGENERAL", "echo mentioned")
+	h.WaitFor(func() bool {
+		for _, msg := range h.Slack.Messages() {
+			if msg.Ephemeral && msg.Text == "mentioned" {
+				require.Equal(t, "U1", msg.User)
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestHarnessInteractionsAndWebhooks(t *testing.T) {
+	p := quadlek.MakePlugin(
+		"test",
+		[]quadlek.Command{
+			quadlek.CommandFunc("later", func(ctx context.Context, cmdMsg *quadlek.CommandMsg) (*quadlek.CommandResp, error) {
+				err := cmdMsg.Bot.RespondToSlashCommand(cmdMsg.Command.ResponseUrl, &quadlek.CommandResp{Text: "done", InChannel: true})
+				return nil, err
+			}),
+		},
+		nil,
+		nil,
+		[]quadlek.Webhook{
+			quadlek.WebhookFunc("ping", func(ctx context.Context, whMsg *quadlek.WebhookMsg) error {
+				_, err := whMsg.ResponseWriter.Write([]byte("pong"))
+				return err
+			}),
+		},
+		nil,
+	)
+	ip := quadlek.MakeInteractionPlugin("test-interactions", []quadlek.Interaction{
+		quadlek.InteractionFunc("greet", func(ctx context.Context, msg *quadlek.InteractionMsg) error {
+			_, err := msg.Bot.OpenView(msg.Interaction.TriggerID, slack.ModalViewRequest{
+				Type:       slack.VTModal,
+				CallbackID: "modal",
+				Title:      slack.NewTextBlockObject(slack.PlainTextType, "Hello", false, false),
+			})
+			return err
+		}),
+	})
+	h := quadlektest.New(t, quadlektest.WithPlugins(p, ip))
+
+	require.Nil(t, h.Command("U1", "CGENERAL", "/later", ""))
+	messages := h.WaitForMessages(1)
+	require.True(t, messages[0].ResponseUrl)
+	require.False(t, messages[0].Ephemeral)
+	require.Equal(t, "done", messages[0].Text)
+
+	h.Interaction(slack.InteractionCallback{Type: slack.InteractionTypeShortcut, CallbackID: "greet-shortcut", TriggerID: "trigger"})
+	h.WaitFor(func() bool { return len(h.Slack.Views()) == 1 })
+	require.Equal(t, "modal", h.Slack.Views()[0].CallbackID)
+
+	w := h.Webhook(httptest.NewRequest(http.MethodGet, "/slack/plugin/ping", nil))
+	require.Equal(t, "pong", w.Body.String())
+}
@@ -0,0 +1,201 @@
+package quadlektest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jirwin/quadlek/quadlek"
+	"github.com/jirwin/quadlek/quadlek/internal/fakeslack"
+)
+
+// Settle is how long the Bot must go without calling Slack before a replayed request is considered handled.
+var Settle = 100 * time.Millisecond
+
+// ReadRecordings reads a recording written by a Bot configured with quadlek.WithRecording, and fails the test if
+// it can't be read.
+func ReadRecordings(t testing.TB, path string) []quadlek.Recording {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("quadlektest: error opening recording: %v", err)
+	}
+	defer f.Close()
+
+	recordings, err := quadlek.ReadRecordings(f)
+	if err != nil {
+		t.Fatalf("quadlektest: error reading recording %s: %v", path, err)
+	}
+
+	return recordings
+}
+
+// Replay sends recorded requests from Slack to the Bot in order, and returns the calls the Bot made to Slack as a
+// result. Each request is sent once the Bot has settled after the previous one, so the calls are in a stable order
+// that can be compared against an earlier run with WriteCalls.
+//
+// Users and channels the recording refers to are added to the fake Slack, and the response urls and trigger ids
+// removed when the requests were recorded are replaced with ones served by the fake.
+func (h *Harness) Replay(recordings []quadlek.Recording) []Call {
+	h.t.Helper()
+
+	start := len(h.Slack.Calls())
+	for i, rec := range recordings {
+		switch rec.Kind {
+		case quadlek.RecordedEvent:
+			h.replayEvent(rec.Payload)
+		case quadlek.RecordedCommand:
+			h.replayCommand(rec.Payload)
+		case quadlek.RecordedInteraction:
+			h.replayInteraction(rec.Payload)
+		default:
+			h.t.Fatalf("quadlektest: unknown kind %q of recording %d", rec.Kind, i)
+		}
+		h.settle()
+	}
+
+	return h.Slack.Calls()[start:]
+}
+
+// ReplayFile replays the recording at path against a new Harness made with opts, and writes the calls the Bot made
+// to path + ".calls" with WriteCalls. The calls are also returned, so the test can check them.
+func ReplayFile(t testing.TB, path string, opts ...Option) []Call {
+	t.Helper()
+
+	h := New(t, opts...)
+	calls := h.Replay(ReadRecordings(t, path))
+
+	out, err := os.Create(path + ".calls")
+	if err != nil {
+		t.Fatalf("quadlektest: error creating calls file: %v", err)
+	}
+	defer out.Close()
+
+	err = WriteCalls(out, calls)
+	if err != nil {
+		t.Fatalf("quadlektest: error writing calls to %s: %v", out.Name(), err)
+	}
+
+	return calls
+}
+
+// WriteCalls writes calls as JSONL, one call per line.
+func WriteCalls(w io.Writer, calls []Call) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, call := range calls {
+		err := enc.Encode(call)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// settle waits until the Bot hasn't called Slack for Settle, or Timeout passes.
+func (h *Harness) settle() {
+	deadline := time.Now().Add(Timeout)
+	calls := len(h.Slack.Calls())
+	for time.Now().Before(deadline) {
+		time.Sleep(Settle)
+
+		n := len(h.Slack.Calls())
+		if n == calls {
+			return
+		}
+		calls = n
+	}
+}
+
+func (h *Harness) replayEvent(payload json.RawMessage) {
+	ev := struct {
+		Event struct {
+			User    string `json:"user"`
+			Channel string `json:"channel"`
+			Item    struct {
+				Channel string `json:"channel"`
+			} `json:"item"`
+		} `json:"event"`
+	}{}
+	h.decode(payload, &ev)
+	h.Slack.ensureUser(ev.Event.User, "")
+	h.Slack.ensureChannel(ev.Event.Channel, "")
+	h.Slack.ensureChannel(ev.Event.Item.Channel, "")
+
+	fields := map[string]interface{}{}
+	h.decode(payload, &fields)
+	if _, ok := fields["event_id"]; !ok {
+		fields["event_id"] = fmt.Sprintf("Ev%d", atomic.AddInt64(&h.events, 1))
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		h.t.Fatalf("quadlektest: error encoding event: %v", err)
+	}
+
+	h.send("/slack/event", "application/json", string(body))
+}
+
+func (h *Harness) replayCommand(payload json.RawMessage) {
+	// Commands recorded over socket mode aren't all strings.
+	values := fakeslack.JSONParams(payload)
+	if values == nil {
+		h.t.Fatalf("quadlektest: invalid recording payload: %s", payload)
+	}
+	h.Slack.ensureUser(values["user_id"], values["user_name"])
+	h.Slack.ensureChannel(values["channel_id"], values["channel_name"])
+	values["response_url"] = h.Slack.responseUrl(values["channel_id"], values["user_id"])
+
+	form := url.Values{}
+	for k, v := range values {
+		form.Set(k, v)
+	}
+	w := h.send("/slack/command", "application/x-www-form-urlencoded", form.Encode())
+	if w.Body.Len() == 0 {
+		return
+	}
+
+	h.Slack.addCall(Call{Method: "command_response", Params: fakeslack.JSONParams(w.Body.Bytes())})
+}
+
+func (h *Harness) replayInteraction(payload json.RawMessage) {
+	ids := struct {
+		User struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"user"`
+		Channel struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"channel"`
+	}{}
+	h.decode(payload, &ids)
+	h.Slack.ensureUser(ids.User.Id, ids.User.Name)
+	h.Slack.ensureChannel(ids.Channel.Id, ids.Channel.Name)
+
+	fields := map[string]interface{}{}
+	h.decode(payload, &fields)
+	fields["trigger_id"] = "replay"
+	if ids.Channel.Id != "" && ids.User.Id != "" {
+		fields["response_url"] = h.Slack.responseUrl(ids.Channel.Id, ids.User.Id)
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		h.t.Fatalf("quadlektest: error encoding interaction: %v", err)
+	}
+
+	h.send("/slack/interaction", "application/x-www-form-urlencoded", url.Values{"payload": {string(body)}}.Encode())
+}
+
+func (h *Harness) decode(payload json.RawMessage, v interface{}) {
+	err := json.Unmarshal(payload, v)
+	if err != nil {
+		h.t.Fatalf("quadlektest: invalid recording payload: %v", err)
+	}
+}
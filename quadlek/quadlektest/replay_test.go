@@ -0,0 +1,52 @@
+package quadlektest_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jirwin/quadlek/plugins/echo"
+	"github.com/jirwin/quadlek/quadlek"
+	"github.com/jirwin/quadlek/quadlek/quadlektest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+
+	h := quadlektest.New(t, quadlektest.WithPlugins(echo.Register()), quadlektest.WithBotOptions(quadlek.WithRecording(path)))
+	h.Slack.AddUser("U1", "jirwin")
+	h.Command("U1", "CGENERAL", "/echo", "hello")
+	ts := h.Message("U1", "CGENERAL", "hi there")
+	h.Reaction("U1", "CGENERAL", ts, "tada")
+
+	recordings := quadlektest.ReadRecordings(t, path)
+	require.Len(t, recordings, 3)
+	require.Equal(t, quadlek.RecordedCommand, recordings[0].Kind)
+	require.NotContains(t, string(recordings[0].Payload), "response_url")
+
+	// Replaying against a fresh bot, where U1 isn't known yet, makes the same calls.
+	replay := quadlektest.New(t, quadlektest.WithPlugins(echo.Register()))
+	calls := replay.Replay(recordings)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, quadlektest.WriteCalls(out, calls))
+	require.Contains(t, out.String(), `{"method":"command_response","params":{"response_type":"ephemeral","text":"hello"}}`)
+
+	var posted []string
+	for _, call := range calls {
+		if call.Method == "chat.postMessage" {
+			require.Equal(t, "CGENERAL", call.Params["channel"])
+			posted = append(posted, call.Params["text"])
+		}
+	}
+	require.Equal(t, []string{"<@U1>: echo: hi there", "<@U1> added a reaction! :tada:"}, posted)
+
+	// ReplayFile writes the same calls next to the recording.
+	require.Equal(t, calls, quadlektest.ReplayFile(t, path, quadlektest.WithPlugins(echo.Register())))
+	written, err := os.ReadFile(path + ".calls")
+	require.NoError(t, err)
+	require.Equal(t, out.String(), string(written))
+}
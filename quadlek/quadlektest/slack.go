@@ -0,0 +1,206 @@
+package quadlektest
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+
+	"github.com/jirwin/quadlek/quadlek/internal/fakeslack"
+)
+
+const (
+	// BotUserId is the user id of the Bot in the fake workspace.
+	BotUserId = fakeslack.BotUserId
+	// BotId is the bot id of the Bot in the fake workspace.
+	BotId = fakeslack.BotId
+	// TeamId is the id of the fake workspace.
+	TeamId = "TQUADLEK"
+)
+
+// Message is a message the Bot sent to Slack.
+type Message struct {
+	Channel         string
+	Text            string
+	Timestamp       string
+	ThreadTimestamp string
+	Attachments     []slack.Attachment
+	Blocks          slack.Blocks
+	// User is the user an ephemeral message was shown to, or the user that ran the command for a response url message.
+	User      string
+	Ephemeral bool
+	// ResponseUrl is true if the message was the response to a slash command, sent to the command's response url.
+	ResponseUrl bool
+}
+
+// Reaction is a reaction the Bot added to a message.
+type Reaction struct {
+	Channel   string
+	Timestamp string
+	Name      string
+}
+
+// Call is a request the Bot made to Slack. Replayed recordings are compared by the calls they result in.
+type Call struct {
+	// Method is the Slack API method, response_url for replies sent to a slash command's response url,
+	// or command_response for replies returned inline.
+	Method string            `json:"method"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Slack is a fake of the parts of the Slack Web API the Bot and plugins use. It records everything the Bot sends,
+// and serves the users, channels and messages it has been given.
+//
+// Methods that aren't faked fail with an unknown_method error, and are logged to the test.
+type Slack struct {
+	// Server is the fake's server. Its url is the Slack API url the Bot is configured with.
+	Server *httptest.Server
+
+	workspace *fakeslack.Workspace
+	mtx       sync.Mutex
+	messages  []Message
+	reactions []Reaction
+	views     []slack.ModalViewRequest
+	calls     []Call
+}
+
+// NewSlack starts a fake Slack API with the Bot's user, and a #general channel.
+// The server is closed when the test finishes.
+func NewSlack(t testing.TB) *Slack {
+	s := &Slack{}
+	s.workspace = fakeslack.New(TeamId, fakeslack.Observer{
+		Call: func(call fakeslack.Call) {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			s.calls = append(s.calls, Call(call))
+		},
+		Message: func(msg fakeslack.Message) {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			s.messages = append(s.messages, Message(msg))
+		},
+		Reaction: func(reaction fakeslack.Reaction) {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			s.reactions = append(s.reactions, Reaction(reaction))
+		},
+		View: func(view slack.ModalViewRequest) {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			s.views = append(s.views, view)
+		},
+		Unknown: func(method string) {
+			t.Logf("quadlektest: the fake slack api doesn't support %s", method)
+		},
+	})
+	s.AddChannel("CGENERAL", "general")
+
+	s.Server = httptest.NewServer(s.workspace)
+	t.Cleanup(s.Server.Close)
+
+	return s
+}
+
+// ApiUrl returns the url to configure the Bot's Slack client with.
+func (s *Slack) ApiUrl() string {
+	return s.Server.URL + "/api/"
+}
+
+// AddUser adds a user to the workspace.
+func (s *Slack) AddUser(id, name string) {
+	s.workspace.AddUser(id, name)
+}
+
+// AddChannel adds a public channel to the workspace.
+func (s *Slack) AddChannel(id, name string) {
+	s.workspace.AddChannel(id, name)
+}
+
+// Messages returns every message the Bot has sent, in order.
+func (s *Slack) Messages() []Message {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]Message{}, s.messages...)
+}
+
+// Reactions returns every reaction the Bot has added, in order.
+func (s *Slack) Reactions() []Reaction {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]Reaction{}, s.reactions...)
+}
+
+// Calls returns every call the Bot has made to Slack, in order.
+func (s *Slack) Calls() []Call {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]Call{}, s.calls...)
+}
+
+// Views returns every modal the Bot has opened, in order.
+func (s *Slack) Views() []slack.ModalViewRequest {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]slack.ModalViewRequest{}, s.views...)
+}
+
+// addCall records a call that didn't go through the fake, such as a reply returned inline to a slash command.
+func (s *Slack) addCall(call Call) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.calls = append(s.calls, call)
+}
+
+// userMessage adds a message from userId to a channel's history, and returns its timestamp.
+func (s *Slack) userMessage(channelId, userId, text string) string {
+	return s.workspace.AddMessage(channelId, userId, text)
+}
+
+// names returns the names of a channel and a user.
+func (s *Slack) names(channelId, userId string) (string, string) {
+	channel, _ := s.workspace.Channel(channelId)
+	user, _ := s.workspace.User(userId)
+
+	return channel.Name, user.Name
+}
+
+// responseUrl returns the response url for a slash command run by userId in channelId.
+func (s *Slack) responseUrl(channelId, userId string) string {
+	return fmt.Sprintf("%s/response/%s/%s", s.Server.URL, channelId, userId)
+}
+
+// ensureUser adds a user to the workspace if it doesn't exist yet. The name defaults to the lowercased id.
+func (s *Slack) ensureUser(id, name string) {
+	if id == "" {
+		return
+	}
+	if name == "" {
+		name = strings.ToLower(id)
+	}
+
+	if _, ok := s.workspace.User(id); !ok {
+		s.workspace.AddUser(id, name)
+	}
+}
+
+// ensureChannel adds a channel to the workspace if it doesn't exist yet. The name defaults to the lowercased id.
+func (s *Slack) ensureChannel(id, name string) {
+	if id == "" {
+		return
+	}
+	if name == "" {
+		name = strings.ToLower(id)
+	}
+
+	if _, ok := s.workspace.Channel(id); !ok {
+		s.workspace.AddChannel(id, name)
+	}
+}
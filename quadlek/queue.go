@@ -0,0 +1,258 @@
+package quadlek
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// OverflowPolicy decides what happens to a message that is dispatched to a handler whose queue is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the message being dispatched.
+	DropNewest
+	// Block waits up to QueueConfig.BlockTimeout for room in the queue, and then discards the message being dispatched.
+	Block
+)
+
+// String returns the name used for the policy in config files.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Block:
+		return "block"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (p OverflowPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler so that policies can be set by name in config files.
+func (p *OverflowPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "drop-oldest":
+		*p = DropOldest
+	case "drop-newest":
+		*p = DropNewest
+	case "block":
+		*p = Block
+	default:
+		return fmt.Errorf("unknown overflow policy: %s", text)
+	}
+
+	return nil
+}
+
+// QueueConfig configures the queue that sits in front of each registered command, hook, reaction hook,
+// interaction and webhook. Messages are queued so that a slow plugin doesn't hold up dispatching to the others.
+type QueueConfig struct {
+	// Size is the number of messages that can be waiting for the handler.
+	Size int `yaml:"size" toml:"size"`
+	// Policy decides what happens when a message is dispatched to a full queue.
+	Policy OverflowPolicy `yaml:"policy" toml:"policy"`
+	// BlockTimeout is how long the Block policy waits for room in the queue. It defaults to a second.
+	BlockTimeout time.Duration `yaml:"block_timeout" toml:"block_timeout"`
+}
+
+// defaultQueueConfig is used for handlers unless it is overridden with WithQueueConfig or WithQueue.
+var defaultQueueConfig = QueueConfig{
+	Size:         100,
+	Policy:       DropOldest,
+	BlockTimeout: time.Second,
+}
+
+// QueueStats describes the current state of a handler's queue.
+type QueueStats struct {
+	PluginId string
+	// Kind is the kind of handler: command, hook, reaction_hook, interaction or webhook.
+	Kind string
+	// Name is the name of the handler. Hooks and reaction hooks are numbered in the order they were registered.
+	Name    string
+	Len     int
+	Cap     int
+	Dropped uint64
+}
+
+// waitingMsg is implemented by messages whose sender waits for them to be handled, such as plugin webhooks.
+type waitingMsg interface {
+	// expiry returns a channel that is closed once the sender has stopped waiting,
+	// after which the message is no longer worth delivering.
+	expiry() <-chan struct{}
+	// discard tells the sender that the message was dropped without being delivered.
+	discard()
+}
+
+// dispatchQueue is a bounded queue of messages waiting to be delivered to a handler's channel.
+// Messages are pushed without blocking the dispatcher (other than under the Block policy), and
+// run delivers them to the handler one at a time.
+type dispatchQueue[T any] struct {
+	pluginId string
+	kind     string
+	name     string
+	cfg      QueueConfig
+	buf      chan T
+	out      chan<- T
+	dropped  uint64
+	log      *zap.Logger
+}
+
+// newDispatchQueue creates a queue that delivers messages to out.
+func newDispatchQueue[T any](log *zap.Logger, cfg QueueConfig, pluginId, kind, name string, out chan<- T) *dispatchQueue[T] {
+	if cfg.Size <= 0 {
+		cfg.Size = defaultQueueConfig.Size
+	}
+	if cfg.BlockTimeout <= 0 {
+		cfg.BlockTimeout = defaultQueueConfig.BlockTimeout
+	}
+
+	return &dispatchQueue[T]{
+		pluginId: pluginId,
+		kind:     kind,
+		name:     name,
+		cfg:      cfg,
+		buf:      make(chan T, cfg.Size),
+		out:      out,
+		log:      log,
+	}
+}
+
+// push queues msg for delivery, applying the overflow policy if the queue is full.
+// It returns false if msg was dropped.
+func (q *dispatchQueue[T]) push(msg T) bool {
+	select {
+	case q.buf <- msg:
+		return true
+	default:
+	}
+
+	switch q.cfg.Policy {
+	case DropOldest:
+		for {
+			// Make room, unless the handler beat us to it.
+			select {
+			case old := <-q.buf:
+				q.drop("oldest", old)
+			default:
+			}
+
+			select {
+			case q.buf <- msg:
+				return true
+			default:
+			}
+		}
+
+	case Block:
+		timer := time.NewTimer(q.cfg.BlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case q.buf <- msg:
+			return true
+		case <-timer.C:
+		}
+	}
+
+	q.drop("newest", msg)
+	return false
+}
+
+// drop records and logs that msg was discarded because the queue was full.
+func (q *dispatchQueue[T]) drop(which string, msg T) {
+	if wm, ok := any(msg).(waitingMsg); ok {
+		wm.discard()
+	}
+
+	dropped := atomic.AddUint64(&q.dropped, 1)
+	q.log.Warn("handler queue is full, dropped a message",
+		zap.String("plugin", q.pluginId),
+		zap.String("kind", q.kind),
+		zap.String("name", q.name),
+		zap.String("policy", q.cfg.Policy.String()),
+		zap.String("dropped_message", which),
+		zap.Uint64("dropped_total", dropped),
+	)
+}
+
+// expire records and logs that a message was discarded because its sender stopped waiting for it.
+func (q *dispatchQueue[T]) expire() {
+	dropped := atomic.AddUint64(&q.dropped, 1)
+	q.log.Warn("sender stopped waiting, dropped a message",
+		zap.String("plugin", q.pluginId),
+		zap.String("kind", q.kind),
+		zap.String("name", q.name),
+		zap.Uint64("dropped_total", dropped),
+	)
+}
+
+// stats returns the current state of the queue.
+func (q *dispatchQueue[T]) stats() QueueStats {
+	return QueueStats{
+		PluginId: q.pluginId,
+		Kind:     q.kind,
+		Name:     q.name,
+		Len:      len(q.buf),
+		Cap:      cap(q.buf),
+		Dropped:  atomic.LoadUint64(&q.dropped),
+	}
+}
+
+// run delivers queued messages to the handler until ctx is cancelled.
+func (q *dispatchQueue[T]) run(ctx context.Context) {
+	for {
+		select {
+		case msg := <-q.buf:
+			var expired <-chan struct{}
+			if wm, ok := any(msg).(waitingMsg); ok {
+				expired = wm.expiry()
+			}
+
+			select {
+			case q.out <- msg:
+			case <-expired:
+				q.expire()
+			case <-ctx.Done():
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// QueueStats returns the state of the queue for every registered handler.
+func (b *Bot) QueueStats() []QueueStats {
+	var stats []QueueStats
+
+	for _, cmd := range b.commands {
+		stats = append(stats, cmd.queue.stats())
+	}
+	for _, hook := range b.hooks {
+		stats = append(stats, hook.queue.stats())
+	}
+	for _, reactionHook := range b.reactionHooks {
+		stats = append(stats, reactionHook.queue.stats())
+	}
+	for _, ic := range b.interactions {
+		stats = append(stats, ic.queue.stats())
+	}
+	for _, wh := range b.webhooks {
+		stats = append(stats, wh.queue.stats())
+	}
+
+	return stats
+}
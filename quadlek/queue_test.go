@@ -0,0 +1,62 @@
+package quadlek
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func drain(q *dispatchQueue[int]) []int {
+	var out []int
+	for {
+		select {
+		case v := <-q.buf:
+			out = append(out, v)
+		default:
+			return out
+		}
+	}
+}
+
+func TestDispatchQueue_DropOldest(t *testing.T) {
+	q := newDispatchQueue[int](zap.NewNop(), QueueConfig{Size: 2, Policy: DropOldest}, "test", "hook", "0", nil)
+
+	require.True(t, q.push(1))
+	require.True(t, q.push(2))
+	require.True(t, q.push(3))
+	require.Equal(t, uint64(1), q.stats().Dropped)
+	require.Equal(t, []int{2, 3}, drain(q))
+}
+
+func TestDispatchQueue_DropNewest(t *testing.T) {
+	q := newDispatchQueue[int](zap.NewNop(), QueueConfig{Size: 2, Policy: DropNewest}, "test", "hook", "0", nil)
+
+	require.True(t, q.push(1))
+	require.True(t, q.push(2))
+	require.False(t, q.push(3))
+	require.Equal(t, uint64(1), q.stats().Dropped)
+	require.Equal(t, []int{1, 2}, drain(q))
+}
+
+func TestDispatchQueue_Block(t *testing.T) {
+	q := newDispatchQueue[int](zap.NewNop(), QueueConfig{Size: 1, Policy: Block, BlockTimeout: 10 * time.Millisecond}, "test", "hook", "0", nil)
+
+	require.True(t, q.push(1))
+	require.False(t, q.push(2))
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		<-q.buf
+	}()
+	q.cfg.BlockTimeout = time.Second
+	require.True(t, q.push(3))
+	require.Equal(t, []int{3}, drain(q))
+	require.Equal(t, uint64(1), q.stats().Dropped)
+}
+
+func TestDispatchQueue_BlockDefaultTimeout(t *testing.T) {
+	q := newDispatchQueue[int](zap.NewNop(), QueueConfig{Policy: Block}, "test", "hook", "0", nil)
+	require.Equal(t, defaultQueueConfig.BlockTimeout, q.cfg.BlockTimeout)
+}
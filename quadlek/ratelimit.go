@@ -0,0 +1,268 @@
+package quadlek
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// rateLimitPrefix is the prefix of the keys that persisted token buckets are stored under in the core plugin's bucket.
+const rateLimitPrefix = "ratelimit:"
+
+const (
+	// rateLimitFlushInterval is how often changes to persisted buckets are written to the store.
+	rateLimitFlushInterval = time.Second
+	// rateLimitSweepInterval is how often buckets that have refilled are dropped from memory.
+	rateLimitSweepInterval = time.Minute
+)
+
+// Rate is a token bucket: up to Burst uses at once, refilled by one use every Every.
+// A Rate with a zero Burst or Every is unlimited.
+type Rate struct {
+	Burst int
+	Every time.Duration
+}
+
+// unlimited returns true if the rate doesn't limit anything.
+func (r Rate) unlimited() bool {
+	return r.Burst <= 0 || r.Every <= 0
+}
+
+// RateLimit limits how often a command or hook is used. A use must be allowed by every configured Rate.
+type RateLimit struct {
+	// PerUser limits each Slack user.
+	PerUser Rate
+	// PerChannel limits each channel.
+	PerChannel Rate
+	// Global limits everyone together.
+	Global Rate
+	// Persist stores the state of the buckets in the Bot's database so that limits survive a restart.
+	Persist bool
+}
+
+// WithRateLimit limits how often a command or hook is used.
+// Users that are over the limit of a command get an ephemeral reply asking them to slow down.
+// Messages over the limit of a hook are dropped without a reply.
+func WithRateLimit(limit RateLimit) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.rateLimit = &limit
+	}
+}
+
+// tokenBucket is the state of a single Rate for a single key.
+type tokenBucket struct {
+	Tokens float64   `json:"tokens"`
+	Last   time.Time `json:"last"`
+	rate   Rate
+}
+
+// full returns true if the bucket will have refilled by now, so it is the same as a new bucket.
+func (tb *tokenBucket) full(now time.Time) bool {
+	return tb.Tokens+float64(now.Sub(tb.Last))/float64(tb.rate.Every) >= float64(tb.rate.Burst)
+}
+
+// refillTime returns how long the bucket takes to refill, after which it doesn't need to be kept.
+func (tb *tokenBucket) refillTime() time.Duration {
+	return time.Duration((float64(tb.rate.Burst) - tb.Tokens) * float64(tb.rate.Every))
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (tb *tokenBucket) refill(rate Rate, now time.Time) {
+	elapsed := now.Sub(tb.Last)
+	if elapsed > 0 {
+		tb.Tokens = math.Min(float64(rate.Burst), tb.Tokens+float64(elapsed)/float64(rate.Every))
+	}
+	tb.Last = now
+}
+
+// rateLimiter holds the token buckets for every rate limited handler.
+// Buckets that have refilled are dropped, since a new bucket starts full.
+type rateLimiter struct {
+	mtx     sync.Mutex
+	buckets map[string]*tokenBucket
+	// dirty are the persisted buckets that changed since they were last written to the store.
+	dirty     map[string]bool
+	lastSweep time.Time
+	// store persists buckets for limits with Persist set.
+	store *Store
+	log   *zap.Logger
+	now   func() time.Time
+}
+
+// newRateLimiter returns a rateLimiter that persists buckets in store.
+func newRateLimiter(log *zap.Logger, store *Store) *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		dirty:   make(map[string]bool),
+		store:   store,
+		log:     log,
+		now:     time.Now,
+	}
+}
+
+// run writes persisted buckets to the store in batches until ctx is done, then writes any that are left.
+func (rl *rateLimiter) run(ctx context.Context) {
+	ticker := time.NewTicker(rateLimitFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rl.flush()
+		case <-ctx.Done():
+			rl.flush()
+			return
+		}
+	}
+}
+
+// allow takes a token from each of the buckets that apply to a use of the handler identified by handlerKey.
+// If any bucket is empty, no tokens are taken and the time until the use would be allowed is returned.
+func (rl *rateLimiter) allow(limit *RateLimit, handlerKey, userId, channelId string) (bool, time.Duration) {
+	if rl == nil || limit == nil {
+		return true, 0
+	}
+
+	type check struct {
+		key  string
+		rate Rate
+	}
+	checks := []check{
+		{key: fmt.Sprintf("%s:global", handlerKey), rate: limit.Global},
+	}
+	if userId != "" {
+		checks = append(checks, check{key: fmt.Sprintf("%s:user:%s", handlerKey, userId), rate: limit.PerUser})
+	}
+	if channelId != "" {
+		checks = append(checks, check{key: fmt.Sprintf("%s:channel:%s", handlerKey, channelId), rate: limit.PerChannel})
+	}
+
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	now := rl.now()
+	var buckets []*tokenBucket
+	var keys []string
+	var wait time.Duration
+	for _, c := range checks {
+		if c.rate.unlimited() {
+			continue
+		}
+
+		tb := rl.bucket(c.key, c.rate, limit.Persist, now)
+		tb.refill(c.rate, now)
+		if tb.Tokens < 1 {
+			w := time.Duration((1 - tb.Tokens) * float64(c.rate.Every))
+			if w > wait {
+				wait = w
+			}
+		}
+		buckets = append(buckets, tb)
+		keys = append(keys, c.key)
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for i, tb := range buckets {
+		tb.Tokens--
+		if limit.Persist {
+			rl.dirty[keys[i]] = true
+		}
+	}
+	rl.sweep(now)
+
+	return true, 0
+}
+
+// sweep drops the buckets that have refilled, at most once every rateLimitSweepInterval.
+// Buckets that haven't been written to the store yet are kept.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, tb := range rl.buckets {
+		if !rl.dirty[key] && tb.full(now) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// bucket returns the bucket for key, loading it from the store if the limit is persisted. New buckets start full.
+func (rl *rateLimiter) bucket(key string, rate Rate, persist bool, now time.Time) *tokenBucket {
+	if tb, ok := rl.buckets[key]; ok {
+		return tb
+	}
+
+	tb := &tokenBucket{Tokens: float64(rate.Burst), Last: now, rate: rate}
+	if persist && rl.store != nil {
+		err := rl.store.Get(rateLimitPrefix+key, func(val []byte) error {
+			if len(val) == 0 {
+				return nil
+			}
+			return json.Unmarshal(val, tb)
+		})
+		if err != nil {
+			rl.log.Error("error loading rate limit", zap.String("key", key), zap.Error(err))
+		}
+	}
+	rl.buckets[key] = tb
+
+	return tb
+}
+
+// flush writes the persisted buckets that changed to the store in a single transaction. Each expires once it has
+// refilled, when it is the same as a new bucket. Errors are logged, the in memory state is still used.
+func (rl *rateLimiter) flush() {
+	rl.mtx.Lock()
+	if rl.store == nil || len(rl.dirty) == 0 {
+		rl.mtx.Unlock()
+		return
+	}
+	vals := make(map[string][]byte, len(rl.dirty))
+	ttls := make(map[string]time.Duration, len(rl.dirty))
+	for key := range rl.dirty {
+		tb := rl.buckets[key]
+		val, err := json.Marshal(tb)
+		if err != nil {
+			rl.log.Error("error saving rate limit", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		vals[key] = val
+		ttls[key] = tb.refillTime()
+	}
+	rl.dirty = make(map[string]bool)
+	rl.mtx.Unlock()
+
+	err := rl.store.Transaction(func(bkt storage.Bucket) error {
+		for key, val := range vals {
+			err := bkt.Put([]byte(rateLimitPrefix+key), val)
+			if err != nil {
+				return err
+			}
+			err = ExpireKey(bkt, rateLimitPrefix+key, ttls[key])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		rl.log.Error("error saving rate limits", zap.Int("count", len(vals)), zap.Error(err))
+	}
+}
+
+// slowDownText is the message shown to users that are over a command's rate limit.
+func slowDownText(command string, wait time.Duration) string {
+	return fmt.Sprintf("Slow down! You can use %s again in %s.", command, (wait.Truncate(time.Second) + time.Second).String())
+}
@@ -0,0 +1,89 @@
+package quadlek
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	rl := newRateLimiter(zap.NewNop(), nil)
+	rl.now = func() time.Time { return now }
+
+	limit := &RateLimit{
+		PerUser: Rate{Burst: 2, Every: 10 * time.Second},
+		Global:  Rate{Burst: 3, Every: time.Second},
+	}
+
+	ok, _ := rl.allow(limit, "command:g", "U1", "C1")
+	require.True(t, ok)
+	ok, _ = rl.allow(limit, "command:g", "U1", "C1")
+	require.True(t, ok)
+	ok, wait := rl.allow(limit, "command:g", "U1", "C1")
+	require.False(t, ok)
+	require.Equal(t, 10*time.Second, wait)
+
+	ok, _ = rl.allow(limit, "command:g", "U2", "C1")
+	require.True(t, ok)
+	ok, wait = rl.allow(limit, "command:g", "U3", "C1")
+	require.False(t, ok)
+	require.Equal(t, time.Second, wait)
+
+	now = now.Add(5 * time.Second)
+	ok, _ = rl.allow(limit, "command:g", "U1", "C1")
+	require.False(t, ok)
+	now = now.Add(5 * time.Second)
+	ok, _ = rl.allow(limit, "command:g", "U1", "C1")
+	require.True(t, ok)
+}
+
+func TestRateLimiter_Persist(t *testing.T) {
+	backend := storage.NewMemory()
+
+	b := &Bot{storage: backend}
+	require.NoError(t, b.InitPluginBucket(corePluginId))
+
+	now := time.Unix(0, 0)
+	limit := &RateLimit{PerUser: Rate{Burst: 1, Every: time.Minute}, Persist: true}
+
+	rl := newRateLimiter(zap.NewNop(), b.getStore(corePluginId))
+	rl.now = func() time.Time { return now }
+	ok, _ := rl.allow(limit, "command:comic", "U1", "")
+	require.True(t, ok)
+	rl.flush()
+
+	// The bucket expires once it has refilled.
+	expiresAt, err := b.getStore(corePluginId).ExpiresAt(rateLimitPrefix + "command:comic:user:U1")
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Minute), expiresAt, 5*time.Second)
+
+	rl = newRateLimiter(zap.NewNop(), b.getStore(corePluginId))
+	rl.now = func() time.Time { return now }
+	ok, _ = rl.allow(limit, "command:comic", "U1", "")
+	require.False(t, ok)
+}
+
+func TestRateLimiter_Sweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	rl := newRateLimiter(zap.NewNop(), nil)
+	rl.now = func() time.Time { return now }
+
+	limit := &RateLimit{PerUser: Rate{Burst: 2, Every: time.Second}}
+	for _, user := range []string{"U1", "U2", "U3"} {
+		ok, _ := rl.allow(limit, "command:g", user, "")
+		require.True(t, ok)
+	}
+	require.Len(t, rl.buckets, 3)
+
+	// Buckets that have refilled are dropped, the one that was just used is kept.
+	now = now.Add(rateLimitSweepInterval)
+	ok, _ := rl.allow(limit, "command:g", "U1", "")
+	require.True(t, ok)
+	require.Len(t, rl.buckets, 1)
+	require.Contains(t, rl.buckets, "command:g:user:U1")
+}
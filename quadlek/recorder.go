@@ -0,0 +1,145 @@
+package quadlek
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The kinds of requests from Slack that are recorded.
+const (
+	RecordedEvent       = "event"
+	RecordedCommand     = "command"
+	RecordedInteraction = "interaction"
+)
+
+// recordedSecrets are removed from payloads before they are recorded. The token is the app's verification token,
+// and response urls and trigger ids let anyone holding them reply to the request for a while.
+// Only top level fields are removed. Secrets nested deeper, or typed by users into messages, are recorded as they are.
+var recordedSecrets = []string{"token", "response_url", "response_urls", "trigger_id"}
+
+// Recording is a request the Bot received from Slack, as written to the recording.
+//
+// The payload is the event callback for events, the form values for slash commands,
+// and the interaction callback for interactions.
+type Recording struct {
+	Time    time.Time       `json:"time"`
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// recorder appends the requests the Bot receives from Slack to a JSONL file, so they can be replayed later.
+type recorder struct {
+	mtx sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func openRecorder(path string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recorder{
+		f:   f,
+		enc: json.NewEncoder(f),
+		now: time.Now,
+	}, nil
+}
+
+// record sanitizes payload, a JSON object, and appends it to the recording.
+func (r *recorder) record(kind string, payload []byte) error {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(payload, &fields)
+	if err != nil {
+		return err
+	}
+	for _, secret := range recordedSecrets {
+		delete(fields, secret)
+	}
+	sanitized, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.enc.Encode(&Recording{
+		Time:    r.now().UTC(),
+		Kind:    kind,
+		Payload: sanitized,
+	})
+}
+
+func (r *recorder) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.f.Close()
+}
+
+// record writes a request from Slack to the recording, if recording is enabled.
+func (b *Bot) record(kind string, payload []byte) {
+	if b.recorder == nil {
+		return
+	}
+
+	err := b.recorder.record(kind, payload)
+	if err != nil {
+		b.Log.Error("error recording request", zap.String("kind", kind), zap.Error(err))
+	}
+}
+
+// recordForm writes a slash command's form values to the recording, if recording is enabled.
+func (b *Bot) recordForm(kind string, form url.Values) {
+	if b.recorder == nil {
+		return
+	}
+
+	values := make(map[string]string, len(form))
+	for k := range form {
+		values[k] = form.Get(k)
+	}
+	payload, err := json.Marshal(values)
+	if err != nil {
+		b.Log.Error("error recording request", zap.String("kind", kind), zap.Error(err))
+		return
+	}
+
+	b.record(kind, payload)
+}
+
+// ReadRecordings reads a recording written by a Bot configured with WithRecording.
+func ReadRecordings(r io.Reader) ([]Recording, error) {
+	var recordings []Recording
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		rec := Recording{}
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recording on line %d: %w", line, err)
+		}
+		recordings = append(recordings, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return recordings, nil
+}
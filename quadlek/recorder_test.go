@@ -0,0 +1,61 @@
+package quadlek
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	rec, err := openRecorder(path)
+	require.NoError(t, err)
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	rec.now = func() time.Time { return now }
+
+	b := &Bot{Log: zap.NewNop(), recorder: rec}
+	b.record(RecordedEvent, []byte(`{"token": "verification", "type": "event_callback", "event": {"type": "message", "text": "jirwin++"}}`))
+	b.recordForm(RecordedCommand, url.Values{
+		"token":        {"verification"},
+		"command":      {"/karma"},
+		"text":         {"jirwin"},
+		"response_url": {"https://hooks.slack.com/commands/secret"},
+	})
+	b.record(RecordedInteraction, []byte(`{"type": "shortcut", "trigger_id": "123.456", "callback_id": "greet"}`))
+	b.record(RecordedEvent, []byte(`not json`))
+	require.NoError(t, rec.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	recordings, err := ReadRecordings(f)
+	require.NoError(t, err)
+	require.Len(t, recordings, 3)
+
+	for i, kind := range []string{RecordedEvent, RecordedCommand, RecordedInteraction} {
+		require.Equal(t, kind, recordings[i].Kind)
+		require.True(t, now.Equal(recordings[i].Time))
+	}
+	require.JSONEq(t, `{"type": "event_callback", "event": {"type": "message", "text": "jirwin++"}}`, string(recordings[0].Payload))
+	require.JSONEq(t, `{"command": "/karma", "text": "jirwin"}`, string(recordings[1].Payload))
+	require.JSONEq(t, `{"type": "shortcut", "callback_id": "greet"}`, string(recordings[2].Payload))
+
+	// Recordings are appended to.
+	rec, err = openRecorder(path)
+	require.NoError(t, err)
+	require.NoError(t, rec.record(RecordedEvent, json.RawMessage(`{}`)))
+	require.NoError(t, rec.Close())
+
+	f, err = os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	recordings, err = ReadRecordings(f)
+	require.NoError(t, err)
+	require.Len(t, recordings, 4)
+}
@@ -0,0 +1,262 @@
+package quadlek
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.uber.org/zap"
+)
+
+// AdminRole is the role that grants access to every command and interaction, whatever roles they require.
+// Users listed in Config.Admins always have it.
+const AdminRole = "admin"
+
+// Roles are stored in the core plugin's bucket, keyed by the user or user group they are granted to.
+const (
+	userRolePrefix  = "role:user:"
+	groupRolePrefix = "role:group:"
+)
+
+// roleCheckTimeout bounds the Slack API calls made to look up user group members when checking roles.
+const roleCheckTimeout = 5 * time.Second
+
+// RoleGrant is the set of roles granted to a Slack user or user group.
+type RoleGrant struct {
+	// Group is true if Id is a user group rather than a user.
+	Group bool
+	Id    string
+	Roles []string
+}
+
+// WithRoles restricts a command, interaction or webhook to users that have at least one of roles.
+// Users with AdminRole can always use it. Webhooks don't come from Slack, so a webhook that requires roles must also
+// be signed, see WithSignatureVerifier, and identify the user it is called for with WithWebhookUser.
+func WithRoles(roles ...string) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.roles = roles
+	}
+}
+
+// WithWebhookUser sets how a webhook finds the Slack user a request is made for, which is checked against the roles
+// the webhook requires and passed to it as WebhookMsg.UserId. Only the body of a request is signed, so userId should
+// read the user from the body. The body is restored afterwards for the webhook to read.
+func WithWebhookUser(userId func(r *http.Request) (string, error)) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.webhookUser = userId
+	}
+}
+
+// GrantUserRole grants role to a Slack user.
+func (b *Bot) GrantUserRole(userId, role string) error {
+	return b.updateRoles(userRolePrefix+userId, func(roles []string) []string {
+		for _, r := range roles {
+			if r == role {
+				return roles
+			}
+		}
+		return append(roles, role)
+	})
+}
+
+// RevokeUserRole revokes role from a Slack user. Roles held through a user group are not affected.
+func (b *Bot) RevokeUserRole(userId, role string) error {
+	return b.updateRoles(userRolePrefix+userId, removeRole(role))
+}
+
+// GrantGroupRole grants role to every member of a Slack user group.
+func (b *Bot) GrantGroupRole(groupId, role string) error {
+	return b.updateRoles(groupRolePrefix+groupId, func(roles []string) []string {
+		for _, r := range roles {
+			if r == role {
+				return roles
+			}
+		}
+		return append(roles, role)
+	})
+}
+
+// RevokeGroupRole revokes role from a Slack user group.
+func (b *Bot) RevokeGroupRole(groupId, role string) error {
+	return b.updateRoles(groupRolePrefix+groupId, removeRole(role))
+}
+
+// removeRole returns an update func that removes role from a list of roles.
+func removeRole(role string) func(roles []string) []string {
+	return func(roles []string) []string {
+		kept := roles[:0]
+		for _, r := range roles {
+			if r != role {
+				kept = append(kept, r)
+			}
+		}
+		return kept
+	}
+}
+
+// updateRoles atomically updates the roles stored at key.
+func (b *Bot) updateRoles(key string, update func(roles []string) []string) error {
+	return b.getStore(corePluginId).GetAndUpdate(key, func(val []byte) ([]byte, error) {
+		roles, err := decodeRoles(val)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(update(roles))
+	})
+}
+
+// decodeRoles decodes a stored list of roles.
+func decodeRoles(val []byte) ([]string, error) {
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	var roles []string
+	err := json.Unmarshal(val, &roles)
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// RoleGrants returns every user and user group that has been granted a role, users first.
+// Admins from the config are not included.
+func (b *Bot) RoleGrants() ([]RoleGrant, error) {
+	var grants []RoleGrant
+	err := b.getStore(corePluginId).Range("role:", func(key string, value []byte) error {
+		grant := RoleGrant{}
+		switch {
+		case strings.HasPrefix(key, userRolePrefix):
+			grant.Id = strings.TrimPrefix(key, userRolePrefix)
+		case strings.HasPrefix(key, groupRolePrefix):
+			grant.Group = true
+			grant.Id = strings.TrimPrefix(key, groupRolePrefix)
+		default:
+			return nil
+		}
+
+		roles, err := decodeRoles(value)
+		if err != nil {
+			return err
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+		grant.Roles = roles
+		grants = append(grants, grant)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(grants, func(i, j int) bool {
+		return !grants[i].Group && grants[j].Group
+	})
+
+	return grants, nil
+}
+
+// HasRole returns true if userId has at least one of roles, either directly, through a user group, or by being an admin.
+// It always returns true if no roles are provided.
+func (b *Bot) HasRole(ctx context.Context, userId string, roles ...string) (bool, error) {
+	if len(roles) == 0 {
+		return true, nil
+	}
+
+	for _, admin := range b.config.Admins {
+		if admin == userId {
+			return true, nil
+		}
+	}
+
+	wanted := make(map[string]bool, len(roles)+1)
+	wanted[AdminRole] = true
+	for _, role := range roles {
+		wanted[role] = true
+	}
+	matches := func(granted []string) bool {
+		for _, role := range granted {
+			if wanted[role] {
+				return true
+			}
+		}
+		return false
+	}
+
+	grants, err := b.RoleGrants()
+	if err != nil {
+		return false, err
+	}
+
+	var groups []string
+	for _, grant := range grants {
+		if !matches(grant.Roles) {
+			continue
+		}
+		if !grant.Group {
+			if grant.Id == userId {
+				return true, nil
+			}
+			continue
+		}
+		groups = append(groups, grant.Id)
+	}
+
+	for _, groupId := range groups {
+		members, err := b.api.GetUserGroupMembersContext(ctx, groupId)
+		if err != nil {
+			return false, err
+		}
+		for _, member := range members {
+			if member == userId {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// authorize returns true if userId may use a handler that requires roles. Errors looking up roles deny access.
+func (b *Bot) authorize(userId string, roles []string) bool {
+	ctx, cancel := context.WithTimeout(b.ctx, roleCheckTimeout)
+	defer cancel()
+
+	ok, err := b.HasRole(ctx, userId, roles...)
+	if err != nil {
+		b.Log.Error("error checking roles", zap.String("user", userId), zap.Strings("roles", roles), zap.Error(err))
+		return false
+	}
+
+	return ok
+}
+
+// denialText is the message shown to users that don't have the roles required to use something.
+func denialText(roles []string, what string) string {
+	return fmt.Sprintf("Sorry, you need the %s role to use %s.", strings.Join(roles, " or "), what)
+}
+
+// denyInteraction lets the user that triggered an interaction know that they don't have the roles required to use it.
+func (b *Bot) denyInteraction(ctx context.Context, cb *slack.InteractionCallback, roles []string, name string) {
+	text := denialText(roles, name)
+
+	var err error
+	switch {
+	case cb.ResponseURL != "":
+		err = b.RespondToSlashCommandContext(ctx, cb.ResponseURL, &CommandResp{Text: text})
+	case cb.Channel.ID != "":
+		_, err = b.api.PostEphemeralContext(ctx, cb.Channel.ID, cb.User.ID, slack.MsgOptionText(text, false))
+	}
+	if err != nil {
+		b.Log.Error("error responding to denied interaction", zap.String("interaction", name), zap.Error(err))
+	}
+}
@@ -0,0 +1,120 @@
+package quadlek
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestRoles(t *testing.T) {
+	backend := storage.NewMemory()
+
+	b := &Bot{storage: backend, config: &Config{Admins: []string{"UADMIN"}}}
+	require.NoError(t, b.InitPluginBucket(corePluginId))
+	ctx := context.Background()
+
+	ok, err := b.HasRole(ctx, "U1", "deployer")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = b.HasRole(ctx, "UADMIN", "deployer")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, b.GrantUserRole("U1", "deployer"))
+	require.NoError(t, b.GrantUserRole("U1", "deployer"))
+	require.NoError(t, b.GrantUserRole("U2", AdminRole))
+
+	ok, err = b.HasRole(ctx, "U1", "deployer")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = b.HasRole(ctx, "U2", "deployer")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	grants, err := b.RoleGrants()
+	require.NoError(t, err)
+	require.Equal(t, []RoleGrant{
+		{Id: "U1", Roles: []string{"deployer"}},
+		{Id: "U2", Roles: []string{AdminRole}},
+	}, grants)
+
+	require.NoError(t, b.RevokeUserRole("U1", "deployer"))
+	ok, err = b.HasRole(ctx, "U1", "deployer")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestWebhookRoles(t *testing.T) {
+	b, err := NewBot(context.Background(),
+		WithApiKey("xoxb-test"),
+		WithSigningSecret("secret"),
+		WithStorage(storage.NewMemory()),
+		WithLogger(zap.NewNop()),
+		WithAdmins("UADMIN"),
+	)
+	require.NoError(t, err)
+	defer b.Stop()
+
+	verifier := WithSignatureVerifier(&SignatureVerifier{Secrets: []string{"deploy-secret"}})
+	bodyUser := WithWebhookUser(func(r *http.Request) (string, error) {
+		req := struct {
+			User string `json:"user"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		return req.User, err
+	})
+	deploy := func(ctx context.Context, msg *WebhookMsg) error {
+		body, err := io.ReadAll(msg.Request.Body)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(msg.ResponseWriter, "%s deployed %s", msg.UserId, body)
+		return err
+	}
+
+	// Webhooks can only require roles if they are signed and identify the user.
+	require.Error(t, b.RegisterPlugin(MakePlugin("unsigned", nil, nil, nil, []Webhook{
+		WebhookFunc("unsigned", deploy, WithRoles("deployer"), bodyUser),
+	}, nil)))
+	require.Error(t, b.RegisterPlugin(MakePlugin("anonymous", nil, nil, nil, []Webhook{
+		WebhookFunc("anonymous", deploy, WithRoles("deployer"), verifier),
+	}, nil)))
+	require.NoError(t, b.RegisterPlugin(MakePlugin("deploys", nil, nil, nil, []Webhook{
+		WebhookFunc("deploy", deploy, WithRoles("deployer"), verifier, bodyUser),
+	}, nil)))
+	require.NoError(t, b.GrantUserRole("U1", "deployer"))
+
+	run := func(body string) *httptest.ResponseRecorder {
+		r := mux.SetURLVars(signedRequest("/slack/plugin/deploy", body, "deploy-secret", time.Now()), map[string]string{"webhook-name": "deploy"})
+		w := httptest.NewRecorder()
+		b.handlePluginWebhook(w, r)
+		return w
+	}
+
+	w := run(`{"user": "U1"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `U1 deployed {"user": "U1"}`, w.Body.String())
+
+	w = run(`{"user": "UADMIN"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = run(`{"user": "U2"}`)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), "Sorry, you need the deployer role to use the deploy webhook.")
+
+	w = run(`not json`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
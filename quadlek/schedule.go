@@ -0,0 +1,407 @@
+package quadlek
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// scheduleStatePrefix is the prefix of the keys that task state is stored under in the core plugin's bucket.
+const scheduleStatePrefix = "schedule:"
+
+// Schedule decides when a ScheduledTask runs.
+type Schedule interface {
+	// Next returns the first time after t that the task should run, or the zero time if it shouldn't run again.
+	Next(t time.Time) time.Time
+}
+
+// intervalSchedule runs every interval.
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+// Next implements Schedule
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// Every returns a Schedule that runs a task every interval, starting one interval after the Bot starts.
+func Every(interval time.Duration) Schedule {
+	return intervalSchedule{interval: interval}
+}
+
+// onceSchedule runs once, at a fixed time.
+type onceSchedule struct {
+	at time.Time
+}
+
+// Next implements Schedule
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.at) {
+		return s.at
+	}
+
+	return time.Time{}
+}
+
+// At returns a Schedule that runs a task once, at t. If the Bot isn't running at t, the task runs when it next starts.
+// If a task that has already run is changed to run At a later time, it runs again at that time.
+func At(t time.Time) Schedule {
+	return onceSchedule{at: t}
+}
+
+// Cron returns a Schedule for a standard five field cron expression, e.g. "0 9 * * MON-FRI",
+// or a descriptor such as "@daily". Times are in the local time zone unless the expression starts with CRON_TZ=.
+func Cron(expr string) (Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// MustCron is like Cron but panics if the expression can't be parsed. It is intended for plugins' Register functions.
+func MustCron(expr string) Schedule {
+	s, err := Cron(expr)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// ScheduledTaskMsg is passed to a scheduled task each time it runs.
+type ScheduledTaskMsg struct {
+	Bot   *Bot
+	Store *Store
+	// Scheduled is the time the run was scheduled for. It is in the past if the run was missed while the Bot was stopped.
+	Scheduled time.Time
+}
+
+// ScheduledTaskFunc does the work of a scheduled task. A returned error is logged and shown to admins.
+type ScheduledTaskFunc func(ctx context.Context, msg *ScheduledTaskMsg) error
+
+// ScheduledTask is the interface that plugins implement for work that runs on a Schedule rather than in response to Slack.
+type ScheduledTask interface {
+	GetName() string
+	Schedule() Schedule
+	Run(ctx context.Context, msg *ScheduledTaskMsg) error
+}
+
+// scheduledTask is an implementation of the ScheduledTask interface
+type scheduledTask struct {
+	name     string
+	schedule Schedule
+	runFunc  ScheduledTaskFunc
+	opts     handlerOptions
+}
+
+// GetName returns the name of the task. It must be unique within the plugin.
+func (t *scheduledTask) GetName() string {
+	return t.name
+}
+
+// Schedule returns when the task runs.
+func (t *scheduledTask) Schedule() Schedule {
+	return t.schedule
+}
+
+// Run runs the task once.
+func (t *scheduledTask) Run(ctx context.Context, msg *ScheduledTaskMsg) error {
+	return t.runFunc(ctx, msg)
+}
+
+// handlerOptions returns the options the task was made with.
+func (t *scheduledTask) handlerOptions() *handlerOptions {
+	return &t.opts
+}
+
+// MakeScheduledTask is a helper function that returns a ScheduledTask. WithTimeout limits how long each run may take.
+func MakeScheduledTask(name string, schedule Schedule, fn ScheduledTaskFunc, opts ...HandlerOption) ScheduledTask {
+	return &scheduledTask{
+		name:     name,
+		schedule: schedule,
+		runFunc:  fn,
+		opts:     makeHandlerOptions(opts),
+	}
+}
+
+// ScheduledTaskStatus describes the state of a scheduled task.
+type ScheduledTaskStatus struct {
+	PluginId string
+	Name     string
+	// Next is when the task will next run. It is zero if the task won't run again.
+	Next      time.Time
+	LastRun   time.Time
+	LastError string
+	// Cancelled is true if an admin has cancelled the task. It stays cancelled across restarts until it is resumed.
+	Cancelled bool
+}
+
+// taskState is the persisted state of a scheduled task.
+type taskState struct {
+	Next      time.Time `json:"next"`
+	LastRun   time.Time `json:"last_run"`
+	LastError string    `json:"last_error,omitempty"`
+	Cancelled bool      `json:"cancelled,omitempty"`
+	Done      bool      `json:"done,omitempty"`
+	// Scheduled is the time the last run was scheduled for.
+	Scheduled time.Time `json:"scheduled,omitempty"`
+}
+
+// lastScheduled returns the time the last run was scheduled for. State saved before it was recorded only has the
+// time of the last run.
+func (s taskState) lastScheduled() time.Time {
+	if s.Scheduled.IsZero() {
+		return s.LastRun
+	}
+
+	return s.Scheduled
+}
+
+// registeredTask is the internal struct that represents a registered scheduled task.
+type registeredTask struct {
+	PluginId string
+	Task     ScheduledTask
+	mtx      sync.Mutex
+	state    taskState
+	// wake interrupts the task's wait when it is cancelled or resumed.
+	wake chan struct{}
+}
+
+// key is where the task's state is stored.
+func (rt *registeredTask) key() string {
+	return fmt.Sprintf("%s%s:%s", scheduleStatePrefix, rt.PluginId, rt.Task.GetName())
+}
+
+// scheduler holds every registered scheduled task.
+type scheduler struct {
+	mtx   sync.Mutex
+	tasks map[string]*registeredTask
+	// started is closed once the Bot has started, tasks don't run before then.
+	started   chan struct{}
+	startOnce sync.Once
+}
+
+// startedChan returns the channel that is closed once the Bot has started.
+func (s *scheduler) startedChan() chan struct{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.started == nil {
+		s.started = make(chan struct{})
+	}
+
+	return s.started
+}
+
+// start lets tasks run. Tasks are registered along with their plugin, before the Bot knows its own user and the
+// channels, so they wait for the Bot to start.
+func (s *scheduler) start() {
+	started := s.startedChan()
+	s.startOnce.Do(func() {
+		close(started)
+	})
+}
+
+// registerTask loads a task's persisted state and starts running it.
+func (b *Bot) registerTask(pluginId string, task ScheduledTask) error {
+	rt := &registeredTask{
+		PluginId: pluginId,
+		Task:     task,
+		wake:     make(chan struct{}, 1),
+	}
+
+	b.scheduler.mtx.Lock()
+	if b.scheduler.tasks == nil {
+		b.scheduler.tasks = make(map[string]*registeredTask)
+	}
+	if _, ok := b.scheduler.tasks[rt.key()]; ok {
+		b.scheduler.mtx.Unlock()
+		return fmt.Errorf("Scheduled task already exists: %s", task.GetName())
+	}
+	b.scheduler.tasks[rt.key()] = rt
+	b.scheduler.mtx.Unlock()
+
+	err := b.getStore(corePluginId).Get(rt.key(), func(val []byte) error {
+		if len(val) == 0 {
+			return nil
+		}
+		return json.Unmarshal(val, &rt.state)
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if rt.state.Done {
+		// A task that has finished runs again if its schedule was changed to run after the last run.
+		if next := task.Schedule().Next(now); !next.IsZero() && next.After(rt.state.lastScheduled()) {
+			rt.state.Done = false
+			rt.state.Next = next
+		}
+	}
+	if rt.state.Next.IsZero() && !rt.state.Done {
+		rt.state.Next = task.Schedule().Next(now)
+		if rt.state.Next.IsZero() {
+			rt.state.Done = true
+		}
+	}
+	b.saveTask(rt)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		b.supervise(pluginId, "scheduled_task", task.GetName(), false, func(ctx context.Context) {
+			b.runTask(ctx, rt)
+		})
+	}()
+
+	return nil
+}
+
+// runTask waits for each scheduled run of a task and runs it, until the Bot stops or the task is done.
+func (b *Bot) runTask(ctx context.Context, rt *registeredTask) {
+	select {
+	case <-b.scheduler.startedChan():
+	case <-ctx.Done():
+		return
+	}
+
+	opts := getHandlerOptions(rt.Task)
+	for {
+		rt.mtx.Lock()
+		state := rt.state
+		rt.mtx.Unlock()
+
+		if state.Done {
+			return
+		}
+
+		// Cancelled tasks wait to be woken up by ResumeScheduledTask.
+		wait := time.Duration(math.MaxInt64)
+		if !state.Cancelled {
+			wait = time.Until(state.Next)
+		}
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-rt.wake:
+			timer.Stop()
+			continue
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		msg := &ScheduledTaskMsg{
+			Bot:       b,
+			Store:     b.getStore(rt.PluginId),
+			Scheduled: state.Next,
+		}
+		_, err := invoke(ctx, ctx, opts.timeout, b, msg.Store, "scheduled_task", rt.Task.GetName(), func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, rt.Task.Run(ctx, msg)
+		})
+
+		now := time.Now()
+		rt.mtx.Lock()
+		rt.state.LastRun = now
+		rt.state.Scheduled = state.Next
+		rt.state.LastError = ""
+		if err != nil {
+			rt.state.LastError = err.Error()
+		}
+		// A task that was cancelled while it ran keeps its next run time, so resuming it picks up where it left off.
+		if !rt.state.Cancelled {
+			rt.state.Next = rt.Task.Schedule().Next(now)
+			rt.state.Done = rt.state.Next.IsZero()
+		}
+		rt.mtx.Unlock()
+		b.saveTask(rt)
+
+		if err != nil {
+			b.notifyAdmins(fmt.Sprintf("Scheduled task %s/%s failed: %s", rt.PluginId, rt.Task.GetName(), err.Error()))
+		}
+	}
+}
+
+// saveTask persists a task's state. Errors are logged, the in memory state is still used.
+func (b *Bot) saveTask(rt *registeredTask) {
+	rt.mtx.Lock()
+	val, err := json.Marshal(rt.state)
+	rt.mtx.Unlock()
+	if err == nil {
+		err = b.getStore(corePluginId).Update(rt.key(), val)
+	}
+	if err != nil {
+		b.Log.Error("error saving scheduled task", zap.String("plugin", rt.PluginId), zap.String("task", rt.Task.GetName()), zap.Error(err))
+	}
+}
+
+// ScheduledTasks returns the status of every scheduled task, sorted by plugin id and name.
+func (b *Bot) ScheduledTasks() []ScheduledTaskStatus {
+	b.scheduler.mtx.Lock()
+	defer b.scheduler.mtx.Unlock()
+
+	statuses := make([]ScheduledTaskStatus, 0, len(b.scheduler.tasks))
+	for _, rt := range b.scheduler.tasks {
+		rt.mtx.Lock()
+		statuses = append(statuses, ScheduledTaskStatus{
+			PluginId:  rt.PluginId,
+			Name:      rt.Task.GetName(),
+			Next:      rt.state.Next,
+			LastRun:   rt.state.LastRun,
+			LastError: rt.state.LastError,
+			Cancelled: rt.state.Cancelled,
+		})
+		if rt.state.Done {
+			statuses[len(statuses)-1].Next = time.Time{}
+		}
+		rt.mtx.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].PluginId != statuses[j].PluginId {
+			return statuses[i].PluginId < statuses[j].PluginId
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// CancelScheduledTask stops a scheduled task from running until it is resumed, including across restarts.
+// A run that is in progress is not interrupted.
+func (b *Bot) CancelScheduledTask(pluginId, name string) error {
+	return b.setTaskCancelled(pluginId, name, true)
+}
+
+// ResumeScheduledTask resumes a cancelled task. Runs that were missed while it was cancelled run straight away.
+func (b *Bot) ResumeScheduledTask(pluginId, name string) error {
+	return b.setTaskCancelled(pluginId, name, false)
+}
+
+// setTaskCancelled cancels or resumes a task and wakes it up so it notices.
+func (b *Bot) setTaskCancelled(pluginId, name string, cancelled bool) error {
+	b.scheduler.mtx.Lock()
+	rt, ok := b.scheduler.tasks[fmt.Sprintf("%s%s:%s", scheduleStatePrefix, pluginId, name)]
+	b.scheduler.mtx.Unlock()
+	if !ok {
+		return fmt.Errorf("no scheduled task %s/%s", pluginId, name)
+	}
+
+	rt.mtx.Lock()
+	rt.state.Cancelled = cancelled
+	rt.mtx.Unlock()
+	b.saveTask(rt)
+
+	select {
+	case rt.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
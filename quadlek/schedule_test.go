@@ -0,0 +1,112 @@
+package quadlek
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestSchedules(t *testing.T) {
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	require.Equal(t, start.Add(time.Hour), Every(time.Hour).Next(start))
+	require.Equal(t, start.Add(time.Hour), At(start.Add(time.Hour)).Next(start))
+	require.True(t, At(start).Next(start).IsZero())
+	require.Equal(t, time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), MustCron("CRON_TZ=UTC @daily").Next(start))
+
+	_, err := Cron("not a cron expression")
+	require.Error(t, err)
+}
+
+func TestScheduledTask(t *testing.T) {
+	backend := storage.NewMemory()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bot{storage: backend, Log: zap.NewNop(), config: &Config{}, ctx: ctx, cancel: cancel}
+	require.NoError(t, b.InitPluginBucket(corePluginId))
+	require.NoError(t, b.InitPluginBucket("test"))
+
+	var runs int32
+	task := MakeScheduledTask("tick", Every(10*time.Millisecond), func(ctx context.Context, msg *ScheduledTaskMsg) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+	require.NoError(t, b.registerTask("test", task))
+	require.Error(t, b.registerTask("test", task))
+
+	// Tasks don't run until the Bot has started.
+	time.Sleep(30 * time.Millisecond)
+	require.Zero(t, atomic.LoadInt32(&runs))
+	b.scheduler.start()
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&runs) >= 2 }, time.Second, time.Millisecond)
+
+	require.NoError(t, b.CancelScheduledTask("test", "tick"))
+	require.Error(t, b.CancelScheduledTask("test", "nope"))
+	time.Sleep(20 * time.Millisecond)
+	cancelledRuns := atomic.LoadInt32(&runs)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, cancelledRuns, atomic.LoadInt32(&runs))
+
+	statuses := b.ScheduledTasks()
+	require.Len(t, statuses, 1)
+	require.True(t, statuses[0].Cancelled)
+	require.False(t, statuses[0].LastRun.IsZero())
+
+	cancel()
+	b.wg.Wait()
+
+	// The cancellation is persisted, so a restarted bot doesn't run the task.
+	ctx, cancel = context.WithCancel(context.Background())
+	b = &Bot{storage: backend, Log: zap.NewNop(), config: &Config{}, ctx: ctx, cancel: cancel}
+	require.NoError(t, b.registerTask("test", task))
+	require.True(t, b.ScheduledTasks()[0].Cancelled)
+	cancel()
+	b.wg.Wait()
+}
+
+func TestScheduledTaskRescheduled(t *testing.T) {
+	backend := storage.NewMemory()
+	var runs int32
+	run := func(ctx context.Context, msg *ScheduledTaskMsg) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}
+
+	startBot := func(at time.Time) (*Bot, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		b := &Bot{storage: backend, Log: zap.NewNop(), config: &Config{}, ctx: ctx, cancel: cancel}
+		require.NoError(t, b.InitPluginBucket(corePluginId))
+		require.NoError(t, b.InitPluginBucket("test"))
+		require.NoError(t, b.registerTask("test", MakeScheduledTask("once", At(at), run)))
+		b.scheduler.start()
+		return b, cancel
+	}
+	stop := func(b *Bot, cancel context.CancelFunc) {
+		cancel()
+		b.wg.Wait()
+	}
+
+	at := time.Now().Add(10 * time.Millisecond)
+	b, cancel := startBot(at)
+	require.Eventually(t, func() bool { return b.ScheduledTasks()[0].Next.IsZero() }, time.Second, time.Millisecond)
+	stop(b, cancel)
+	require.Equal(t, int32(1), atomic.LoadInt32(&runs))
+
+	// The same time doesn't run again after a restart.
+	b, cancel = startBot(at)
+	require.True(t, b.ScheduledTasks()[0].Next.IsZero())
+	stop(b, cancel)
+
+	// Moving it to a later time does.
+	b, cancel = startBot(time.Now().Add(10 * time.Millisecond))
+	require.False(t, b.ScheduledTasks()[0].Next.IsZero())
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&runs) == 2 }, time.Second, time.Millisecond)
+	stop(b, cancel)
+}
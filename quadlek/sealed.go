@@ -0,0 +1,371 @@
+package quadlek
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// sealedMagic starts every sealed value. Protobufs and text never start with a zero byte, so sealed values
+// can be told apart from values written before they were sealed.
+var sealedMagic = []byte("\x00qs\x01")
+
+// ErrNoSealingKey is returned when opening a sealed value if no sealing key is configured.
+var ErrNoSealingKey = errors.New("no sealing key is configured")
+
+// SealingConfig configures the keys used to encrypt values sealed with Store.PutSealed.
+// Keys are base64 encoded 32 byte AES-256 keys, e.g. the output of `openssl rand -base64 32`.
+type SealingConfig struct {
+	// KeyId is stored with every sealed value, so that values sealed with an old key can be opened after it is rotated.
+	KeyId string `yaml:"key_id" toml:"key_id"`
+	// Key is the key new values are sealed with.
+	Key string `yaml:"key" toml:"key"`
+	// KeyFile is a file holding Key, used if Key is empty.
+	KeyFile string `yaml:"key_file" toml:"key_file"`
+	// OldKeys are previous keys by key id. They are only used to open values sealed before the key was rotated.
+	OldKeys map[string]string `yaml:"old_keys" toml:"old_keys"`
+}
+
+// sealer seals and opens values with AES-GCM. A nil sealer can only open values that aren't sealed.
+type sealer struct {
+	keyId string
+	keys  map[string]cipher.AEAD
+}
+
+// newSealer returns a sealer for the configured keys, or nil if no key is configured.
+func newSealer(cfg SealingConfig) (*sealer, error) {
+	key := cfg.Key
+	if key == "" && cfg.KeyFile != "" {
+		out, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = strings.TrimSpace(string(out))
+	}
+	if key == "" {
+		if len(cfg.OldKeys) > 0 {
+			return nil, fmt.Errorf("old sealing keys are configured without a current key")
+		}
+		return nil, nil
+	}
+
+	s := &sealer{
+		keyId: cfg.KeyId,
+		keys:  make(map[string]cipher.AEAD, len(cfg.OldKeys)+1),
+	}
+	if len(s.keyId) > 255 {
+		return nil, fmt.Errorf("the sealing key id is too long")
+	}
+
+	add := func(keyId, key string) error {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("invalid sealing key %q: %w", keyId, err)
+		}
+		if len(raw) != 32 {
+			return fmt.Errorf("invalid sealing key %q: must be 32 bytes, not %d", keyId, len(raw))
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		s.keys[keyId] = aead
+
+		return nil
+	}
+	for keyId, oldKey := range cfg.OldKeys {
+		err := add(keyId, oldKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := add(cfg.KeyId, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// isSealed returns true if value was sealed.
+func isSealed(value []byte) bool {
+	return bytes.HasPrefix(value, sealedMagic)
+}
+
+// sealedKeyId returns the id of the key a sealed value was sealed with.
+func sealedKeyId(value []byte) (string, []byte, error) {
+	rest := value[len(sealedMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return "", nil, fmt.Errorf("invalid sealed value")
+	}
+
+	return string(rest[1 : 1+rest[0]]), rest[1+rest[0]:], nil
+}
+
+// seal encrypts plaintext. aad isn't stored, the same aad must be passed to open.
+func (s *sealer) seal(aad, plaintext []byte) ([]byte, error) {
+	if s == nil {
+		return nil, ErrNoSealingKey
+	}
+
+	aead := s.keys[s.keyId]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(sealedMagic)+1+len(s.keyId)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, sealedMagic...)
+	out = append(out, byte(len(s.keyId)))
+	out = append(out, s.keyId...)
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+// open decrypts a sealed value. Values that aren't sealed are returned as they are.
+func (s *sealer) open(aad, value []byte) ([]byte, error) {
+	if !isSealed(value) {
+		return value, nil
+	}
+	if s == nil {
+		return nil, ErrNoSealingKey
+	}
+
+	keyId, rest, err := sealedKeyId(value)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := s.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("no sealing key with id %q is configured", keyId)
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid sealed value")
+	}
+
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], aad)
+}
+
+// current returns true if value is sealed with the current key.
+func (s *sealer) current(value []byte) bool {
+	if s == nil || !isSealed(value) {
+		return false
+	}
+	keyId, _, err := sealedKeyId(value)
+
+	return err == nil && keyId == s.keyId
+}
+
+// sealedAAD binds a sealed value to the key it is stored at, so it can't be moved to another key or plugin.
+func sealedAAD(pluginId string, key []byte) []byte {
+	return expiryKey(pluginId, key)
+}
+
+// GetBucketSealed returns the value sealed at key, or nil if it doesn't exist.
+// Values stored before they were sealed are returned as they are. bkt must be the bucket passed to Store.Transaction or Store.View.
+func GetBucketSealed(bkt storage.Bucket, key string) ([]byte, error) {
+	sb, ok := bkt.(*storeBucket)
+	if !ok {
+		return nil, ErrNotStoreBucket
+	}
+
+	val, err := sb.Get([]byte(key))
+	if err != nil || val == nil {
+		return nil, err
+	}
+
+	return sb.sealer.open(sealedAAD(sb.pluginId, []byte(key)), val)
+}
+
+// PutBucketSealed encrypts value and stores it at key. bkt must be the bucket passed to Store.Transaction.
+// If no sealing key is configured the value is stored as it is, see WithSealedKeys.
+func PutBucketSealed(bkt storage.Bucket, key string, value []byte) error {
+	sb, ok := bkt.(*storeBucket)
+	if !ok {
+		return ErrNotStoreBucket
+	}
+	if sb.sealer == nil {
+		return sb.Put([]byte(key), value)
+	}
+
+	sealed, err := sb.sealer.seal(sealedAAD(sb.pluginId, []byte(key)), value)
+	if err != nil {
+		return err
+	}
+
+	return sb.Put([]byte(key), sealed)
+}
+
+// ResealBucket seals every value that starts with prefix with the current key. It seals values that were stored
+// before they were sealed, and values sealed with old keys after a rotation. It returns how many values were sealed.
+// If no sealing key is configured nothing is sealed.
+func ResealBucket(bkt storage.Bucket, prefix string) (int, error) {
+	sb, ok := bkt.(*storeBucket)
+	if !ok {
+		return 0, ErrNotStoreBucket
+	}
+	if sb.sealer == nil {
+		return 0, nil
+	}
+
+	// Collect the keys first, the bucket can't be modified while it is scanned.
+	var keys []string
+	err := sb.Scan([]byte(prefix), func(key, value []byte) error {
+		if !sb.sealer.current(value) {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		// Resealing keeps the key's expiry time.
+		expiresAt, err := sb.expiresAt([]byte(key))
+		if err != nil {
+			return 0, err
+		}
+		val, err := GetBucketSealed(sb, key)
+		if err != nil {
+			return 0, err
+		}
+		err = PutBucketSealed(sb, key, val)
+		if err != nil {
+			return 0, err
+		}
+		if !expiresAt.IsZero() {
+			err = ExpireKey(sb, key, expiresAt.Sub(sb.now))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(keys), nil
+}
+
+// GetSealed returns the value sealed at key, or nil if it doesn't exist.
+func (s *Store) GetSealed(key string) ([]byte, error) {
+	var val []byte
+	err := s.View(func(bkt storage.Bucket) error {
+		var err error
+		val, err = GetBucketSealed(bkt, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+// PutSealed encrypts value with the configured sealing key and stores it at key,
+// so that it can't be read from the database without the key. If no key is configured the value is stored as it is.
+func (s *Store) PutSealed(key string, value []byte) error {
+	return s.Transaction(func(bkt storage.Bucket) error {
+		return PutBucketSealed(bkt, key, value)
+	})
+}
+
+// Reseal seals every value that starts with prefix with the current key, e.g. after rotating keys.
+func (s *Store) Reseal(prefix string) (int, error) {
+	resealed := 0
+	err := s.Transaction(func(bkt storage.Bucket) error {
+		var err error
+		resealed, err = ResealBucket(bkt, prefix)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return resealed, nil
+}
+
+// resealPlugin seals the values a plugin declared with WithSealedKeys. It runs every time the plugin is registered,
+// so values stored while no key was configured are sealed once one is, and values sealed with an old key are
+// resealed after a rotation.
+func (b *Bot) resealPlugin(pluginId string, prefixes []string) error {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	if b.sealer == nil {
+		b.Log.Warn("no sealing key is configured, the plugin's secrets are stored unencrypted", zap.String("plugin", pluginId))
+		return nil
+	}
+
+	store := b.getStore(pluginId)
+	for _, prefix := range prefixes {
+		resealed, err := store.Reseal(prefix)
+		if err != nil {
+			return fmt.Errorf("unable to seal %s keys for %s: %w", prefix, pluginId, err)
+		}
+		if resealed > 0 {
+			b.Log.Info("sealed stored secrets", zap.String("plugin", pluginId), zap.String("prefix", prefix), zap.Int("count", resealed))
+		}
+	}
+
+	return nil
+}
+
+// GetSealedProto is GetProto for values stored with PutSealedProto.
+func GetSealedProto[T proto.Message](s *Store, key string) (T, error) {
+	var msg T
+	err := s.View(func(bkt storage.Bucket) error {
+		var err error
+		msg, err = GetBucketSealedProto[T](bkt, key)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return msg, nil
+}
+
+// PutSealedProto is PutProto for messages that hold secrets. The message is encrypted like PutSealed.
+func PutSealedProto(s *Store, key string, msg proto.Message) error {
+	return s.Transaction(func(bkt storage.Bucket) error {
+		return PutBucketSealedProto(bkt, key, msg)
+	})
+}
+
+// GetBucketSealedProto is GetSealedProto for use with the bucket passed to Store.Transaction or Store.View.
+func GetBucketSealedProto[T proto.Message](bkt storage.Bucket, key string) (T, error) {
+	val, err := GetBucketSealed(bkt, key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return decodeProto[T](key, val)
+}
+
+// PutBucketSealedProto is PutSealedProto for use with the bucket passed to Store.Transaction.
+func PutBucketSealedProto(bkt storage.Bucket, key string, msg proto.Message) error {
+	val, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return PutBucketSealed(bkt, key, val)
+}
@@ -0,0 +1,212 @@
+package quadlek
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	v1 "github.com/jirwin/quadlek/pb/quadlek/plugins/spotify/v1"
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func testSealingKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func getRaw(t *testing.T, store *Store, key string) []byte {
+	var raw []byte
+	require.NoError(t, store.Get(key, func(val []byte) error {
+		raw = append([]byte{}, val...)
+		return nil
+	}))
+	return raw
+}
+
+func TestNewSealer(t *testing.T) {
+	s, err := newSealer(SealingConfig{})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	_, err = newSealer(SealingConfig{Key: "not base64"})
+	require.Error(t, err)
+
+	_, err = newSealer(SealingConfig{Key: base64.StdEncoding.EncodeToString([]byte("short"))})
+	require.Error(t, err)
+
+	_, err = newSealer(SealingConfig{OldKeys: map[string]string{"old": testSealingKey(1)}})
+	require.Error(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(testSealingKey(1)+"\n"), 0600))
+	s, err = newSealer(SealingConfig{KeyId: "file", KeyFile: keyFile})
+	require.NoError(t, err)
+	require.Equal(t, "file", s.keyId)
+}
+
+func TestStoreSealed(t *testing.T) {
+	sealer, err := newSealer(SealingConfig{KeyId: "k1", Key: testSealingKey(1)})
+	require.NoError(t, err)
+	b := &Bot{storage: storage.NewMemory(), sealer: sealer}
+	store := b.getStore("spotify")
+
+	require.NoError(t, store.PutSealed("secret", []byte("hunter2")))
+
+	raw := getRaw(t, store, "secret")
+	require.True(t, isSealed(raw))
+	require.NotContains(t, string(raw), "hunter2")
+
+	val, err := store.GetSealed("secret")
+	require.NoError(t, err)
+	require.Equal(t, []byte("hunter2"), val)
+
+	val, err = store.GetSealed("missing")
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	// Sealed values are bound to their key and plugin.
+	require.NoError(t, store.Update("moved", raw))
+	_, err = store.GetSealed("moved")
+	require.Error(t, err)
+	require.NoError(t, b.getStore("github").Update("secret", raw))
+	_, err = b.getStore("github").GetSealed("secret")
+	require.Error(t, err)
+
+	// Values stored before they were sealed are read as they are.
+	require.NoError(t, store.Update("legacy", []byte("plain")))
+	val, err = store.GetSealed("legacy")
+	require.NoError(t, err)
+	require.Equal(t, []byte("plain"), val)
+
+	token := &v1.AuthToken{Token: &v1.Token{AccessToken: "access", RefreshToken: "refresh"}}
+	require.NoError(t, PutSealedProto(store, "authtoken-U1", token))
+	got, err := GetSealedProto[*v1.AuthToken](store, "authtoken-U1")
+	require.NoError(t, err)
+	require.Equal(t, "refresh", got.Token.RefreshToken)
+
+	// Without a key values are stored as they are, but sealed values can't be opened.
+	unsealed := &Bot{storage: storage.NewMemory()}
+	require.NoError(t, unsealed.getStore("spotify").PutSealed("secret", []byte("hunter2")))
+	require.Equal(t, []byte("hunter2"), getRaw(t, unsealed.getStore("spotify"), "secret"))
+	require.NoError(t, unsealed.getStore("spotify").Update("sealed", raw))
+	_, err = unsealed.getStore("spotify").GetSealed("sealed")
+	require.ErrorIs(t, err, ErrNoSealingKey)
+}
+
+func TestReseal(t *testing.T) {
+	oldSealer, err := newSealer(SealingConfig{KeyId: "k1", Key: testSealingKey(1)})
+	require.NoError(t, err)
+	backend := storage.NewMemory()
+	b := &Bot{storage: backend, sealer: oldSealer}
+	store := b.getStore("spotify")
+
+	require.NoError(t, store.Update("authtoken-U1", []byte("legacy")))
+	require.NoError(t, store.PutSealed("authtoken-U2", []byte("old key")))
+	require.NoError(t, store.PutWithTTL("authtoken-U3", []byte("expiring"), time.Hour))
+	require.NoError(t, store.Update("other", []byte("plain")))
+
+	newSealer, err := newSealer(SealingConfig{
+		KeyId:   "k2",
+		Key:     testSealingKey(2),
+		OldKeys: map[string]string{"k1": testSealingKey(1)},
+	})
+	require.NoError(t, err)
+	b = &Bot{storage: backend, sealer: newSealer}
+	store = b.getStore("spotify")
+
+	resealed, err := store.Reseal("authtoken-")
+	require.NoError(t, err)
+	require.Equal(t, 3, resealed)
+
+	for key, want := range map[string]string{"authtoken-U1": "legacy", "authtoken-U2": "old key", "authtoken-U3": "expiring"} {
+		raw := getRaw(t, store, key)
+		require.True(t, newSealer.current(raw), key)
+
+		val, err := store.GetSealed(key)
+		require.NoError(t, err)
+		require.Equal(t, want, string(val))
+	}
+
+	expiresAt, err := store.ExpiresAt("authtoken-U3")
+	require.NoError(t, err)
+	require.False(t, expiresAt.IsZero())
+
+	raw := getRaw(t, store, "other")
+	require.Equal(t, []byte("plain"), raw)
+
+	resealed, err = store.Reseal("authtoken-")
+	require.NoError(t, err)
+	require.Zero(t, resealed)
+}
+
+func TestExportSealed(t *testing.T) {
+	sealer, err := newSealer(SealingConfig{KeyId: "k1", Key: testSealingKey(1)})
+	require.NoError(t, err)
+	b := &Bot{storage: storage.NewMemory(), Log: zap.NewNop(), config: defaultConfig(), sealer: sealer}
+	b.protoKeys.register("spotify", []ProtoKey{{Prefix: "authtoken-", Message: &v1.AuthToken{}}})
+
+	token := &v1.AuthToken{Token: &v1.Token{AccessToken: "access"}}
+	require.NoError(t, PutSealedProto(b.getStore("spotify"), "authtoken-U1", token))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, b.Export(buf))
+	require.NotContains(t, buf.String(), "access")
+
+	restored := &Bot{storage: storage.NewMemory(), Log: zap.NewNop(), config: defaultConfig(), sealer: sealer}
+	require.NoError(t, restored.Import(bytes.NewReader(buf.Bytes())))
+
+	got, err := GetSealedProto[*v1.AuthToken](restored.getStore("spotify"), "authtoken-U1")
+	require.NoError(t, err)
+	require.Equal(t, "access", got.Token.AccessToken)
+}
+
+func TestSealedKeysWithoutKey(t *testing.T) {
+	backend := storage.NewMemory()
+	store := (&Bot{storage: backend}).getStore("spotify")
+	require.NoError(t, store.Update("authtoken-U1", []byte("legacy")))
+
+	plugin := func() Plugin {
+		return MakePlugin("spotify", nil, nil, nil, nil, nil,
+			WithSealedKeys("authtoken-"),
+			WithMigrations(Migration{Version: 1, Migrate: func(bkt storage.Bucket) error {
+				_, err := ResealBucket(bkt, "authtoken-")
+				return err
+			}}),
+		)
+	}
+
+	// Upgrading without a sealing key keeps the plugin working with its tokens unsealed.
+	b, err := NewBot(context.Background(), WithApiKey("xoxb-test"), WithSigningSecret("secret"), WithStorage(backend), WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	require.NoError(t, b.RegisterPlugin(plugin()))
+	require.NoError(t, b.getStore("spotify").PutSealed("authtoken-U2", []byte("new")))
+	require.Equal(t, []byte("legacy"), getRaw(t, b.getStore("spotify"), "authtoken-U1"))
+	b.Stop()
+
+	// Once a key is configured the tokens stored without one are sealed.
+	b, err = NewBot(context.Background(),
+		WithApiKey("xoxb-test"),
+		WithSigningSecret("secret"),
+		WithStorage(backend),
+		WithLogger(zap.NewNop()),
+		WithSealingKey("k1", bytes.Repeat([]byte{1}, 32), nil),
+	)
+	require.NoError(t, err)
+	defer b.Stop()
+	require.NoError(t, b.RegisterPlugin(plugin()))
+
+	store = b.getStore("spotify")
+	for key, want := range map[string]string{"authtoken-U1": "legacy", "authtoken-U2": "new"} {
+		require.True(t, b.sealer.current(getRaw(t, store, key)), key)
+
+		val, err := store.GetSealed(key)
+		require.NoError(t, err)
+		require.Equal(t, want, string(val))
+	}
+}
@@ -0,0 +1,122 @@
+package quadlek
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultSignatureMaxAge is how far a signed request's timestamp may be from now, as recommended by Slack.
+const defaultSignatureMaxAge = 5 * time.Minute
+
+// SignatureVerifier verifies HMAC-SHA256 request signatures in the format Slack signs its requests with:
+//
+//	v0=hex(hmac_sha256(secret, "v0:" + timestamp + ":" + body))
+//
+// Requests with a timestamp further than MaxAge from now are rejected, so captured requests can't be replayed.
+type SignatureVerifier struct {
+	// Secrets are the signing secrets that are accepted. Configure both the old and the new secret while rotating it.
+	Secrets []string
+	// MaxAge is how far a request's timestamp may be from now. Defaults to five minutes.
+	MaxAge time.Duration
+	// SignatureHeader is the header that holds the signature. Defaults to X-Slack-Signature.
+	SignatureHeader string
+	// TimestampHeader is the header that holds the unix timestamp the request was signed at. Defaults to X-Slack-Request-Timestamp.
+	TimestampHeader string
+
+	now func() time.Time
+}
+
+// Verify checks the signature of r. The body is read and replaced, so it can still be read by the handler.
+func (v *SignatureVerifier) Verify(r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("%w: error reading body: %v", InvalidRequestSignature, err)
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	signatureHeader, timestampHeader := v.SignatureHeader, v.TimestampHeader
+	if signatureHeader == "" {
+		signatureHeader = "X-Slack-Signature"
+	}
+	if timestampHeader == "" {
+		timestampHeader = "X-Slack-Request-Timestamp"
+	}
+
+	ts := r.Header.Get(timestampHeader)
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", InvalidRequestSignature, ts)
+	}
+	maxAge := v.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultSignatureMaxAge
+	}
+	now := time.Now
+	if v.now != nil {
+		now = v.now
+	}
+	age := now().Sub(time.Unix(unix, 0))
+	if age > maxAge || age < -maxAge {
+		return fmt.Errorf("%w: timestamp is %s from now", InvalidRequestSignature, age.Round(time.Second))
+	}
+
+	signature := []byte(r.Header.Get(signatureHeader))
+	for _, secret := range v.Secrets {
+		if secret == "" {
+			continue
+		}
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write([]byte("v0:" + ts + ":"))
+		h.Write(body)
+		expected := []byte("v0=" + hex.EncodeToString(h.Sum(nil)))
+		if hmac.Equal(signature, expected) {
+			return nil
+		}
+	}
+
+	return InvalidRequestSignature
+}
+
+// Middleware returns a handler that rejects requests that fail Verify with a 401, and passes the rest to next.
+func (v *SignatureVerifier) Middleware(log *zap.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := v.Verify(r)
+		if err != nil {
+			log.Warn("rejected request", zap.String("path", r.URL.Path), zap.String("remote_addr", r.RemoteAddr), zap.Error(err))
+			http.Error(w, "invalid request signature", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithSignatureVerifier makes a webhook reject requests that aren't signed with one of verifier's secrets.
+// Callers sign requests the same way Slack does, see SignatureVerifier.
+func WithSignatureVerifier(verifier *SignatureVerifier) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.verifier = verifier
+	}
+}
+
+// slackVerifier returns the verifier for requests from Slack.
+func (b *Bot) slackVerifier() *SignatureVerifier {
+	return &SignatureVerifier{
+		Secrets: append([]string{b.config.SigningSecret}, b.config.SigningSecrets...),
+		MaxAge:  b.config.SignatureMaxAge,
+	}
+}
+
+// ValidateSlackRequest validates the signature and timestamp of a request from Slack
+func (b *Bot) ValidateSlackRequest(r *http.Request) error {
+	return b.slackVerifier().Verify(r)
+}
@@ -0,0 +1,111 @@
+package quadlek
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// signedRequest returns a request with body signed with secret at ts, the way Slack signs requests.
+func signedRequest(target, body, secret string, ts time.Time) *http.Request {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte("v0:" + unix + ":" + body))
+
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", unix)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(h.Sum(nil)))
+	return r
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	now := time.Now()
+	v := &SignatureVerifier{
+		Secrets: []string{"new", "old"},
+		now:     func() time.Time { return now },
+	}
+
+	for _, tc := range []struct {
+		name   string
+		secret string
+		ts     time.Time
+		valid  bool
+	}{
+		{name: "current secret", secret: "new", ts: now, valid: true},
+		{name: "rotated secret", secret: "old", ts: now, valid: true},
+		{name: "unknown secret", secret: "other", ts: now},
+		{name: "small skew", secret: "new", ts: now.Add(-4 * time.Minute), valid: true},
+		{name: "replayed", secret: "new", ts: now.Add(-6 * time.Minute)},
+		{name: "future", secret: "new", ts: now.Add(6 * time.Minute)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := signedRequest("/slack/event", `{"type": "event_callback"}`, tc.secret, tc.ts)
+			err := v.Verify(r)
+			if !tc.valid {
+				require.True(t, errors.Is(err, InvalidRequestSignature))
+				return
+			}
+			require.NoError(t, err)
+
+			// The body can still be read after it is verified.
+			body := make([]byte, 64)
+			n, _ := r.Body.Read(body)
+			require.Equal(t, `{"type": "event_callback"}`, string(body[:n]))
+		})
+	}
+
+	r := signedRequest("/slack/event", "{}", "new", now)
+	r.Header.Set("X-Slack-Request-Timestamp", "yesterday")
+	require.Error(t, v.Verify(r))
+
+	// Empty secrets never match an unsigned request.
+	r = httptest.NewRequest(http.MethodPost, "/slack/event", strings.NewReader("{}"))
+	r.Header.Set("X-Slack-Request-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	require.Error(t, (&SignatureVerifier{Secrets: []string{""}}).Verify(r))
+}
+
+func TestSignatureMiddleware(t *testing.T) {
+	v := &SignatureVerifier{Secrets: []string{"secret"}}
+	called := false
+	h := v.Middleware(zap.NewNop(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedRequest("/slack/command", "text=hi", "wrong", time.Now()))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.False(t, called)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, signedRequest("/slack/command", "text=hi", "secret", time.Now()))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, called)
+}
+
+func TestPluginWebhookSignature(t *testing.T) {
+	b := &Bot{
+		Log: zap.NewNop(),
+		webhooks: map[string]*registeredWebhook{
+			"deploy": {
+				PluginId: "deploys",
+				Webhook:  MakeWebhook("deploy", nil, WithSignatureVerifier(&SignatureVerifier{Secrets: []string{"secret"}})),
+			},
+		},
+	}
+
+	r := mux.SetURLVars(signedRequest("/slack/plugin/deploy", "{}", "wrong", time.Now()), map[string]string{"webhook-name": "deploy"})
+	w := httptest.NewRecorder()
+	b.handlePluginWebhook(w, r)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
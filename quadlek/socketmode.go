@@ -0,0 +1,157 @@
+package quadlek
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+	"go.uber.org/zap"
+)
+
+// socketModeBackoff is how long the Bot first waits before reconnecting after a socket mode connection fails.
+var socketModeBackoff = time.Second
+
+// socketModeConn is the part of socketmode.Client that the Bot uses, so tests can drive it with their own events.
+type socketModeConn interface {
+	RunContext(ctx context.Context) error
+	Ack(req socketmode.Request, payload ...interface{})
+}
+
+// SocketModeClient connects to Slack using Socket Mode and dispatches the events, slash commands, and interactions
+// it receives exactly as the webhook server would. This allows the Bot to run without any public ingress.
+//
+// The connection is re-established with an exponential backoff whenever it drops, until the Bot is stopped.
+func (b *Bot) SocketModeClient() {
+	client := socketmode.New(b.api)
+	b.runSocketMode(client, client.Events)
+}
+
+// runSocketMode runs client until the Bot is stopped, handling the events it receives on events.
+func (b *Bot) runSocketMode(client socketModeConn, events <-chan socketmode.Event) {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = socketModeBackoff
+	bo.MaxInterval = time.Minute
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+
+	connected := make(chan struct{}, 1)
+	go b.handleSocketModeEvents(client, events, connected)
+
+	for {
+		err := client.RunContext(b.ctx)
+		if b.ctx.Err() != nil {
+			b.Log.Info("Shut down socket mode client")
+			return
+		}
+
+		// Only back off further if we never managed to connect since the last failure.
+		select {
+		case <-connected:
+			bo.Reset()
+		default:
+		}
+
+		wait := bo.NextBackOff()
+		b.Log.Error("socket mode connection failed", zap.Error(err), zap.Duration("retry_in", wait))
+
+		select {
+		case <-time.After(wait):
+		case <-b.ctx.Done():
+			b.Log.Info("Shut down socket mode client")
+			return
+		}
+	}
+}
+
+// handleSocketModeEvents acknowledges each envelope received over the socket and dispatches its payload.
+// A notification is sent on connected whenever a connection is established.
+func (b *Bot) handleSocketModeEvents(client socketModeConn, events <-chan socketmode.Event, connected chan<- struct{}) {
+	for {
+		select {
+		case evt := <-events:
+			switch evt.Type {
+			case socketmode.EventTypeConnecting:
+				b.Log.Info("connecting to slack with socket mode")
+
+			case socketmode.EventTypeConnected:
+				b.Log.Info("connected to slack with socket mode")
+				select {
+				case connected <- struct{}{}:
+				default:
+				}
+
+			case socketmode.EventTypeConnectionError, socketmode.EventTypeInvalidAuth:
+				b.Log.Error("socket mode connection error", zap.Any("data", evt.Data))
+
+			case socketmode.EventTypeEventsAPI:
+				ev, ok := evt.Data.(slackevents.EventsAPIEvent)
+				if !ok {
+					b.Log.Error("unexpected data type for socket mode event")
+					continue
+				}
+				client.Ack(*evt.Request)
+
+				if ev.Type == slackevents.CallbackEvent && !b.duplicateEvent(ev, evt.Request.RetryAttempt, evt.Request.RetryReason) {
+					b.record(RecordedEvent, evt.Request.Payload)
+					ctx, span := b.startSocketModeSpan(evt.Request)
+					b.dispatchEvent(ctx, ev)
+					span.End()
+				}
+
+			case socketmode.EventTypeSlashCommand:
+				sc, ok := evt.Data.(slack.SlashCommand)
+				if !ok {
+					b.Log.Error("unexpected data type for socket mode slash command")
+					continue
+				}
+				b.record(RecordedCommand, evt.Request.Payload)
+
+				// Waiting on the plugin must not hold up the rest of the events.
+				go func(req socketmode.Request) {
+					ctx, span := b.startSocketModeSpan(&req)
+					defer span.End()
+
+					resp, replied := b.runSlashCommand(&slashCommand{
+						Token:       sc.Token,
+						TeamId:      sc.TeamID,
+						TeamDomain:  sc.TeamDomain,
+						ChannelId:   sc.ChannelID,
+						ChannelName: sc.ChannelName,
+						UserId:      sc.UserID,
+						UserName:    sc.UserName,
+						Command:     sc.Command,
+						Text:        sc.Text,
+						ResponseUrl: sc.ResponseURL,
+						ctx:         ctx,
+					})
+					if !replied || resp == nil {
+						client.Ack(req)
+						return
+					}
+
+					prepareSlashCommandResp(resp)
+					client.Ack(req, resp)
+				}(*evt.Request)
+
+			case socketmode.EventTypeInteractive:
+				cb, ok := evt.Data.(slack.InteractionCallback)
+				if !ok {
+					b.Log.Error("unexpected data type for socket mode interaction")
+					continue
+				}
+				client.Ack(*evt.Request)
+
+				b.record(RecordedInteraction, evt.Request.Payload)
+				ctx, span := b.startSocketModeSpan(evt.Request)
+				b.interactionChannel <- &interactionRequest{ctx: ctx, cb: &cb}
+				span.End()
+			}
+
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
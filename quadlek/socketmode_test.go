@@ -0,0 +1,244 @@
+package quadlek
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeSocketModeAck is an envelope acknowledged with fakeSocketMode.
+type fakeSocketModeAck struct {
+	req     socketmode.Request
+	payload []interface{}
+}
+
+// fakeSocketMode is a socketModeConn whose events are sent by the test, and whose acks are received by it.
+type fakeSocketMode struct {
+	events chan socketmode.Event
+	acks   chan fakeSocketModeAck
+	run    func(ctx context.Context) error
+}
+
+func newFakeSocketMode() *fakeSocketMode {
+	return &fakeSocketMode{
+		events: make(chan socketmode.Event),
+		acks:   make(chan fakeSocketModeAck),
+	}
+}
+
+// RunContext implements socketModeConn
+func (f *fakeSocketMode) RunContext(ctx context.Context) error {
+	if f.run == nil {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	return f.run(ctx)
+}
+
+// Ack implements socketModeConn
+func (f *fakeSocketMode) Ack(req socketmode.Request, payload ...interface{}) {
+	f.acks <- fakeSocketModeAck{req: req, payload: payload}
+}
+
+func (f *fakeSocketMode) send(t *testing.T, evt socketmode.Event) {
+	select {
+	case f.events <- evt:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event wasn't received: %s", evt.Type)
+	}
+}
+
+func (f *fakeSocketMode) ack(t *testing.T) fakeSocketModeAck {
+	select {
+	case ack := <-f.acks:
+		return ack
+	case <-time.After(5 * time.Second):
+		t.Fatal("envelope wasn't acknowledged")
+		return fakeSocketModeAck{}
+	}
+}
+
+func socketModeTeamJoin(t *testing.T, envelopeId, eventId, userName string) socketmode.Event {
+	payload := fmt.Sprintf(`{"type": "event_callback", "event_id": "%s", "event": {"type": "team_join", "user": {"id": "U1", "name": "%s"}}}`, eventId, userName)
+	ev, err := slackevents.ParseEvent(json.RawMessage(payload), slackevents.OptionNoVerifyToken())
+	require.NoError(t, err)
+
+	return socketmode.Event{
+		Type:    socketmode.EventTypeEventsAPI,
+		Data:    ev,
+		Request: &socketmode.Request{Type: "events_api", EnvelopeID: envelopeId, Payload: json.RawMessage(payload)},
+	}
+}
+
+func socketModeBot(ctx context.Context) *Bot {
+	return &Bot{
+		Log:                zap.NewNop(),
+		ctx:                ctx,
+		directory:          newDirectory(zap.NewNop(), nil),
+		events:             newEventDeduper(eventDedupeSize, eventDedupeTTL),
+		cmdChannel:         make(chan *slashCommand),
+		interactionChannel: make(chan *interactionRequest),
+	}
+}
+
+func TestSocketModeEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := socketModeBot(ctx)
+	client := newFakeSocketMode()
+	go b.handleSocketModeEvents(client, client.events, make(chan struct{}, 1))
+
+	userName := func() string {
+		b.directory.mtx.RLock()
+		defer b.directory.mtx.RUnlock()
+		return b.directory.users["U1"].Name
+	}
+
+	// The envelope is acknowledged before the event is dispatched.
+	client.send(t, socketModeTeamJoin(t, "env1", "Ev1", "jirwin"))
+	ack := client.ack(t)
+	require.Equal(t, "env1", ack.req.EnvelopeID)
+	require.Empty(t, ack.payload)
+	require.Empty(t, userName())
+	require.Eventually(t, func() bool {
+		return userName() == "jirwin"
+	}, time.Second, time.Millisecond)
+
+	// A redelivery is acknowledged, but not dispatched again.
+	client.send(t, socketModeTeamJoin(t, "env2", "Ev1", "redelivered"))
+	require.Equal(t, "env2", client.ack(t).req.EnvelopeID)
+	client.send(t, socketModeTeamJoin(t, "env3", "Ev2", "jirwin"))
+	require.Equal(t, "env3", client.ack(t).req.EnvelopeID)
+	require.Equal(t, uint64(1), b.events.duplicateCount())
+	require.Equal(t, "jirwin", userName())
+}
+
+func TestSocketModeSlashCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := socketModeBot(ctx)
+	client := newFakeSocketMode()
+	go b.handleSocketModeEvents(client, client.events, make(chan struct{}, 1))
+
+	slashCommand := func(envelopeId string) socketmode.Event {
+		return socketmode.Event{
+			Type:    socketmode.EventTypeSlashCommand,
+			Data:    slack.SlashCommand{Command: "/echo", Text: "hi", UserID: "U1", ChannelID: "C1", ResponseURL: "https://example.com/respond"},
+			Request: &socketmode.Request{Type: "slash_commands", EnvelopeID: envelopeId},
+		}
+	}
+
+	// The plugin's reply is sent with the ack.
+	client.send(t, slashCommand("env1"))
+	cmd := <-b.cmdChannel
+	require.Equal(t, "/echo", cmd.Command)
+	require.Equal(t, "hi", cmd.Text)
+	require.Equal(t, "U1", cmd.UserId)
+	require.Equal(t, "C1", cmd.ChannelId)
+	require.Equal(t, "https://example.com/respond", cmd.ResponseUrl)
+	cmd.responseChan <- &CommandResp{Text: "hi", InChannel: true}
+
+	ack := client.ack(t)
+	require.Equal(t, "env1", ack.req.EnvelopeID)
+	require.Len(t, ack.payload, 1)
+	resp, ok := ack.payload[0].(*CommandResp)
+	require.True(t, ok)
+	require.Equal(t, "hi", resp.Text)
+	require.Equal(t, "in_channel", resp.ResponseType)
+
+	// A nil reply is acknowledged without a payload.
+	client.send(t, slashCommand("env2"))
+	cmd = <-b.cmdChannel
+	cmd.responseChan <- nil
+	ack = client.ack(t)
+	require.Equal(t, "env2", ack.req.EnvelopeID)
+	require.Empty(t, ack.payload)
+}
+
+func TestSocketModeInteraction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := socketModeBot(ctx)
+	client := newFakeSocketMode()
+	go b.handleSocketModeEvents(client, client.events, make(chan struct{}, 1))
+
+	client.send(t, socketmode.Event{
+		Type:    socketmode.EventTypeInteractive,
+		Data:    slack.InteractionCallback{Type: slack.InteractionTypeBlockActions, CallbackID: "vote"},
+		Request: &socketmode.Request{Type: "interactive", EnvelopeID: "env1"},
+	})
+
+	// The envelope is acknowledged before the interaction is dispatched.
+	require.Equal(t, "env1", client.ack(t).req.EnvelopeID)
+	select {
+	case ir := <-b.interactionChannel:
+		require.Equal(t, "vote", ir.cb.CallbackID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("interaction wasn't dispatched")
+	}
+}
+
+func TestSocketModeReconnectBackoff(t *testing.T) {
+	orig := socketModeBackoff
+	socketModeBackoff = time.Millisecond
+	defer func() { socketModeBackoff = orig }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := socketModeBot(ctx)
+	core, logs := observer.New(zapcore.ErrorLevel)
+	b.Log = zap.New(core)
+
+	// The connection fails without connecting several times, then connects once before failing again.
+	const failures = 7
+	client := newFakeSocketMode()
+	runs := 0
+	client.run = func(ctx context.Context) error {
+		runs++
+		switch {
+		case runs == failures+1:
+			client.events <- socketmode.Event{Type: socketmode.EventTypeConnected}
+			// The events are handled in order, so the connection has been noted once this is received.
+			client.events <- socketmode.Event{Type: socketmode.EventTypeConnecting}
+		case runs > failures+1:
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return errors.New("connection dropped")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.runSocketMode(client, client.events)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("socket mode client didn't stop")
+	}
+
+	var waits []time.Duration
+	for _, entry := range logs.FilterMessage("socket mode connection failed").All() {
+		waits = append(waits, entry.ContextMap()["retry_in"].(time.Duration))
+	}
+	require.Len(t, waits, failures+1)
+
+	// The backoff grows while the connection keeps failing, and is reset once it connects. The waits are
+	// randomized by up to half of the interval.
+	require.Greater(t, waits[failures-1], 3*socketModeBackoff/2)
+	require.LessOrEqual(t, waits[failures], 3*socketModeBackoff/2)
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltRootBucket is the bolt bucket that holds a nested bolt bucket for each Bucket.
+const boltRootBucket = "plugins"
+
+// Bolt is a Backend that stores everything in a single bolt file.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens, or creates, the bolt database at path. timeout is how long to wait to obtain the lock on the file.
+func OpenBolt(path string, timeout time.Duration) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+// DB returns the underlying bolt database, for code that still works with bolt buckets directly.
+func (b *Bolt) DB() *bolt.DB {
+	return b.db
+}
+
+// View implements Backend
+func (b *Bolt) View(fn func(tx Tx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+// Update implements Backend
+func (b *Bolt) Update(fn func(tx Tx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+// Close implements Backend
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// boltTx is a Tx for a bolt transaction.
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// Bucket implements Tx. Buckets are created the first time they are used in a read-write transaction.
+func (t *boltTx) Bucket(name string) (Bucket, error) {
+	if !t.tx.Writable() {
+		rootBkt := t.tx.Bucket([]byte(boltRootBucket))
+		if rootBkt == nil {
+			return &boltBucket{}, nil
+		}
+		return &boltBucket{bkt: rootBkt.Bucket([]byte(name))}, nil
+	}
+
+	rootBkt, err := t.tx.CreateBucketIfNotExists([]byte(boltRootBucket))
+	if err != nil {
+		return nil, err
+	}
+
+	bkt, err := rootBkt.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltBucket{bkt: bkt}, nil
+}
+
+// Buckets implements Tx
+func (t *boltTx) Buckets() ([]string, error) {
+	rootBkt := t.tx.Bucket([]byte(boltRootBucket))
+	if rootBkt == nil {
+		return nil, nil
+	}
+
+	var names []string
+	err := rootBkt.ForEach(func(k, v []byte) error {
+		// Nested buckets have nil values.
+		if v == nil {
+			names = append(names, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// boltBucket is a Bucket for a bolt bucket. bkt is nil for buckets that don't exist yet in read-only transactions.
+type boltBucket struct {
+	bkt *bolt.Bucket
+}
+
+// Get implements Bucket
+func (b *boltBucket) Get(key []byte) ([]byte, error) {
+	if b.bkt == nil {
+		return nil, nil
+	}
+
+	return b.bkt.Get(key), nil
+}
+
+// Put implements Bucket
+func (b *boltBucket) Put(key, value []byte) error {
+	if b.bkt == nil || !b.bkt.Tx().Writable() {
+		return ErrReadOnly
+	}
+
+	return b.bkt.Put(key, value)
+}
+
+// Delete implements Bucket
+func (b *boltBucket) Delete(key []byte) error {
+	if b.bkt == nil || !b.bkt.Tx().Writable() {
+		return ErrReadOnly
+	}
+
+	return b.bkt.Delete(key)
+}
+
+// Scan implements Bucket
+func (b *boltBucket) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	return b.ScanWith(ScanOptions{Prefix: prefix}, fn)
+}
+
+// ScanWith implements Bucket. It seeks the cursor straight to the first key of the scan.
+func (b *boltBucket) ScanWith(opts ScanOptions, fn func(key, value []byte) error) error {
+	if b.bkt == nil {
+		return nil
+	}
+
+	c := b.bkt.Cursor()
+	next := c.Next
+	var k, v []byte
+	if opts.Reverse {
+		next = c.Prev
+
+		// Start at the last key before the end of the prefix, or before After if that comes first.
+		end := prefixEnd(opts.Prefix)
+		if opts.After != nil && (end == nil || bytes.Compare(opts.After, end) < 0) {
+			end = opts.After
+		}
+		if end != nil {
+			k, v = c.Seek(end)
+		}
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+	} else {
+		start := opts.Prefix
+		if opts.After != nil && bytes.Compare(opts.After, start) > 0 {
+			start = opts.After
+		}
+		k, v = c.Seek(start)
+	}
+
+	for ; k != nil && bytes.HasPrefix(k, opts.Prefix); k, v = next() {
+		// Nested bolt buckets have nil values, they aren't part of the Bucket.
+		if v == nil || !opts.after(k) {
+			continue
+		}
+
+		err := fn(k, v)
+		if err == ErrStop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory is a Backend that keeps everything in memory. Nothing is persisted, so it is mostly useful for tests.
+type Memory struct {
+	mtx     sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemory returns an empty Memory backend.
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]map[string][]byte),
+	}
+}
+
+// View implements Backend
+func (m *Memory) View(fn func(tx Tx) error) error {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	return fn(&memoryTx{buckets: m.buckets})
+}
+
+// Update implements Backend. Writes go to copies of the buckets, which replace the originals if fn succeeds.
+func (m *Memory) Update(fn func(tx Tx) error) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	tx := &memoryTx{
+		buckets:  make(map[string]map[string][]byte, len(m.buckets)),
+		writable: true,
+		copied:   make(map[string]bool),
+	}
+	for name, bkt := range m.buckets {
+		tx.buckets[name] = bkt
+	}
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	m.buckets = tx.buckets
+
+	return nil
+}
+
+// Close implements Backend
+func (m *Memory) Close() error {
+	return nil
+}
+
+// memoryTx is a Tx for the Memory backend.
+type memoryTx struct {
+	buckets  map[string]map[string][]byte
+	writable bool
+	// copied records the buckets that have been copied for writing in this transaction.
+	copied map[string]bool
+}
+
+// Bucket implements Tx
+func (t *memoryTx) Bucket(name string) (Bucket, error) {
+	return &memoryBucket{tx: t, name: name}, nil
+}
+
+// Buckets implements Tx
+func (t *memoryTx) Buckets() ([]string, error) {
+	names := make([]string, 0, len(t.buckets))
+	for name := range t.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// writeBucket returns the transaction's own copy of a bucket, making it on the first write.
+func (t *memoryTx) writeBucket(name string) (map[string][]byte, error) {
+	if !t.writable {
+		return nil, ErrReadOnly
+	}
+
+	if !t.copied[name] {
+		bkt := make(map[string][]byte, len(t.buckets[name])+1)
+		for k, v := range t.buckets[name] {
+			bkt[k] = v
+		}
+		t.buckets[name] = bkt
+		t.copied[name] = true
+	}
+
+	return t.buckets[name], nil
+}
+
+// memoryBucket is a Bucket for the Memory backend.
+type memoryBucket struct {
+	tx   *memoryTx
+	name string
+}
+
+// Get implements Bucket
+func (b *memoryBucket) Get(key []byte) ([]byte, error) {
+	return b.tx.buckets[b.name][string(key)], nil
+}
+
+// Put implements Bucket
+func (b *memoryBucket) Put(key, value []byte) error {
+	bkt, err := b.tx.writeBucket(b.name)
+	if err != nil {
+		return err
+	}
+	bkt[string(key)] = append([]byte{}, value...)
+
+	return nil
+}
+
+// Delete implements Bucket
+func (b *memoryBucket) Delete(key []byte) error {
+	bkt, err := b.tx.writeBucket(b.name)
+	if err != nil {
+		return err
+	}
+	delete(bkt, string(key))
+
+	return nil
+}
+
+// Scan implements Bucket
+func (b *memoryBucket) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	return b.ScanWith(ScanOptions{Prefix: prefix}, fn)
+}
+
+// ScanWith implements Bucket
+func (b *memoryBucket) ScanWith(opts ScanOptions, fn func(key, value []byte) error) error {
+	bkt := b.tx.buckets[b.name]
+
+	keys := make([]string, 0, len(bkt))
+	for k := range bkt {
+		if strings.HasPrefix(k, string(opts.Prefix)) && opts.after([]byte(k)) {
+			keys = append(keys, k)
+		}
+	}
+	if opts.Reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	} else {
+		sort.Strings(keys)
+	}
+
+	for _, k := range keys {
+		err := fn([]byte(k), bkt[k])
+		if err == ErrStop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
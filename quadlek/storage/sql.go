@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// SQLite is a Backend that stores everything in a single table of a SQLite database.
+//
+// quadlek doesn't link a SQLite driver, so the caller opens the *sql.DB with the driver of their choice,
+// e.g. github.com/mattn/go-sqlite3 or modernc.org/sqlite.
+//
+// Transactions are SQLite's default deferred ones, so a View only takes a shared lock. Without a busy timeout,
+// reads and writes that overlap fail with SQLITE_BUSY, so the data source name should set one and enable WAL,
+// e.g. "quadlek.db?_busy_timeout=5000&_journal_mode=WAL" for go-sqlite3.
+type SQLite struct {
+	db *sql.DB
+}
+
+// NewSQLite creates the table used for storage if it doesn't exist, and returns a Backend that uses it.
+func NewSQLite(db *sql.DB) (*SQLite, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS quadlek_kv (
+		bucket TEXT NOT NULL,
+		key BLOB NOT NULL,
+		value BLOB NOT NULL,
+		PRIMARY KEY (bucket, key)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// View implements Backend
+func (s *SQLite) View(fn func(tx Tx) error) error {
+	return s.run(false, fn)
+}
+
+// Update implements Backend
+func (s *SQLite) Update(fn func(tx Tx) error) error {
+	return s.run(true, fn)
+}
+
+// run runs fn in a transaction, committing it if it is writable and fn succeeds.
+func (s *SQLite) run(writable bool, fn func(tx Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(&sqlTx{tx: tx, writable: writable})
+	if err != nil || !writable {
+		rbErr := tx.Rollback()
+		if err == nil {
+			err = rbErr
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Close implements Backend
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// sqlTx is a Tx for a SQL transaction.
+type sqlTx struct {
+	tx       *sql.Tx
+	writable bool
+}
+
+// Bucket implements Tx
+func (t *sqlTx) Bucket(name string) (Bucket, error) {
+	return &sqlBucket{tx: t, name: name}, nil
+}
+
+// Buckets implements Tx
+func (t *sqlTx) Buckets() ([]string, error) {
+	rows, err := t.tx.Query(`SELECT DISTINCT bucket FROM quadlek_kv ORDER BY bucket`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// sqlBucket is a Bucket for the rows of the table with the same bucket name.
+type sqlBucket struct {
+	tx   *sqlTx
+	name string
+}
+
+// Get implements Bucket
+func (b *sqlBucket) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := b.tx.tx.QueryRow(`SELECT value FROM quadlek_kv WHERE bucket = ? AND key = ?`, b.name, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Put implements Bucket
+func (b *sqlBucket) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return ErrReadOnly
+	}
+
+	_, err := b.tx.tx.Exec(`INSERT INTO quadlek_kv (bucket, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (bucket, key) DO UPDATE SET value = excluded.value`, b.name, key, value)
+	return err
+}
+
+// Delete implements Bucket
+func (b *sqlBucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return ErrReadOnly
+	}
+
+	_, err := b.tx.tx.Exec(`DELETE FROM quadlek_kv WHERE bucket = ? AND key = ?`, b.name, key)
+	return err
+}
+
+// sqlScanBatch is how many rows a scan reads at a time.
+const sqlScanBatch = 100
+
+// Scan implements Bucket
+func (b *sqlBucket) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	return b.ScanWith(ScanOptions{Prefix: prefix}, fn)
+}
+
+// ScanWith implements Bucket. Rows are read in batches before fn is called, so fn can use the bucket.
+func (b *sqlBucket) ScanWith(opts ScanOptions, fn func(key, value []byte) error) error {
+	// A nil prefix would be bound as NULL, which no key compares to.
+	prefix := opts.Prefix
+	if prefix == nil {
+		prefix = []byte{}
+	}
+
+	for {
+		kvs, err := b.scanBatch(prefix, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range kvs {
+			err = fn(row.key, row.value)
+			if err == ErrStop {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(kvs) < sqlScanBatch {
+			return nil
+		}
+		opts.After = kvs[len(kvs)-1].key
+	}
+}
+
+// sqlRow is a key and value read by a scan.
+type sqlRow struct {
+	key   []byte
+	value []byte
+}
+
+// scanBatch reads the next batch of rows of a scan.
+func (b *sqlBucket) scanBatch(prefix []byte, opts ScanOptions) ([]sqlRow, error) {
+	query := `SELECT key, value FROM quadlek_kv WHERE bucket = ? AND key >= ?`
+	args := []interface{}{b.name, prefix}
+	if end := prefixEnd(prefix); end != nil {
+		query += ` AND key < ?`
+		args = append(args, end)
+	}
+	if opts.After != nil {
+		if opts.Reverse {
+			query += ` AND key < ?`
+		} else {
+			query += ` AND key > ?`
+		}
+		args = append(args, opts.After)
+	}
+	if opts.Reverse {
+		query += ` ORDER BY key DESC`
+	} else {
+		query += ` ORDER BY key`
+	}
+	query += ` LIMIT ?`
+	args = append(args, sqlScanBatch)
+
+	rows, err := b.tx.tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var kvs []sqlRow
+	for rows.Next() {
+		var row sqlRow
+		err = rows.Scan(&row.key, &row.value)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, row)
+	}
+
+	return kvs, rows.Err()
+}
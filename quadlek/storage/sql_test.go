@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestSQLite(t *testing.T, name string) *SQLite {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), name)+"?_busy_timeout=5000&_journal_mode=WAL")
+	require.NoError(t, err)
+	backend, err := NewSQLite(db)
+	require.NoError(t, err)
+	return backend
+}
+
+func TestSQLite(t *testing.T) {
+	testBackend(t, openTestSQLite(t, "test.db"))
+	testScanWith(t, openTestSQLite(t, "scan.db"))
+}
+
+func TestSQLiteScanBatches(t *testing.T) {
+	backend := openTestSQLite(t, "batches.db")
+	defer backend.Close()
+
+	n := sqlScanBatch*2 + 5
+	err := backend.Update(func(tx Tx) error {
+		bkt, err := tx.Bucket("test")
+		require.NoError(t, err)
+		for i := 0; i < n; i++ {
+			require.NoError(t, bkt.Put([]byte(fmt.Sprintf("k:%04d", i)), []byte("old")))
+		}
+		// Putting a key again replaces its value.
+		return bkt.Put([]byte("k:0000"), []byte("new"))
+	})
+	require.NoError(t, err)
+
+	for _, reverse := range []bool{false, true} {
+		var keys []string
+		err = backend.Update(func(tx Tx) error {
+			bkt, err := tx.Bucket("test")
+			require.NoError(t, err)
+			return bkt.ScanWith(ScanOptions{Prefix: []byte("k:"), Reverse: reverse}, func(k, v []byte) error {
+				keys = append(keys, string(k))
+				// The bucket can be written to while it is scanned.
+				return bkt.Put(k, v)
+			})
+		})
+		require.NoError(t, err)
+		require.Len(t, keys, n)
+		if reverse {
+			require.Equal(t, "k:0000", keys[n-1])
+		} else {
+			require.Equal(t, "k:0000", keys[0])
+			require.Equal(t, fmt.Sprintf("k:%04d", n-1), keys[n-1])
+		}
+	}
+
+	err = backend.View(func(tx Tx) error {
+		bkt, err := tx.Bucket("test")
+		require.NoError(t, err)
+		v, err := bkt.Get([]byte("k:0000"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("new"), v)
+		return nil
+	})
+	require.NoError(t, err)
+}
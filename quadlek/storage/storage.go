@@ -0,0 +1,83 @@
+// storage provides the transactional key value storage behind quadlek.Store.
+//
+// Data is kept in named buckets of sorted keys. Backends are available for bolt (the default, a single file),
+// SQLite through database/sql, and memory, which is useful in tests.
+package storage
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrReadOnly is returned when writing to a bucket in a read-only transaction.
+var ErrReadOnly = errors.New("storage: transaction is read only")
+
+// ErrStop can be returned by the func passed to a scan to end it early. The scan then returns nil.
+var ErrStop = errors.New("storage: stop scan")
+
+// Backend is a key value store with transactions.
+type Backend interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(tx Tx) error) error
+	// Update runs fn in a read-write transaction. If fn returns an error nothing it wrote is kept.
+	Update(fn func(tx Tx) error) error
+	Close() error
+}
+
+// Tx is a transaction.
+type Tx interface {
+	// Bucket returns the named bucket. Every bucket exists, a bucket that has never been written to is empty.
+	Bucket(name string) (Bucket, error)
+	// Buckets returns the names of the buckets that have been written to, sorted. It may include empty buckets.
+	Buckets() ([]string, error)
+}
+
+// Bucket is a set of keys and values, sorted by key.
+// Values returned by a bucket are only valid until the transaction ends, and must not be modified.
+type Bucket interface {
+	// Get returns the value of key, or nil if it doesn't exist.
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// Scan calls fn for each key that starts with prefix, in key order. fn must not modify the bucket.
+	Scan(prefix []byte, fn func(key, value []byte) error) error
+	// ScanWith is Scan with options to start part way through the keys, or to go in reverse.
+	ScanWith(opts ScanOptions, fn func(key, value []byte) error) error
+}
+
+// ScanOptions controls which keys a scan visits, and in what order.
+type ScanOptions struct {
+	// Prefix limits the scan to keys that start with it.
+	Prefix []byte
+	// After skips every key up to and including After, in the order of the scan. It is ignored if nil.
+	After []byte
+	// Reverse visits the keys in reverse order.
+	Reverse bool
+}
+
+// after returns true if key comes after opts.After in the order of the scan.
+func (opts ScanOptions) after(key []byte) bool {
+	if opts.After == nil {
+		return true
+	}
+
+	c := bytes.Compare(key, opts.After)
+	if opts.Reverse {
+		return c < 0
+	}
+	return c > 0
+}
+
+// prefixEnd returns the smallest key that is greater than every key starting with prefix,
+// or nil if there isn't one.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
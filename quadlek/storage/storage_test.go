@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testBackend(t *testing.T, backend Backend) {
+	defer backend.Close()
+
+	err := backend.View(func(tx Tx) error {
+		bkt, err := tx.Bucket("empty")
+		require.NoError(t, err)
+
+		v, err := bkt.Get([]byte("a"))
+		require.NoError(t, err)
+		require.Nil(t, v)
+		require.ErrorIs(t, bkt.Put([]byte("a"), []byte("1")), ErrReadOnly)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = backend.Update(func(tx Tx) error {
+		bkt, err := tx.Bucket("test")
+		require.NoError(t, err)
+		for _, k := range []string{"b:2", "a:1", "b:1", "c:1"} {
+			require.NoError(t, bkt.Put([]byte(k), []byte("v"+k)))
+		}
+		return bkt.Delete([]byte("c:1"))
+	})
+	require.NoError(t, err)
+
+	errRollback := errors.New("rollback")
+	err = backend.Update(func(tx Tx) error {
+		bkt, err := tx.Bucket("test")
+		require.NoError(t, err)
+		require.NoError(t, bkt.Put([]byte("b:3"), []byte("vb:3")))
+		require.NoError(t, bkt.Delete([]byte("a:1")))
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	err = backend.View(func(tx Tx) error {
+		bkt, err := tx.Bucket("test")
+		require.NoError(t, err)
+
+		v, err := bkt.Get([]byte("a:1"))
+		require.NoError(t, err)
+		require.Equal(t, "va:1", string(v))
+
+		var keys []string
+		err = bkt.Scan([]byte("b:"), func(k, v []byte) error {
+			require.Equal(t, "v"+string(k), string(v))
+			keys = append(keys, string(k))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"b:1", "b:2"}, keys)
+
+		keys = nil
+		err = bkt.Scan(nil, func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"a:1", "b:1", "b:2"}, keys)
+
+		names, err := tx.Buckets()
+		require.NoError(t, err)
+		require.Equal(t, []string{"test"}, names)
+
+		other, err := tx.Bucket("empty")
+		require.NoError(t, err)
+		return other.Scan(nil, func(k, v []byte) error {
+			t.Fatalf("unexpected key in empty bucket: %s", k)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+}
+
+func testScanWith(t *testing.T, backend Backend) {
+	defer backend.Close()
+
+	keys := []string{"a", "b:1", "b:2", "b:3", "b:4", "b\xff", "c"}
+	err := backend.Update(func(tx Tx) error {
+		bkt, err := tx.Bucket("test")
+		require.NoError(t, err)
+		for _, k := range keys {
+			require.NoError(t, bkt.Put([]byte(k), []byte("v")))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	scan := func(opts ScanOptions, limit int) []string {
+		var got []string
+		err := backend.View(func(tx Tx) error {
+			bkt, err := tx.Bucket("test")
+			require.NoError(t, err)
+			return bkt.ScanWith(opts, func(k, v []byte) error {
+				got = append(got, string(k))
+				if len(got) == limit {
+					return ErrStop
+				}
+				return nil
+			})
+		})
+		require.NoError(t, err)
+		return got
+	}
+
+	require.Equal(t, keys, scan(ScanOptions{}, -1))
+	require.Equal(t, []string{"b:1", "b:2"}, scan(ScanOptions{Prefix: []byte("b:")}, 2))
+	require.Equal(t, []string{"b:3", "b:4"}, scan(ScanOptions{Prefix: []byte("b:"), After: []byte("b:2")}, -1))
+	require.Equal(t, []string{"b:4", "b:3"}, scan(ScanOptions{Prefix: []byte("b:"), Reverse: true}, 2))
+	require.Equal(t, []string{"b:2", "b:1"}, scan(ScanOptions{Prefix: []byte("b:"), After: []byte("b:3"), Reverse: true}, -1))
+	require.Equal(t, []string{"c", "b\xff", "b:4"}, scan(ScanOptions{Reverse: true}, 3))
+	require.Equal(t, []string{"b\xff", "c"}, scan(ScanOptions{After: []byte("b:4")}, -1))
+	require.Empty(t, scan(ScanOptions{Prefix: []byte("b:"), After: []byte("a"), Reverse: true}, -1))
+	require.Empty(t, scan(ScanOptions{Prefix: []byte("d")}, -1))
+}
+
+func TestMemory(t *testing.T) {
+	testBackend(t, NewMemory())
+	testScanWith(t, NewMemory())
+}
+
+func TestBolt(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := OpenBolt(filepath.Join(dir, "test.db"), time.Second)
+	require.NoError(t, err)
+	testBackend(t, backend)
+
+	backend, err = OpenBolt(filepath.Join(dir, "scan.db"), time.Second)
+	require.NoError(t, err)
+	testScanWith(t, backend)
+}
@@ -1,43 +1,149 @@
 package quadlek
 
-import "github.com/boltdb/bolt"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// ErrNotBolt is returned by the deprecated bolt specific Store methods when the Bot isn't using bolt storage.
+var ErrNotBolt = errors.New("the bot isn't using bolt storage")
 
 // The Store struct provides a plugin a namespaced key value store for the plugin to use however it needs.
-// By default, keys are strings, and values are []byte. You can use UpdateRaw() if this doesn't fit your needs.
+// By default, keys are strings, and values are []byte. You can use Transaction() if this doesn't fit your needs.
 type Store struct {
-	db       *bolt.DB
+	backend  storage.Backend
 	pluginId string
+	sealer   *sealer
 }
 
-func (s *Store) ForEach(forEachFunc func(bucket *bolt.Bucket, key string, value []byte) error) error {
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		rootBkt := tx.Bucket([]byte("plugins"))
+// bucket returns the plugin's bucket in tx.
+func (s *Store) bucket(tx storage.Tx) (*storeBucket, error) {
+	bkt, err := tx.Bucket(s.pluginId)
+	if err != nil {
+		return nil, err
+	}
+	expiry, err := tx.Bucket(expiryBucket)
+	if err != nil {
+		return nil, err
+	}
 
-		pluginBkt := rootBkt.Bucket([]byte(s.pluginId))
-		err := pluginBkt.ForEach(func(k []byte, v []byte) error {
-			return forEachFunc(pluginBkt, string(k), v)
-		})
+	return &storeBucket{
+		Bucket:   bkt,
+		pluginId: s.pluginId,
+		expiry:   expiry,
+		sealer:   s.sealer,
+		now:      time.Now(),
+	}, nil
+}
+
+// Transaction runs updateFunc with the plugin's bucket in a read-write transaction.
+// If updateFunc returns an error nothing it wrote is kept.
+func (s *Store) Transaction(updateFunc func(bkt storage.Bucket) error) error {
+	return s.withPluginId(s.backend.Update(func(tx storage.Tx) error {
+		bkt, err := s.bucket(tx)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return updateFunc(bkt)
+	}))
+}
+
+// View runs viewFunc with the plugin's bucket in a read-only transaction.
+func (s *Store) View(viewFunc func(bkt storage.Bucket) error) error {
+	return s.withPluginId(s.backend.View(func(tx storage.Tx) error {
+		bkt, err := s.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		return viewFunc(bkt)
+	}))
+}
+
+// Range calls rangeFunc for each key that starts with prefix, in key order.
+func (s *Store) Range(prefix string, rangeFunc func(key string, value []byte) error) error {
+	return s.View(func(bkt storage.Bucket) error {
+		return bkt.Scan([]byte(prefix), func(k []byte, v []byte) error {
+			return rangeFunc(string(k), v)
+		})
+	})
+}
+
+// KeyValue is a key and its value, returned by Store.Scan and Store.ScanReverse.
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// Scan returns up to limit keys that start with prefix, in key order, beginning after startAfter unless it is empty.
+// next is the startAfter of the following page, or empty if there are no more keys. Scan only takes a read-only transaction.
+func (s *Store) Scan(prefix, startAfter string, limit int) (kvs []KeyValue, next string, err error) {
+	return s.scan(storage.ScanOptions{Prefix: []byte(prefix)}, startAfter, limit)
+}
+
+// ScanReverse is Scan in reverse key order, beginning before startBefore unless it is empty.
+func (s *Store) ScanReverse(prefix, startBefore string, limit int) (kvs []KeyValue, next string, err error) {
+	return s.scan(storage.ScanOptions{Prefix: []byte(prefix), Reverse: true}, startBefore, limit)
+}
+
+// scan returns a page of keys.
+func (s *Store) scan(opts storage.ScanOptions, cursor string, limit int) ([]KeyValue, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid scan limit %d", limit)
+	}
+	if cursor != "" {
+		opts.After = []byte(cursor)
+	}
+
+	var kvs []KeyValue
+	more := false
+	err := s.View(func(bkt storage.Bucket) error {
+		return bkt.ScanWith(opts, func(key, value []byte) error {
+			// Read one key past the page to know if there is another page.
+			if len(kvs) == limit {
+				more = true
+				return storage.ErrStop
+			}
+			kvs = append(kvs, KeyValue{Key: string(key), Value: append([]byte{}, value...)})
+			return nil
+		})
 	})
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	return nil
+	next := ""
+	if more {
+		next = kvs[len(kvs)-1].Key
+	}
+
+	return kvs, next, nil
 }
 
-// Update stores the value at the provided key
-func (s *Store) Update(key string, value []byte) error {
-	err := s.db.Update(func(tx *bolt.Tx) error {
+// ForEach calls forEachFunc for each key in the plugin's bucket.
+//
+// Deprecated: Use Range. The bolt bucket is nil unless the Bot is using bolt storage.
+func (s *Store) ForEach(forEachFunc func(bucket *bolt.Bucket, key string, value []byte) error) error {
+	boltBackend, ok := s.backend.(*storage.Bolt)
+	if !ok {
+		return s.Range("", func(key string, value []byte) error {
+			return forEachFunc(nil, key, value)
+		})
+	}
+
+	err := boltBackend.DB().Update(func(tx *bolt.Tx) error {
 		rootBkt := tx.Bucket([]byte("plugins"))
 
 		pluginBkt := rootBkt.Bucket([]byte(s.pluginId))
-
-		err := pluginBkt.Put([]byte(key), value)
+		err := pluginBkt.ForEach(func(k []byte, v []byte) error {
+			return forEachFunc(pluginBkt, string(k), v)
+		})
 		if err != nil {
 			return err
 		}
@@ -51,9 +157,30 @@ func (s *Store) Update(key string, value []byte) error {
 	return nil
 }
 
+// Update stores the value at the provided key
+func (s *Store) Update(key string, value []byte) error {
+	return s.Transaction(func(bkt storage.Bucket) error {
+		return bkt.Put([]byte(key), value)
+	})
+}
+
+// Delete removes the provided key
+func (s *Store) Delete(key string) error {
+	return s.Transaction(func(bkt storage.Bucket) error {
+		return bkt.Delete([]byte(key))
+	})
+}
+
 // UpdateRaw allows you direct access to the database when the simple key value interface doesn't work.
+//
+// Deprecated: Use Transaction. UpdateRaw returns ErrNotBolt unless the Bot is using bolt storage.
 func (s *Store) UpdateRaw(updateFunc func(*bolt.Bucket) error) error {
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	boltBackend, ok := s.backend.(*storage.Bolt)
+	if !ok {
+		return ErrNotBolt
+	}
+
+	err := boltBackend.DB().Update(func(tx *bolt.Tx) error {
 		rootBkt := tx.Bucket([]byte("plugins"))
 
 		pluginBkt := rootBkt.Bucket([]byte(s.pluginId))
@@ -75,68 +202,43 @@ func (s *Store) UpdateRaw(updateFunc func(*bolt.Bucket) error) error {
 // GetAndUpdate retrieves a key from the database and passes its value to the provided updateFunc.
 // This allows you to transform data atomically.
 func (s *Store) GetAndUpdate(key string, updateFunc func([]byte) ([]byte, error)) error {
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	return s.Transaction(func(bkt storage.Bucket) error {
 		stringKey := []byte(key)
-		rootBkt := tx.Bucket([]byte("plugins"))
 
-		pluginBkt := rootBkt.Bucket([]byte(s.pluginId))
-
-		val := pluginBkt.Get(stringKey)
+		val, err := bkt.Get(stringKey)
+		if err != nil {
+			return err
+		}
 		updateVal, err := updateFunc(val)
 		if err != nil {
 			return err
 		}
 
 		if updateVal != nil {
-			err = pluginBkt.Put(stringKey, updateVal)
+			err = bkt.Put(stringKey, updateVal)
 			if err != nil {
 				return err
 			}
 		}
 		return nil
 	})
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 // Get retrieves a key from the database and passes it to the provided getFunc
 func (s *Store) Get(key string, getFunc func([]byte) error) error {
-	err := s.db.View(func(tx *bolt.Tx) error {
-		stringKey := []byte(key)
-		rootBkt := tx.Bucket([]byte("plugins"))
-
-		pluginBkt := rootBkt.Bucket([]byte(s.pluginId))
-
-		val := pluginBkt.Get(stringKey)
+	return s.View(func(bkt storage.Bucket) error {
+		val, err := bkt.Get([]byte(key))
+		if err != nil {
+			return err
+		}
 		return getFunc(val)
 	})
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 // InitPluginBucket initializes the database bucket for the given pluginId
 func (b *Bot) InitPluginBucket(pluginId string) error {
-	err := b.db.Update(func(tx *bolt.Tx) error {
-		rootBkt, err := tx.CreateBucketIfNotExists([]byte("plugins"))
-		if err != nil {
-			return err
-		}
-
-		_, err = rootBkt.CreateBucketIfNotExists([]byte(pluginId))
-		if err != nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
+	return b.storage.Update(func(tx storage.Tx) error {
+		_, err := tx.Bucket(pluginId)
 		return err
-	}
-
-	return nil
+	})
 }
@@ -0,0 +1,115 @@
+package quadlek
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+// DecodeError is returned by the protobuf helpers when a stored value can't be unmarshalled.
+type DecodeError struct {
+	PluginId string
+	Key      string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("error decoding %s in the %s store: %v", e.Key, e.PluginId, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// withPluginId fills in the plugin id of a DecodeError returned from a transaction on the store.
+func (s *Store) withPluginId(err error) error {
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) && decodeErr.PluginId == "" {
+		decodeErr.PluginId = s.pluginId
+	}
+
+	return err
+}
+
+// newProto returns a new, empty message of type T.
+func newProto[T proto.Message]() T {
+	var msg T
+	return msg.ProtoReflect().New().Interface().(T)
+}
+
+// GetProto returns the message stored at key. If the key doesn't exist, an empty message is returned.
+func GetProto[T proto.Message](s *Store, key string) (T, error) {
+	var msg T
+	err := s.View(func(bkt storage.Bucket) error {
+		var err error
+		msg, err = GetBucketProto[T](bkt, key)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return msg, nil
+}
+
+// PutProto stores msg at key.
+func PutProto(s *Store, key string, msg proto.Message) error {
+	return s.Transaction(func(bkt storage.Bucket) error {
+		return PutBucketProto(bkt, key, msg)
+	})
+}
+
+// UpdateProto atomically passes the message stored at key to updateFunc, and stores it once updateFunc returns.
+// If the key doesn't exist, updateFunc is passed an empty message. Nothing is stored if updateFunc returns an error.
+func UpdateProto[T proto.Message](s *Store, key string, updateFunc func(msg T) error) error {
+	return s.Transaction(func(bkt storage.Bucket) error {
+		msg, err := GetBucketProto[T](bkt, key)
+		if err != nil {
+			return err
+		}
+
+		err = updateFunc(msg)
+		if err != nil {
+			return err
+		}
+
+		return PutBucketProto(bkt, key, msg)
+	})
+}
+
+// GetBucketProto is GetProto for use with the bucket passed to Store.Transaction or Store.View.
+func GetBucketProto[T proto.Message](bkt storage.Bucket, key string) (T, error) {
+	val, err := bkt.Get([]byte(key))
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return decodeProto[T](key, val)
+}
+
+// decodeProto unmarshals the value stored at key.
+func decodeProto[T proto.Message](key string, val []byte) (T, error) {
+	msg := newProto[T]()
+	err := proto.Unmarshal(val, msg)
+	if err != nil {
+		var zero T
+		return zero, &DecodeError{Key: key, Err: err}
+	}
+
+	return msg, nil
+}
+
+// PutBucketProto is PutProto for use with the bucket passed to Store.Transaction.
+func PutBucketProto(bkt storage.Bucket, key string, msg proto.Message) error {
+	val, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return bkt.Put([]byte(key), val)
+}
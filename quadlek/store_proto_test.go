@@ -0,0 +1,61 @@
+package quadlek
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/jirwin/quadlek/pb/quadlek/plugins/comics/v1"
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestProtoHelpers(t *testing.T) {
+	store := &Store{backend: storage.NewMemory(), pluginId: "comics"}
+
+	templates, err := GetProto[*v1.Templates](store, "templates")
+	require.NoError(t, err)
+	require.NotNil(t, templates)
+	require.Empty(t, templates.Urls)
+
+	err = PutProto(store, "templates", &v1.Templates{Urls: []string{"a"}})
+	require.NoError(t, err)
+
+	err = UpdateProto(store, "templates", func(templates *v1.Templates) error {
+		templates.Urls = append(templates.Urls, "b")
+		return nil
+	})
+	require.NoError(t, err)
+
+	templates, err = GetProto[*v1.Templates](store, "templates")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, templates.Urls)
+
+	failed := errors.New("failed")
+	err = UpdateProto(store, "templates", func(templates *v1.Templates) error {
+		templates.Urls = nil
+		return failed
+	})
+	require.ErrorIs(t, err, failed)
+
+	templates, err = GetProto[*v1.Templates](store, "templates")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, templates.Urls)
+}
+
+func TestProtoHelpersDecodeError(t *testing.T) {
+	store := &Store{backend: storage.NewMemory(), pluginId: "comics"}
+	require.NoError(t, store.Update("templates", []byte{0xff}))
+
+	_, err := GetProto[*v1.Templates](store, "templates")
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, "comics", decodeErr.PluginId)
+	require.Equal(t, "templates", decodeErr.Key)
+
+	err = UpdateProto(store, "templates", func(templates *v1.Templates) error {
+		t.Fatal("updateFunc called for an undecodable value")
+		return nil
+	})
+	require.ErrorAs(t, err, &decodeErr)
+}
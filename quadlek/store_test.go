@@ -0,0 +1,58 @@
+package quadlek
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestStoreScan(t *testing.T) {
+	b := &Bot{storage: storage.NewMemory()}
+	store := b.getStore("test")
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.Update(fmt.Sprintf("alias:%d", i), []byte{byte(i)}))
+	}
+	require.NoError(t, store.Update("other", []byte("x")))
+	require.NoError(t, store.PutWithTTL("alias:9", []byte("expired"), -time.Second))
+
+	keys := func(kvs []KeyValue) []string {
+		var keys []string
+		for _, kv := range kvs {
+			keys = append(keys, kv.Key)
+		}
+		return keys
+	}
+
+	kvs, next, err := store.Scan("alias:", "", 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alias:0", "alias:1"}, keys(kvs))
+	require.Equal(t, []byte{1}, kvs[1].Value)
+	require.Equal(t, "alias:1", next)
+
+	kvs, next, err = store.Scan("alias:", next, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alias:2", "alias:3"}, keys(kvs))
+
+	kvs, next, err = store.Scan("alias:", next, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alias:4"}, keys(kvs))
+	require.Empty(t, next)
+
+	kvs, next, err = store.ScanReverse("alias:", "", 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alias:4", "alias:3", "alias:2"}, keys(kvs))
+	require.Equal(t, "alias:2", next)
+
+	kvs, next, err = store.ScanReverse("alias:", next, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alias:1", "alias:0"}, keys(kvs))
+	require.Empty(t, next)
+
+	_, _, err = store.Scan("alias:", "", 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,208 @@
+package quadlek
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	// degradedPanics is the number of panics within degradedWindow after which a plugin is marked as degraded.
+	degradedPanics = 5
+	degradedWindow = 10 * time.Minute
+	// healthyRun is how long a runner must run without panicking for its restart backoff to be reset.
+	healthyRun = time.Minute
+)
+
+// restartBackoff is how long the supervisor first waits before restarting a runner.
+var restartBackoff = time.Second
+
+// PluginStatus describes the health of a plugin's commands, hooks, reaction hooks, webhooks and interactions.
+type PluginStatus struct {
+	PluginId string
+	// Degraded is true if the plugin's runners have panicked degradedPanics times within degradedWindow. It is cleared
+	// once fewer of its panics are that recent. Degraded plugins still receive messages, their runners are restarted
+	// with a backoff, so the flag is only reported to admins.
+	Degraded bool
+	// Panics is the total number of times the plugin's runners have panicked.
+	Panics        int
+	LastPanic     string
+	LastPanicTime time.Time
+	// recentPanics holds the times of the panics within degradedWindow.
+	recentPanics []time.Time
+}
+
+// prune forgets the panics older than degradedWindow, and clears Degraded if too few are left.
+func (status *PluginStatus) prune(now time.Time) {
+	recent := status.recentPanics[:0]
+	for _, t := range status.recentPanics {
+		if now.Sub(t) < degradedWindow {
+			recent = append(recent, t)
+		}
+	}
+	status.recentPanics = recent
+
+	if len(status.recentPanics) < degradedPanics {
+		status.Degraded = false
+	}
+}
+
+// supervisor tracks the health of every plugin.
+type supervisor struct {
+	mtx      sync.Mutex
+	statuses map[string]*PluginStatus
+	now      func() time.Time
+}
+
+func (s *supervisor) time() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+
+	return s.now()
+}
+
+// recordPanic records a panic for pluginId. It returns true if this panic caused the plugin to become degraded.
+func (s *supervisor) recordPanic(pluginId string, recovered interface{}) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.statuses == nil {
+		s.statuses = make(map[string]*PluginStatus)
+	}
+	status, ok := s.statuses[pluginId]
+	if !ok {
+		status = &PluginStatus{PluginId: pluginId}
+		s.statuses[pluginId] = status
+	}
+
+	now := s.time()
+	status.Panics++
+	status.LastPanic = fmt.Sprint(recovered)
+	status.LastPanicTime = now
+
+	status.prune(now)
+	status.recentPanics = append(status.recentPanics, now)
+
+	if !status.Degraded && len(status.recentPanics) >= degradedPanics {
+		status.Degraded = true
+		return true
+	}
+
+	return false
+}
+
+// PluginStatuses returns the health of every plugin that has panicked, sorted by plugin id.
+func (b *Bot) PluginStatuses() []PluginStatus {
+	b.supervisor.mtx.Lock()
+	defer b.supervisor.mtx.Unlock()
+
+	now := b.supervisor.time()
+	statuses := make([]PluginStatus, 0, len(b.supervisor.statuses))
+	for _, status := range b.supervisor.statuses {
+		status.prune(now)
+		s := *status
+		s.recentPanics = nil
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].PluginId < statuses[j].PluginId
+	})
+
+	return statuses
+}
+
+// supervise runs a plugin's runner until the Bot is stopped.
+// If the runner panics, the panic is logged along with the stack and the runner is restarted with a backoff.
+// Runners are expected to run until ctx is cancelled, so if restartOnReturn is true a runner that returns early is
+// logged and restarted the same way. Scheduled tasks return once they are done, so they aren't restarted.
+func (b *Bot) supervise(pluginId, kind, name string, restartOnReturn bool, run func(ctx context.Context)) {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = restartBackoff
+	bo.MaxInterval = time.Minute
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+
+	for {
+		started := time.Now()
+		recovered, stack := runRecovered(b.ctx, run)
+		if b.ctx.Err() != nil {
+			return
+		}
+
+		if recovered != nil {
+			b.reportPanic(pluginId, kind, name, recovered, stack)
+		} else if restartOnReturn {
+			b.Log.Warn("plugin runner returned while the bot is running",
+				zap.String("plugin", pluginId),
+				zap.String("kind", kind),
+				zap.String("name", name),
+			)
+		} else {
+			return
+		}
+
+		if time.Since(started) > healthyRun {
+			bo.Reset()
+		}
+
+		select {
+		case <-time.After(bo.NextBackOff()):
+			b.Log.Info("restarting plugin", zap.String("plugin", pluginId), zap.String("kind", kind), zap.String("name", name))
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// reportPanic logs a panic in a plugin along with its stack, and lets the admins know if the plugin has become degraded.
+func (b *Bot) reportPanic(pluginId, kind, name string, recovered interface{}, stack []byte) {
+	b.Log.Error("plugin panicked",
+		zap.String("plugin", pluginId),
+		zap.String("kind", kind),
+		zap.String("name", name),
+		zap.Any("panic", recovered),
+		zap.ByteString("stack", stack),
+	)
+
+	if b.supervisor.recordPanic(pluginId, recovered) {
+		b.Log.Error("plugin is degraded", zap.String("plugin", pluginId))
+		b.notifyAdmins(fmt.Sprintf("The %s plugin is degraded, it has panicked %d times in the last %s. The last panic was in %s %s: %v",
+			pluginId, degradedPanics, degradedWindow, kind, name, recovered))
+	}
+}
+
+// runRecovered calls run, and returns the recovered value and stack if it panicked.
+func runRecovered(ctx context.Context, run func(ctx context.Context)) (recovered interface{}, stack []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			recovered = r
+			stack = debug.Stack()
+		}
+	}()
+
+	run(ctx)
+
+	return nil, nil
+}
+
+// notifyAdmins posts a message to the configured admin channel, if there is one.
+func (b *Bot) notifyAdmins(msg string) {
+	if b.config.AdminChannel == "" {
+		return
+	}
+
+	chanId, err := b.GetChannelId(b.config.AdminChannel)
+	if err != nil {
+		b.Log.Error("unable to find admin channel", zap.String("channel", b.config.AdminChannel), zap.Error(err))
+		return
+	}
+
+	b.Say(chanId, msg)
+}
@@ -0,0 +1,85 @@
+package quadlek
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSupervisorDegraded(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := &Bot{}
+	b.supervisor.now = func() time.Time { return now }
+
+	for i := 1; i < degradedPanics; i++ {
+		require.False(t, b.supervisor.recordPanic("gifs", "boom"))
+	}
+	require.True(t, b.supervisor.recordPanic("gifs", "boom"))
+	require.False(t, b.supervisor.recordPanic("gifs", "boom"))
+	require.True(t, b.PluginStatuses()[0].Degraded)
+
+	// The plugin recovers once its panics are no longer recent, and can become degraded again.
+	now = now.Add(degradedWindow)
+	statuses := b.PluginStatuses()
+	require.False(t, statuses[0].Degraded)
+	require.Equal(t, degradedPanics+1, statuses[0].Panics)
+
+	for i := 1; i < degradedPanics; i++ {
+		require.False(t, b.supervisor.recordPanic("gifs", "boom"))
+	}
+	require.True(t, b.supervisor.recordPanic("gifs", "boom"))
+}
+
+func TestSupervisorRestarts(t *testing.T) {
+	orig := restartBackoff
+	restartBackoff = time.Millisecond
+	defer func() { restartBackoff = orig }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &Bot{ctx: ctx, Log: zap.NewNop()}
+
+	// The runner panics, then returns early, and is restarted after both.
+	runs := 0
+	running := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.supervise("gifs", "command", "gif", true, func(ctx context.Context) {
+			runs++
+			switch runs {
+			case 1:
+				panic("boom")
+			case 2:
+				return
+			}
+			close(running)
+			<-ctx.Done()
+		})
+	}()
+
+	select {
+	case <-running:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runner wasn't restarted")
+	}
+	statuses := b.PluginStatuses()
+	require.Len(t, statuses, 1)
+	require.Equal(t, 1, statuses[0].Panics)
+	require.Equal(t, "boom", statuses[0].LastPanic)
+
+	cancel()
+	<-done
+	require.Equal(t, 3, runs)
+
+	// Scheduled tasks aren't restarted once they return.
+	b.ctx = context.Background()
+	runs = 0
+	b.supervise("gifs", "scheduled_task", "cleanup", false, func(ctx context.Context) {
+		runs++
+	})
+	require.Equal(t, 1, runs)
+}
@@ -0,0 +1,190 @@
+package quadlek
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/slack-go/slack/socketmode"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/jirwin/quadlek/quadlek"
+
+// TracingConfig configures OpenTelemetry tracing of the requests the Bot handles.
+type TracingConfig struct {
+	// Exporter is where spans are sent: otlp to export them to a collector with OTLP over HTTP,
+	// or stdout to print them for local debugging. Tracing is disabled if it is empty.
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the host:port of the OTLP collector. Defaults to the standard OTEL_EXPORTER_OTLP_ENDPOINT
+	// environment variable, or localhost:4318.
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	// Insecure connects to the OTLP collector without TLS.
+	Insecure bool `yaml:"insecure" toml:"insecure"`
+	// SampleRatio is the fraction of traces that are sampled, between 0 and 1. Defaults to 1 if it is zero.
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+	// ServiceName is the name the Bot's spans are reported under. Defaults to quadlek.
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+}
+
+// validate checks that the tracing config is usable.
+func (cfg TracingConfig) validate() error {
+	switch cfg.Exporter {
+	case "", "otlp", "stdout":
+	default:
+		return fmt.Errorf("unknown tracing exporter %q, must be otlp or stdout", cfg.Exporter)
+	}
+
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return fmt.Errorf("the tracing sample ratio must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// tracerProvider returns the TracerProvider for the Bot, and a function to flush and shut it down if the Bot owns it.
+// Unless one was provided or tracing is configured, the global TracerProvider is used.
+func (cfg *Config) tracerProvider(ctx context.Context) (trace.TracerProvider, func(context.Context) error, error) {
+	if cfg.TracerProvider != nil {
+		return cfg.TracerProvider, nil, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Tracing.Exporter {
+	case "":
+		return otel.GetTracerProvider(), nil, nil
+
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Tracing.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Tracing.Endpoint))
+		}
+		if cfg.Tracing.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serviceName := cfg.Tracing.ServiceName
+	if serviceName == "" {
+		serviceName = "quadlek"
+	}
+	ratio := cfg.Tracing.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String(cfg.Version),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tp, tp.Shutdown, nil
+}
+
+// Tracer returns the tracer plugins can use to trace their own work, e.g. with the context of a CommandMsg:
+//
+//	ctx, span := cmdMsg.Bot.Tracer().Start(cmdMsg.Context(), "render")
+//	err := render(ctx)
+//	quadlek.EndSpan(span, err)
+func (b *Bot) Tracer() trace.Tracer {
+	if b.tracerProvider == nil {
+		return otel.Tracer(tracerName)
+	}
+
+	return b.tracerProvider.Tracer(tracerName)
+}
+
+// HttpClient returns an http.Client for plugins to call other services with. Requests made with a context
+// from a message, e.g. CommandMsg.Context, are traced as part of handling the message.
+func (b *Bot) HttpClient() *http.Client {
+	if b.pluginClient == nil {
+		return http.DefaultClient
+	}
+
+	return b.pluginClient
+}
+
+// traceHandler traces the requests served by h as operation.
+func (b *Bot) traceHandler(operation string, h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, operation, otelhttp.WithTracerProvider(b.tracerProvider))
+}
+
+// traceTransport traces the requests made with next. name names the span for a request.
+func traceTransport(tp trace.TracerProvider, next http.RoundTripper, name func(r *http.Request) string) http.RoundTripper {
+	opts := []otelhttp.Option{otelhttp.WithTracerProvider(tp)}
+	if name != nil {
+		opts = append(opts, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return name(r)
+		}))
+	}
+
+	return otelhttp.NewTransport(next, opts...)
+}
+
+// startSocketModeSpan starts the span for an envelope received over socket mode, which plays the part of the
+// request's span for the webhook server.
+func (b *Bot) startSocketModeSpan(req *socketmode.Request) (context.Context, trace.Span) {
+	return b.Tracer().Start(context.Background(), "socketmode "+req.Type, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// traceContext returns a context that only carries the trace of ctx. Messages are handled after the request
+// they came in on has been answered, so their context mustn't be cancelled along with the request.
+func traceContext(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
+
+// msgContext returns a message's context, or the background context for messages the Bot didn't dispatch.
+func msgContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+
+	return ctx
+}
+
+// startHandlerSpan starts the span for a plugin handler. ctx is the handler's context, and parent the context of
+// the message it is handling.
+func (b *Bot) startHandlerSpan(ctx, parent context.Context, pluginId, kind, name string) (context.Context, trace.Span) {
+	ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(parent))
+
+	return b.Tracer().Start(ctx, strings.TrimSpace(kind+" "+name), trace.WithAttributes(
+		attribute.String("quadlek.plugin", pluginId),
+		attribute.String("quadlek.handler.kind", kind),
+		attribute.String("quadlek.handler.name", name),
+	))
+}
+
+// EndSpan records err on span, if there is one, and ends it. Plugins can use it to end the spans they start with Tracer.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
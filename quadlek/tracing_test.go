@@ -0,0 +1,79 @@
+package quadlek
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+
+	"github.com/jirwin/quadlek/quadlek/storage"
+)
+
+func TestTracing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	b, err := NewBot(context.Background(),
+		WithApiKey("xoxb-test"),
+		WithSigningSecret("secret"),
+		WithApiUrl(srv.URL+"/api/"),
+		WithStorage(storage.NewMemory()),
+		WithLogger(zap.NewNop()),
+		WithListenAddr("127.0.0.1:0"),
+		WithTracerProvider(tp),
+	)
+	require.NoError(t, err)
+	defer b.Stop()
+
+	err = b.RegisterPlugin(MakePlugin("trace", []Command{
+		CommandFunc("whoami", func(ctx context.Context, cmdMsg *CommandMsg) (*CommandResp, error) {
+			cmdMsg.Bot.ReactContext(ctx, &slack.Msg{Channel: cmdMsg.Command.ChannelId, Timestamp: "1"}, "wave")
+
+			return &CommandResp{Text: "quadlek"}, nil
+		}),
+	}, nil, nil, nil, nil))
+	require.NoError(t, err)
+	b.Start()
+
+	body := url.Values{"command": {"/whoami"}, "channel_id": {"CGENERAL"}, "user_id": {"UJOHN"}}.Encode()
+	r := signedRequest("/slack/command", body, "secret", time.Now())
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	b.Handler().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "quadlek")
+
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	require.Eventually(t, func() bool {
+		for _, span := range spans.Ended() {
+			byName[span.Name()] = span
+		}
+		return byName["slack.command"] != nil && byName["command whoami"] != nil && byName["slack reactions.add"] != nil
+	}, time.Second, 10*time.Millisecond)
+
+	request, handler, api := byName["slack.command"], byName["command whoami"], byName["slack reactions.add"]
+	require.Equal(t, request.SpanContext().TraceID(), handler.SpanContext().TraceID())
+	require.Equal(t, request.SpanContext().SpanID(), handler.Parent().SpanID())
+	require.Equal(t, handler.SpanContext().SpanID(), api.Parent().SpanID())
+}
+
+func TestTracingConfig(t *testing.T) {
+	require.NoError(t, TracingConfig{}.validate())
+	require.NoError(t, TracingConfig{Exporter: "otlp", SampleRatio: 0.5}.validate())
+	require.Error(t, TracingConfig{Exporter: "jaeger"}.validate())
+	require.Error(t, TracingConfig{Exporter: "stdout", SampleRatio: 2}.validate())
+}
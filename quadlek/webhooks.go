@@ -3,9 +3,6 @@ package quadlek
 import (
 	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,6 +16,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var decoder = schema.NewDecoder()
@@ -43,6 +41,10 @@ type slashCommand struct {
 	Text         string            `schema:"text"`
 	ResponseUrl  string            `schema:"response_url"`
 	responseChan chan *CommandResp `schema:"-"`
+	// ctx carries the trace of the request the command came in on.
+	ctx context.Context `schema:"-"`
+	// expired is closed once the Bot stops waiting on responseChan.
+	expired chan struct{} `schema:"-"`
 }
 
 // Reply returns the channel to write command responses to.
@@ -73,22 +75,17 @@ func generateErrorMsg(w http.ResponseWriter, msg string) {
 	jsonResponse(w, resp)
 }
 
-// handleSlackCommand is an http handler that parses an incoming slash command webhook
-// and dispatches it to the proper plugin.
-// It attempts to handle responding to the request if the plugin doesn't respond in time.
+// handleSlackCommand is an http handler that parses an incoming slash command webhook, whose signature has
+// already been verified by the middleware in front of it, and dispatches it to the proper plugin.
+// If the plugin doesn't reply in time the request is answered without a response.
 func (b *Bot) handleSlackCommand(w http.ResponseWriter, r *http.Request) {
-	err := b.ValidateSlackRequest(r)
-	if err != nil {
-		b.Log.Error("failed validating request signature")
-		generateErrorMsg(w, "Sorry. I was unable to complete your request. :cry:")
-		return
-	}
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		b.Log.Error("error parsing form. Invalid slack command hook.", zap.Error(err))
 		generateErrorMsg(w, "Sorry. I was unable to complete your request. :cry:")
 		return
 	}
+	b.recordForm(RecordedCommand, r.PostForm)
 
 	cmd := &slashCommand{}
 	decoder.IgnoreUnknownKeys(true)
@@ -98,53 +95,69 @@ func (b *Bot) handleSlackCommand(w http.ResponseWriter, r *http.Request) {
 		generateErrorMsg(w, "Sorry. I was unable to complete your request. :cry:")
 		return
 	}
+	cmd.ctx = traceContext(r.Context())
+
+	resp, replied := b.runSlashCommand(cmd)
+	// Got a nil response, so the plugin is explicitly not sending a response here and will send one manually.
+	if !replied || resp == nil {
+		ok(w)
+		return
+	}
 
+	prepareSlashCommandResp(resp)
+	jsonResponse(w, resp)
+}
+
+// runSlashCommand hands a slash command to the event loop and waits for the plugin to reply.
+// Slack expects an answer within 3 seconds, so if the plugin hasn't replied by then false is returned
+// and the plugin is expected to use the command's response url instead.
+func (b *Bot) runSlashCommand(cmd *slashCommand) (*CommandResp, bool) {
 	respChan := make(chan *CommandResp)
 	cmd.responseChan = respChan
+	cmd.expired = make(chan struct{})
+	defer close(cmd.expired)
 	b.cmdChannel <- cmd
 
 	timer := time.NewTimer(time.Millisecond * 2500)
-	for {
-		select {
-		case resp := <-respChan:
-			if timer.Stop() {
-				// Got a nil response, so the plugin is explicitly not sending a response here and will send one manually.
-				if resp == nil {
-					w.WriteHeader(http.StatusOK)
-					_, _ = w.Write([]byte{})
-					return
-				}
-
-				prepareSlashCommandResp(resp)
-				jsonResponse(w, resp)
-			} else {
-				<-timer.C
-				_ = b.RespondToSlashCommand(cmd.ResponseUrl, resp)
-			}
-			return
+	defer timer.Stop()
 
-		case <-timer.C:
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte{})
-			return
-		}
+	select {
+	case resp := <-respChan:
+		return resp, true
+
+	case <-timer.C:
+		return nil, false
 	}
 }
 
+// respondToCommand replies to a slash command. If the Bot is still waiting for the reply it is returned inline,
+// otherwise it is sent to the command's response url. Unlike writing to Reply(), this never blocks forever
+// when the plugin replies too late.
+func (b *Bot) respondToCommand(cmd *slashCommand, resp *CommandResp) error {
+	select {
+	case cmd.responseChan <- resp:
+		return nil
+	case <-cmd.expired:
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	if cmd.ResponseUrl == "" {
+		return errors.New("command expired before it was responded to")
+	}
+
+	return b.RespondToSlashCommandContext(msgContext(cmd.ctx), cmd.ResponseUrl, resp)
+}
+
 func ok(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte{})
 }
 
 func (b *Bot) handleSlackInteraction(w http.ResponseWriter, r *http.Request) {
-	err := b.ValidateSlackRequest(r)
-	if err != nil {
-		b.Log.Error("failed validating request signature")
-		generateErrorMsg(w, "Sorry. I was unable to complete your request. :cry:")
-		return
-	}
-
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		fmt.Println("error: ", err.Error())
 		ok(w)
@@ -166,10 +179,14 @@ func (b *Bot) handleSlackInteraction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	b.interactionChannel <- ev
+	b.record(RecordedInteraction, []byte(r.Form.Get("payload")))
+	b.interactionChannel <- &interactionRequest{ctx: traceContext(r.Context()), cb: ev}
 	ok(w)
 }
 
+// webhookTimeout is how long a plugin webhook request waits for the plugin to handle it.
+var webhookTimeout = time.Second * 5
+
 // handlePluginWebhook is an http handler that dispatches custom webhooks to the appropriate plugin
 func (b *Bot) handlePluginWebhook(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -179,6 +196,31 @@ func (b *Bot) handlePluginWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	opts := getHandlerOptions(wh.Webhook)
+	if opts.verifier != nil {
+		err := opts.verifier.Verify(r)
+		if err != nil {
+			b.Log.Warn("rejected webhook", zap.String("webhook", wh.Webhook.GetName()), zap.Error(err))
+			http.Error(w, "invalid request signature", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var userId string
+	if opts.webhookUser != nil {
+		var err error
+		userId, err = webhookUserId(r, opts.webhookUser)
+		if err != nil {
+			b.Log.Warn("unable to identify webhook user", zap.String("webhook", wh.Webhook.GetName()), zap.Error(err))
+			http.Error(w, "unable to identify user", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(opts.roles) > 0 && !b.authorize(userId, opts.roles) {
+		http.Error(w, denialText(opts.roles, "the "+wh.Webhook.GetName()+" webhook"), http.StatusForbidden)
+		return
+	}
+
 	done := make(chan bool, 1)
 	msg := &WebhookMsg{
 		Bot:            b,
@@ -186,32 +228,76 @@ func (b *Bot) handlePluginWebhook(w http.ResponseWriter, r *http.Request) {
 		ResponseWriter: w,
 		Store:          b.getStore(wh.PluginId),
 		Done:           done,
+		UserId:         userId,
+		ctx:            traceContext(r.Context()),
+		expired:        make(chan struct{}),
+		dropped:        make(chan struct{}),
+	}
+	// The ResponseWriter is only valid until we return, so a webhook that is still queued must not be delivered after that.
+	defer close(msg.expired)
+
+	if !wh.queue.push(msg) {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
 	}
-	wh.Webhook.Channel() <- msg
 
 	select {
 	case <-done:
-	case <-time.After(time.Second * 5):
+	case <-msg.dropped:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	case <-time.After(webhookTimeout):
 		b.Log.Info("Webhook timed out.")
 	}
 }
 
+// webhookUserId calls userId to find the user a webhook request was made for, and restores the body it reads.
+func webhookUserId(r *http.Request, userId func(r *http.Request) (string, error)) (string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	defer func() {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}()
+
+	return userId(r)
+}
+
+// Handler returns the http.Handler that serves Slack's requests and plugin webhooks.
+// WebhookServer serves it on the configured address, but it can also be mounted in another server.
+func (b *Bot) Handler() http.Handler {
+	r := mux.NewRouter()
+	r.Handle("/slack/plugin/{webhook-name}", b.traceHandler("plugin.webhook", http.HandlerFunc(b.handlePluginWebhook))).Methods("GET", "POST", "DELETE", "PUT")
+	if b.metrics != nil && !b.config.DisableMetrics {
+		r.Handle("/metrics", promhttp.HandlerFor(b.metrics.registry, promhttp.HandlerOpts{})).Methods("GET")
+	}
+	// In socket mode Slack delivers events, commands and interactions over the websocket instead.
+	if b.config.AppToken == "" {
+		verifier := b.slackVerifier()
+		r.Handle("/slack/command", b.traceHandler("slack.command", verifier.Middleware(b.Log, http.HandlerFunc(b.handleSlackCommand)))).Methods("POST")
+		r.Handle("/slack/interaction", b.traceHandler("slack.interaction", verifier.Middleware(b.Log, http.HandlerFunc(b.handleSlackInteraction)))).Methods("POST")
+		r.Handle("/slack/event", b.traceHandler("slack.event", verifier.Middleware(b.Log, http.HandlerFunc(b.handleSlackEvent)))).Methods("POST")
+	}
+
+	return r
+}
+
 // WebhookServer starts a new http server that listens and responds to incoming webhooks.
 // The Slack API uses webhooks for processing slash commands, and this server is used to respond to them.
 // Plugins can also register custom webhooks that can be used however they choose. An example of this would be
 // to process oauth2 callbacks to facilitate oauth2 flows for associating a user's slack account with an external service.
 func (b *Bot) WebhookServer() {
-	r := mux.NewRouter()
-	r.HandleFunc("/slack/command", b.handleSlackCommand).Methods("POST")
-	r.HandleFunc("/slack/plugin/{webhook-name}", b.handlePluginWebhook).Methods("GET", "POST", "DELETE", "PUT")
-	r.HandleFunc("/slack/interaction", b.handleSlackInteraction).Methods("POST")
-	r.HandleFunc("/slack/event", b.handleSlackEvent).Methods("POST")
-
-	// TODO(jirwin): This listen address should be configurable
-	srv := &http.Server{Addr: ":8000", Handler: r}
+	srv := &http.Server{Addr: b.config.ListenAddr, Handler: b.Handler()}
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
+		var err error
+		if b.config.TLSCertFile != "" {
+			err = srv.ListenAndServeTLS(b.config.TLSCertFile, b.config.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			b.Log.Error("listen err", zap.Error(err))
 		}
 	}()
@@ -227,30 +313,3 @@ func (b *Bot) WebhookServer() {
 }
 
 var InvalidRequestSignature = errors.New("invalid request signature")
-
-// Validates the signature header for slack webhooks
-func (b *Bot) ValidateSlackRequest(r *http.Request) error {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		b.Log.Error("error reading request body")
-		return InvalidRequestSignature
-	}
-
-	rBody := io.NopCloser(bytes.NewBuffer(body))
-	r.Body = rBody
-
-	ts := r.Header.Get("X-Slack-Request-Timestamp")
-	signature := r.Header.Get("X-Slack-Signature")
-
-	msg := fmt.Sprintf("v0:%s:%s", ts, body)
-	key := []byte(b.verificationToken)
-	h := hmac.New(sha256.New, key)
-	h.Write([]byte(msg))
-	checkSign := "v0=" + hex.EncodeToString(h.Sum(nil))
-
-	if signature == checkSign {
-		return nil
-	}
-
-	return InvalidRequestSignature
-}
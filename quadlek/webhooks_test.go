@@ -0,0 +1,86 @@
+package quadlek
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func webhookRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/slack/plugin/deploy", strings.NewReader("{}"))
+	return mux.SetURLVars(r, map[string]string{"webhook-name": "deploy"})
+}
+
+func TestPluginWebhookSlowRunner(t *testing.T) {
+	orig := webhookTimeout
+	webhookTimeout = 20 * time.Millisecond
+	defer func() { webhookTimeout = orig }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runnerChan := make(chan *WebhookMsg)
+	wh := &registeredWebhook{
+		PluginId: "deploys",
+		Webhook:  MakeWebhook("deploy", nil),
+		queue:    newDispatchQueue[*WebhookMsg](zap.NewNop(), QueueConfig{Size: 1, Policy: DropNewest}, "deploys", "webhook", "deploy", runnerChan),
+	}
+	b := &Bot{
+		Log:      zap.NewNop(),
+		webhooks: map[string]*registeredWebhook{"deploy": wh},
+	}
+	go wh.queue.run(ctx)
+
+	// The runner is busy, so the first request times out while the pump is holding it and the
+	// second times out while it is queued.
+	w := httptest.NewRecorder()
+	b.handlePluginWebhook(w, webhookRequest())
+	require.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	b.handlePluginWebhook(w, webhookRequest())
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Neither reaches the runner once it is free, since their ResponseWriters are gone.
+	select {
+	case msg := <-runnerChan:
+		t.Fatalf("expired webhook was delivered: %v", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.Eventually(t, func() bool {
+		return wh.queue.stats().Dropped == 2
+	}, time.Second, time.Millisecond)
+
+	// A webhook that is handled in time gets its response.
+	go func() {
+		msg := <-runnerChan
+		msg.ResponseWriter.WriteHeader(http.StatusAccepted)
+		msg.Done <- true
+	}()
+	w = httptest.NewRecorder()
+	b.handlePluginWebhook(w, webhookRequest())
+	require.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestPluginWebhookQueueFull(t *testing.T) {
+	wh := &registeredWebhook{
+		PluginId: "deploys",
+		Webhook:  MakeWebhook("deploy", nil),
+		queue:    newDispatchQueue[*WebhookMsg](zap.NewNop(), QueueConfig{Size: 1, Policy: DropNewest}, "deploys", "webhook", "deploy", nil),
+	}
+	wh.queue.push(&WebhookMsg{})
+	b := &Bot{
+		Log:      zap.NewNop(),
+		webhooks: map[string]*registeredWebhook{"deploy": wh},
+	}
+
+	w := httptest.NewRecorder()
+	b.handlePluginWebhook(w, webhookRequest())
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+}